@@ -10,7 +10,9 @@ import (
 // tests only.
 type ResponseRecorder struct {
 	Local, Remote net.IPAddr
-	Msg           *dns.Msg
+	Msg           *dns.Msg   // the last written Msg
+	Msgs          []*dns.Msg // all written Msgs, in order
+	Tsig          error      // the status of the TSIG of the request
 }
 
 // LocalAddr returns the internal Local net.IPAddr.
@@ -19,9 +21,11 @@ func (r ResponseRecorder) LocalAddr() net.Addr { return &r.Local }
 // RemoteAddr returns the internal Remote net.IPAddr
 func (r ResponseRecorder) RemoteAddr() net.Addr { return &r.Remote }
 
-// WriteMsg sets the internal Msg to the given Msg and returns nil.
+// WriteMsg sets the internal Msg to the given Msg, appends it to the
+// internal Msgs and returns nil.
 func (r *ResponseRecorder) WriteMsg(m *dns.Msg) error {
 	r.Msg = m
+	r.Msgs = append(r.Msgs, m)
 	return nil
 }
 
@@ -31,8 +35,8 @@ func (r *ResponseRecorder) Write([]byte) (int, error) { return 0, nil }
 // Close is not implemented.
 func (r *ResponseRecorder) Close() error { return nil }
 
-// TsigStatus is not implemented.
-func (r ResponseRecorder) TsigStatus() error { return nil }
+// TsigStatus returns the internal Tsig error.
+func (r ResponseRecorder) TsigStatus() error { return r.Tsig }
 
 // TsigTimersOnly is not implemented.
 func (r ResponseRecorder) TsigTimersOnly(bool) {}
@@ -0,0 +1,283 @@
+package exchanger
+
+import (
+	"container/list"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// A Cache is a bounded LRU cache of DNS replies. Positive replies are cached
+// for the smallest TTL of their answers and negative ones (NXDOMAIN and
+// NODATA) for the TTL of their SOA record as specified by RFC 2308, both
+// capped at a maximum TTL. Failures, truncated replies and negative replies
+// without a SOA record aren't cached. It's safe for concurrent use.
+type Cache struct {
+	size     int
+	maxTTL   time.Duration
+	prefetch int
+	hits     logging.Counter
+	misses   logging.Counter
+	now      func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[cacheKey]*list.Element
+}
+
+// NewCache returns a Cache holding up to size replies for at most maxTTL.
+// Entries hit at least prefetch times, unless zero, are refreshed in the
+// background shortly before they expire. Cache hits and misses are counted
+// with the given counters.
+func NewCache(size int, maxTTL time.Duration, prefetch int, hits, misses logging.Counter) *Cache {
+	return &Cache{
+		size:     size,
+		maxTTL:   maxTTL,
+		prefetch: prefetch,
+		hits:     hits,
+		misses:   misses,
+		now:      time.Now,
+		lru:      list.New(),
+		entries:  make(map[cacheKey]*list.Element, size),
+	}
+}
+
+// A cacheKey identifies the replies which can be reused for a request. The
+// RD, CD and DO bits, as well as the use of EDNS0 and the source prefix of its
+// client subnet option (RFC 7871), are part of it since they change the
+// replies of upstreams.
+type cacheKey struct {
+	name       string
+	qtype      uint16
+	qclass     uint16
+	rd, cd, do bool
+	edns       bool
+	subnet     string
+}
+
+// key returns the cacheKey of the given request and whether it can be cached
+// at all, i.e. it has a single question.
+func key(m *dns.Msg) (cacheKey, bool) {
+	if len(m.Question) != 1 {
+		return cacheKey{}, false
+	}
+	q := m.Question[0]
+	k := cacheKey{
+		name:   strings.ToLower(q.Name),
+		qtype:  q.Qtype,
+		qclass: q.Qclass,
+		rd:     m.RecursionDesired,
+		cd:     m.CheckingDisabled,
+	}
+	if opt := m.IsEdns0(); opt != nil {
+		k.edns, k.do = true, opt.Do()
+		for _, o := range opt.Option {
+			if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+				k.subnet = subnet(ecs)
+			}
+		}
+	}
+	return k, true
+}
+
+// subnet returns the source prefix of the given client subnet option, e.g.
+// "10.1.2.0/24", with the bits of its address beyond it cleared.
+func subnet(ecs *dns.EDNS0_SUBNET) string {
+	bits := 8 * net.IPv6len
+	if ecs.Family == 1 {
+		bits = 8 * net.IPv4len
+	}
+	mask := net.CIDRMask(int(ecs.SourceNetmask), bits)
+	return (&net.IPNet{IP: ecs.Address.Mask(mask), Mask: mask}).String()
+}
+
+// A cacheEntry is a cached reply.
+type cacheEntry struct {
+	key        cacheKey
+	msg        *dns.Msg
+	stored     time.Time
+	expires    time.Time
+	hits       int
+	prefetched bool
+}
+
+// Caching returns a Decorator which answers requests from the given Cache,
+// only exchanging them with the decorated Exchanger on misses.
+func Caching(c *Cache) Decorator {
+	return func(ex Exchanger) Exchanger {
+		return Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			k, ok := key(m)
+			if !ok {
+				return ex.Exchange(m, a)
+			}
+
+			r, prefetch := c.get(k, m.Id)
+			if r != nil {
+				c.hits.Inc()
+				if prefetch {
+					go c.exchange(ex, k, m.Copy(), a)
+				}
+				return r, 0, nil
+			}
+
+			c.misses.Inc()
+			return c.exchange(ex, k, m, a)
+		})
+	}
+}
+
+// CachingForwarder returns a Forwarder which answers messages from the given
+// Cache, only forwarding them with the given Forwarder on misses. Unlike with
+// Caching Exchangers, cache hits don't involve any upstream, and so aren't
+// part of the statistics of Upstreams.
+func CachingForwarder(c *Cache, fwd Forwarder) Forwarder {
+	// the protocol of messages takes the place of the exchanged address
+	ex := Caching(c)(Func(func(m *dns.Msg, proto string) (*dns.Msg, time.Duration, error) {
+		r, err := fwd(m, proto)
+		return r, 0, err
+	}))
+	return func(m *dns.Msg, proto string) (*dns.Msg, error) {
+		r, _, err := ex.Exchange(m, proto)
+		return r, err
+	}
+}
+
+// exchange exchanges the given request with the given Exchanger, caching its
+// reply under the given key if possible.
+func (c *Cache) exchange(ex Exchanger, k cacheKey, m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+	r, rtt, err := ex.Exchange(m, a)
+	if err == nil && r != nil {
+		c.put(k, r)
+	}
+	return r, rtt, err
+}
+
+// get returns a copy of the reply cached under the given key, with the given
+// ID and TTLs decremented by the time it spent in the cache, if any. It also
+// returns whether the entry should be prefetched.
+func (c *Cache) get(k cacheKey, id uint16) (*dns.Msg, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	now := c.now()
+	if !now.Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	e.hits++
+
+	// prefetch hot entries within the last tenth of their lifetime
+	var prefetch bool
+	if c.prefetch > 0 && e.hits >= c.prefetch && !e.prefetched &&
+		e.expires.Sub(now) <= e.expires.Sub(e.stored)/10 {
+		prefetch, e.prefetched = true, true
+	}
+
+	r := e.msg.Copy()
+	r.Id = id
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+	forEachRR(r, func(rr dns.RR) {
+		if h := rr.Header(); h.Ttl > elapsed {
+			h.Ttl -= elapsed
+		} else {
+			h.Ttl = 0
+		}
+	})
+	return r, prefetch
+}
+
+// put caches the given reply under the given key, evicting the least
+// recently used entry if the Cache is full.
+func (c *Cache) put(k cacheKey, r *dns.Msg) {
+	ttl, ok := c.ttl(r)
+	if !ok {
+		return
+	}
+	r = r.Copy()
+	forEachRR(r, func(rr dns.RR) {
+		if h := rr.Header(); h.Ttl > ttl {
+			h.Ttl = ttl
+		}
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	e := &cacheEntry{
+		key:     k,
+		msg:     r,
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+	if el, ok := c.entries[k]; ok {
+		e.hits = el.Value.(*cacheEntry).hits
+		c.remove(el)
+	}
+	c.entries[k] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes the given element from the Cache. The caller must hold
+// c.mu.
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// ttl returns the number of seconds the given reply can be cached for and
+// whether it can be cached at all.
+func (c *Cache) ttl(r *dns.Msg) (ttl uint32, ok bool) {
+	if r.Truncated {
+		return 0, false
+	}
+
+	switch {
+	case r.Rcode == dns.RcodeSuccess && len(r.Answer) > 0:
+		for _, rr := range r.Answer {
+			if h := rr.Header(); !ok || h.Ttl < ttl {
+				ttl, ok = h.Ttl, true
+			}
+		}
+	case r.Rcode == dns.RcodeSuccess, r.Rcode == dns.RcodeNameError:
+		// See: https://tools.ietf.org/html/rfc2308#section-5
+		for _, rr := range r.Ns {
+			if soa, isSOA := rr.(*dns.SOA); isSOA {
+				ttl, ok = soa.Hdr.Ttl, true
+				if soa.Minttl < ttl {
+					ttl = soa.Minttl
+				}
+				break
+			}
+		}
+	}
+
+	if max := uint32(c.maxTTL / time.Second); ok && ttl > max {
+		ttl = max
+	}
+	return ttl, ok && ttl > 0
+}
+
+// forEachRR calls f with each record of the given message, except OPT
+// records whose TTL field holds flags.
+func forEachRR(m *dns.Msg, f func(dns.RR)) {
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				f(rr)
+			}
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package exchanger
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// clock is a manually advanced clock, safe for concurrent use.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// cached returns a Cache with the given parameters, running on a manual
+// clock, and its hit and miss counters.
+func cached(size int, maxTTL time.Duration, prefetch int) (*Cache, *clock, *logging.LogCounter, *logging.LogCounter) {
+	var hits, misses logging.LogCounter
+	clk := &clock{now: time.Unix(0, 0)}
+	c := NewCache(size, maxTTL, prefetch, &hits, &misses)
+	c.now = clk.Now
+	return c, clk, &hits, &misses
+}
+
+// upstream returns an Exchanger answering A queries with a record of the
+// given TTL, and the channel its requests are sent to.
+func upstream(ttl uint32) (Exchanger, chan *dns.Msg) {
+	reqs := make(chan *dns.Msg, 10)
+	return Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+		reqs <- m
+		r := new(dns.Msg).SetReply(m)
+		r.Answer = append(r.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   []byte{1, 2, 3, 4},
+		})
+		return r, time.Millisecond, nil
+	}), reqs
+}
+
+func TestCaching(t *testing.T) {
+	c, clk, hits, misses := cached(10, time.Hour, 0)
+	up, reqs := upstream(60)
+	ex := Caching(c)(up)
+
+	exchange := func(id uint16) *dns.Msg {
+		m := new(dns.Msg).SetQuestion("Example.COM.", dns.TypeA)
+		m.Id = id
+		r, _, err := ex.Exchange(m, "1.2.3.4:53")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Id != id {
+			t.Fatalf("got ID %d, want %d", r.Id, id)
+		}
+		return r
+	}
+
+	exchange(1)
+	clk.Advance(20 * time.Second)
+	r := exchange(2)
+	if got, want := r.Answer[0].Header().Ttl, uint32(40); got != want {
+		t.Errorf("got TTL %d, want %d", got, want)
+	}
+	if got, want := len(reqs), 1; got != want {
+		t.Errorf("got %d upstream requests, want %d", got, want)
+	}
+
+	clk.Advance(40 * time.Second)
+	exchange(3)
+	if got, want := len(reqs), 2; got != want {
+		t.Errorf("got %d upstream requests after expiry, want %d", got, want)
+	}
+	if got, want := hits.String(), "1"; got != want {
+		t.Errorf("got %s hits, want %s", got, want)
+	}
+	if got, want := misses.String(), "2"; got != want {
+		t.Errorf("got %s misses, want %s", got, want)
+	}
+}
+
+func TestCachingTTL(t *testing.T) {
+	soa := func(ttl, minttl uint32) dns.RR {
+		return &dns.SOA{
+			Hdr:    dns.RR_Header{Name: "com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+			Ns:     "a.gtld-servers.net.",
+			Mbox:   "nstld.verisign-grs.com.",
+			Minttl: minttl,
+		}
+	}
+	a := &dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 7200}}
+
+	for i, tt := range []struct {
+		rcode     int
+		truncated bool
+		answer    []dns.RR
+		ns        []dns.RR
+		ttl       uint32
+		ok        bool
+	}{
+		{dns.RcodeSuccess, false, []dns.RR{a}, nil, 3600, true},            // capped
+		{dns.RcodeSuccess, true, []dns.RR{a}, nil, 0, false},               // truncated
+		{dns.RcodeNameError, false, nil, []dns.RR{soa(900, 30)}, 30, true}, // RFC 2308
+		{dns.RcodeSuccess, false, nil, []dns.RR{soa(20, 300)}, 20, true},   // NODATA
+		{dns.RcodeNameError, false, nil, nil, 0, false},                    // no SOA
+		{dns.RcodeServerFailure, false, nil, []dns.RR{soa(60, 60)}, 0, false},
+		{dns.RcodeNameError, false, nil, []dns.RR{soa(0, 60)}, 0, false},
+	} {
+		c, _, _, _ := cached(10, time.Hour, 0)
+		r := &dns.Msg{Answer: tt.answer, Ns: tt.ns}
+		r.Rcode, r.Truncated = tt.rcode, tt.truncated
+		if ttl, ok := c.ttl(r); ttl != tt.ttl || ok != tt.ok {
+			t.Errorf("test #%d: got (%d, %t), want (%d, %t)", i, ttl, ok, tt.ttl, tt.ok)
+		}
+	}
+}
+
+func TestCachingFailures(t *testing.T) {
+	c, _, _, _ := cached(10, time.Hour, 0)
+	var calls int
+	ex := Caching(c)(Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+		calls++
+		return nil, 0, errors.New("timeout")
+	}))
+	for i := 0; i < 2; i++ {
+		if _, _, err := ex.Exchange(new(dns.Msg).SetQuestion("example.com.", dns.TypeA), ""); err == nil {
+			t.Fatal("got no error")
+		}
+	}
+	if calls != 2 {
+		t.Errorf("got %d upstream requests, want 2", calls)
+	}
+}
+
+func TestCachingKey(t *testing.T) {
+	c, _, _, _ := cached(10, time.Hour, 0)
+	up, reqs := upstream(60)
+	ex := Caching(c)(up)
+
+	plain := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	do := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	do.SetEdns0(4096, true)
+	aaaa := new(dns.Msg).SetQuestion("example.com.", dns.TypeAAAA)
+	subnet := func(addr string) *dns.Msg {
+		m := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+		m.SetEdns0(4096, false)
+		opt := m.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+			Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP(addr).To4()})
+		return m
+	}
+	ecs, other, same := subnet("10.1.2.3"), subnet("10.1.3.3"), subnet("10.1.2.4")
+	for _, m := range []*dns.Msg{plain, do, aaaa, ecs, other, plain, do, aaaa, ecs, same} {
+		if _, _, err := ex.Exchange(m, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := len(reqs), 5; got != want {
+		t.Errorf("got %d upstream requests, want %d", got, want)
+	}
+}
+
+func TestCachingEviction(t *testing.T) {
+	c, _, _, _ := cached(2, time.Hour, 0)
+	up, reqs := upstream(60)
+	ex := Caching(c)(up)
+
+	for _, name := range []string{"a.", "b.", "a.", "c.", "a.", "b."} {
+		if _, _, err := ex.Exchange(new(dns.Msg).SetQuestion(name, dns.TypeA), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// "b." was the least recently used when "c." was cached
+	var got []string
+	for len(reqs) > 0 {
+		got = append(got, (<-reqs).Question[0].Name)
+	}
+	if want := []string{"a.", "b.", "c.", "b."}; !equalNames(got, want) {
+		t.Errorf("got upstream requests %v, want %v", got, want)
+	}
+}
+
+func TestCachingPrefetch(t *testing.T) {
+	c, clk, _, _ := cached(10, time.Hour, 2)
+	up, reqs := upstream(100)
+	ex := Caching(c)(up)
+
+	m := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	exchange := func() {
+		if _, _, err := ex.Exchange(m, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exchange()
+	<-reqs
+	clk.Advance(95 * time.Second)
+	exchange() // first hit, not hot yet
+	exchange() // second hit, within the last tenth of the TTL
+
+	select {
+	case <-reqs:
+	case <-time.After(time.Second):
+		t.Fatal("entry wasn't prefetched")
+	}
+	// the refreshed entry is served once stored
+	for deadline := time.Now().Add(time.Second); ; {
+		r, _ := c.get(cacheKey{name: "example.com.", qtype: dns.TypeA, qclass: dns.ClassINET, rd: true}, 0)
+		if r != nil && r.Answer[0].Header().Ttl == 100 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("got %v, want the prefetched reply", r)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(reqs) != 0 {
+		t.Errorf("got %d more upstream requests, want none", len(reqs))
+	}
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCachingForwarder(t *testing.T) {
+	c, _, hits, _ := cached(10, time.Hour, 0)
+	up, reqs := upstream(60)
+	u := NewUpstreams([]string{"1.2.3.4"}, Sequential, time.Minute)
+	fwd := CachingForwarder(c, u.Forwarder(map[string]Exchanger{"udp": up}))
+
+	for i := 0; i < 2; i++ {
+		r, err := fwd(new(dns.Msg).SetQuestion("example.com.", dns.TypeA), "udp")
+		if err != nil || len(r.Answer) != 1 {
+			t.Fatalf("forward #%d: got %v, %v", i, r, err)
+		}
+	}
+	if len(reqs) != 1 || hits.String() != "1" {
+		t.Errorf("got %d upstream requests and %s hits, want 1 and 1", len(reqs), hits)
+	}
+	// cache hits aren't exchanges with the upstream
+	if got := u.Stats()[0].Exchanges; got != 1 {
+		t.Errorf("got %d exchanges, want 1", got)
+	}
+}
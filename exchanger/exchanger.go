@@ -2,6 +2,7 @@ package exchanger
 
 import (
 	"log"
+	"strings"
 	"time"
 
 	"github.com/mesosphere/mesos-dns/logging"
@@ -66,3 +67,109 @@ func Instrumentation(total, success, failure logging.Counter) Decorator {
 		})
 	}
 }
+
+// TSIGFudge is the number of seconds of clock skew allowed between signers
+// and verifiers of TSIG records.
+const TSIGFudge = 300
+
+// Signing returns a Decorator which signs messages with the TSIG key of the
+// given name and algorithm (RFC 2845), replacing any TSIG record they carry.
+// The signatures are computed, and those of replies verified, by the
+// decorated Exchanger which must know the key's secret, e.g. a dns.Client.
+// Replies must be signed with the same key, or dns.ErrNoSig or dns.ErrSecret
+// is returned, since the decorated Exchanger only verifies the signatures
+// replies carry. Their TSIG records are removed once verified.
+func Signing(name, algorithm string) Decorator {
+	return func(ex Exchanger) Exchanger {
+		return Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			signed := m.Copy()
+			if signed.IsTsig() != nil {
+				signed.Extra = signed.Extra[:len(signed.Extra)-1]
+			}
+			signed.SetTsig(name, algorithm, TSIGFudge, time.Now().Unix())
+
+			r, rtt, err := ex.Exchange(signed, a)
+			if err != nil || r == nil {
+				return r, rtt, err
+			}
+			if err = signedWith(r, name); err != nil {
+				return nil, rtt, err
+			}
+			r.Extra = r.Extra[:len(r.Extra)-1]
+			return r, rtt, nil
+		})
+	}
+}
+
+// signedWith returns nil if the given reply carries a TSIG record of the key
+// of the given name, dns.ErrNoSig if it carries none, and dns.ErrSecret if it
+// carries one of another key.
+func signedWith(r *dns.Msg, name string) error {
+	switch t := r.IsTsig(); {
+	case t == nil:
+		return dns.ErrNoSig
+	case !strings.EqualFold(t.Hdr.Name, name):
+		return dns.ErrSecret
+	}
+	return nil
+}
+
+// EDNS0 returns a Decorator which advertises the given UDP buffer size to
+// upstreams with an EDNS0 OPT record (RFC 6891), so that larger replies
+// aren't truncated. Messages which advertise a smaller one have it raised.
+// The OPT records of replies are removed if the original message had none.
+func EDNS0(size uint16) Decorator {
+	return func(ex Exchanger) Exchanger {
+		return Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			opt := m.IsEdns0()
+			if opt != nil && opt.UDPSize() >= size {
+				return ex.Exchange(m, a)
+			}
+
+			sized := m.Copy()
+			if opt = sized.IsEdns0(); opt != nil {
+				opt.SetUDPSize(size)
+			} else {
+				opt = &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+				opt.SetUDPSize(size)
+				// the TSIG record, if any, must remain the last one
+				n := len(sized.Extra)
+				if sized.IsTsig() != nil {
+					n--
+				}
+				sized.Extra = append(sized.Extra[:n], append([]dns.RR{opt}, sized.Extra[n:]...)...)
+			}
+
+			r, rtt, err := ex.Exchange(sized, a)
+			if err == nil && r != nil && m.IsEdns0() == nil {
+				extra := r.Extra[:0]
+				for _, rr := range r.Extra {
+					if rr.Header().Rrtype != dns.TypeOPT {
+						extra = append(extra, rr)
+					}
+				}
+				r.Extra = extra
+			}
+			return r, rtt, err
+		})
+	}
+}
+
+// TCPRetry returns a Decorator which repeats exchanges whose reply is
+// truncated with the given, usually TCP, Exchanger.
+func TCPRetry(tcp Exchanger) Decorator {
+	return func(ex Exchanger) Exchanger {
+		return Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			r, rtt, err := ex.Exchange(m, a)
+			if err != nil || r == nil || !r.Truncated {
+				return r, rtt, err
+			}
+			rr, tcpRTT, err := tcp.Exchange(m, a)
+			if err != nil {
+				// the truncated reply is better than none
+				return r, rtt, nil
+			}
+			return rr, rtt + tcpRTT, nil
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"log"
+	"reflect"
 	"testing"
 	"time"
 
@@ -60,6 +61,119 @@ func TestInstrumentation(t *testing.T) {
 	}
 }
 
+func TestSigning(t *testing.T) {
+	for i, tt := range []struct {
+		key string // of the reply, if signed
+		err error
+	}{
+		{"upstream.", nil},
+		{"UPSTREAM.", nil},
+		{"", dns.ErrNoSig},
+		{"other.", dns.ErrSecret},
+	} {
+		m := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+		m.SetTsig("client.", dns.HmacMD5, TSIGFudge, 0)
+
+		var sent *dns.Msg
+		ex := Signing("upstream.", dns.HmacSHA256)(Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			sent = m
+			r := new(dns.Msg).SetReply(m)
+			if tt.key != "" {
+				r.SetTsig(tt.key, dns.HmacSHA256, TSIGFudge, 0)
+			}
+			return r, 0, nil
+		}))
+
+		r, _, err := ex.Exchange(m, "1.2.3.4:53")
+		if err != tt.err {
+			t.Errorf("test #%d: got error %v, want %v", i, err, tt.err)
+		}
+		if ts := sent.IsTsig(); ts == nil || ts.Hdr.Name != "upstream." || ts.Algorithm != dns.HmacSHA256 {
+			t.Errorf("test #%d: got TSIG %v, want one of the upstream key", i, ts)
+		}
+		if len(sent.Extra) != 1 {
+			t.Errorf("test #%d: got %d additional records, want the TSIG only", i, len(sent.Extra))
+		}
+		if ts := m.IsTsig(); ts == nil || ts.Hdr.Name != "client." {
+			t.Errorf("test #%d: the original message was modified: %v", i, m)
+		}
+		if r != nil && r.IsTsig() != nil {
+			t.Errorf("test #%d: got TSIG in reply: %v", i, r)
+		}
+	}
+}
+
+func TestEDNS0(t *testing.T) {
+	for i, tt := range []struct {
+		size    uint16 // advertised by the request, if any
+		tsig    bool
+		want    uint16 // advertised to the upstream
+		withOPT bool   // in the reply
+	}{
+		{0, false, 1232, false},
+		{0, true, 1232, false},
+		{512, false, 1232, true},
+		{4096, false, 4096, true},
+	} {
+		m := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+		if tt.size != 0 {
+			m.SetEdns0(tt.size, true)
+		}
+		if tt.tsig {
+			m.SetTsig("client.", dns.HmacSHA256, TSIGFudge, 0)
+		}
+		orig := m.Copy()
+
+		var sent *dns.Msg
+		ex := EDNS0(1232)(Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			sent = m
+			r := new(dns.Msg).SetReply(m)
+			r.SetEdns0(4096, false)
+			return r, 0, nil
+		}))
+		r, _, err := ex.Exchange(m, "1.2.3.4:53")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opt := sent.IsEdns0()
+		if opt == nil || opt.UDPSize() != tt.want {
+			t.Errorf("test #%d: got OPT %v, want UDP size %d", i, opt, tt.want)
+		} else if tt.size != 0 && !opt.Do() {
+			t.Errorf("test #%d: the DO bit of the request was lost", i)
+		}
+		if tt.tsig && sent.IsTsig() == nil {
+			t.Errorf("test #%d: TSIG isn't the last record of %v", i, sent)
+		}
+		if got := r.IsEdns0() != nil; got != tt.withOPT {
+			t.Errorf("test #%d: got OPT in reply %t, want %t", i, got, tt.withOPT)
+		}
+		if m.String() != orig.String() {
+			t.Errorf("test #%d: the original message was modified: %v", i, m)
+		}
+	}
+}
+
+func TestTCPRetry(t *testing.T) {
+	truncated := &dns.Msg{MsgHdr: dns.MsgHdr{Truncated: true}}
+	full := &dns.Msg{}
+	for i, tt := range []struct {
+		udp, tcp exchanged
+		want     exchanged
+	}{
+		{exchanged{m: full, rtt: 1}, exchanged{err: errors.New("unexpected")}, exchanged{m: full, rtt: 1}},
+		{exchanged{err: errors.New("timeout")}, exchanged{m: full}, exchanged{err: errors.New("timeout")}},
+		{exchanged{m: truncated, rtt: 1}, exchanged{m: full, rtt: 2}, exchanged{m: full, rtt: 3}},
+		{exchanged{m: truncated, rtt: 1}, exchanged{err: errors.New("eof")}, exchanged{m: truncated, rtt: 1}},
+	} {
+		var got exchanged
+		got.m, got.rtt, got.err = TCPRetry(stub(tt.tcp))(stub(tt.udp)).Exchange(nil, "1.2.3.4:53")
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got %+v, want %+v", i, got, tt.want)
+		}
+	}
+}
+
 func stubs(ed ...exchanged) []Exchanger {
 	exs := make([]Exchanger, len(ed))
 	for i := range ed {
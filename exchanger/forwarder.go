@@ -3,6 +3,7 @@ package exchanger
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/miekg/dns"
 )
@@ -19,22 +20,38 @@ func (f Forwarder) Forward(m *dns.Msg, proto string) (*dns.Msg, error) {
 // NewForwarder returns a new Forwarder for the given addrs with the given
 // Exchangers map which maps network protocols to Exchangers.
 //
+// Addresses are IPs or host:port pairs, exchanged with the Exchanger of the
+// protocol of the forwarded message on port 53 by default, or URLs with a
+// "tls" (DNS-over-TLS, port 853 by default) or "https" (DNS-over-HTTPS)
+// scheme, exchanged with the Exchanger of that scheme whatever the protocol.
+//
 // Every message will be exchanged with each address until no error is returned.
 // If no addresses or no matching protocol exchanger exist, a *ForwardError will
 // be returned.
 func NewForwarder(addrs []string, exs map[string]Exchanger) Forwarder {
-	return func(m *dns.Msg, proto string) (r *dns.Msg, err error) {
-		ex, ok := exs[proto]
-		if !ok || len(addrs) == 0 {
-			return nil, &ForwardError{Addrs: addrs, Proto: proto}
-		}
-		for _, a := range addrs {
-			if r, _, err = ex.Exchange(m, net.JoinHostPort(a, "53")); err == nil {
-				break
-			}
-		}
-		return
+	return NewUpstreams(addrs, Sequential, 0).Forwarder(exs)
+}
+
+// Upstream returns the network protocol of the Exchanger messages of the
+// given protocol are exchanged with the given upstream address with, as
+// described by NewForwarder, and the address to exchange them with.
+func Upstream(addr, proto string) (string, string) {
+	switch {
+	case strings.HasPrefix(addr, "tls://"):
+		return "tls", hostPort(strings.TrimPrefix(addr, "tls://"), "853")
+	case strings.HasPrefix(addr, "https://"):
+		return "https", addr
+	default:
+		return proto, hostPort(addr, "53")
+	}
+}
+
+// hostPort returns the given address with the given port unless it has one.
+func hostPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
 	}
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), port)
 }
 
 // A ForwardError is returned by Forwarders when they can't forward.
@@ -79,6 +79,25 @@ func TestForwarder(t *testing.T) {
 			proto: "udp",
 			err:   errors.New("eof"),
 		},
+		{ // per address protocols and ports
+			addrs: []string{"1.2.3.4:5353", "tls://2.3.4.5", "https://3.4.5.6/dns-query"},
+			exs: map[string]Exchanger{
+				"udp": Func(func(_ *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+					if a != "1.2.3.4:5353" {
+						t.Errorf("exchanged over UDP with %q", a)
+					}
+					return nil, 0, errors.New("timeout")
+				}),
+				"tls": Func(func(_ *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+					if a != "2.3.4.5:853" {
+						t.Errorf("exchanged over TLS with %q", a)
+					}
+					return nil, 0, errors.New("handshake failure")
+				}),
+			},
+			proto: "udp",
+			err:   &ForwardError{[]string{"https://3.4.5.6/dns-query"}, "https"},
+		},
 	} {
 		var got forwarded
 		got.r, got.err = NewForwarder(tt.addrs, tt.exs).Forward(nil, tt.proto)
@@ -93,3 +112,23 @@ type forwarded struct {
 	r   *dns.Msg
 	err error
 }
+
+func TestUpstream(t *testing.T) {
+	for i, tt := range []struct {
+		addr, proto string
+		want        [2]string
+	}{
+		{"1.2.3.4", "udp", [2]string{"udp", "1.2.3.4:53"}},
+		{"1.2.3.4:5353", "tcp", [2]string{"tcp", "1.2.3.4:5353"}},
+		{"2001:db8::1", "udp", [2]string{"udp", "[2001:db8::1]:53"}},
+		{"[2001:db8::1]", "udp", [2]string{"udp", "[2001:db8::1]:53"}},
+		{"[2001:db8::1]:5353", "udp", [2]string{"udp", "[2001:db8::1]:5353"}},
+		{"tls://1.1.1.1", "udp", [2]string{"tls", "1.1.1.1:853"}},
+		{"tls://dns.example.com:8853", "tcp", [2]string{"tls", "dns.example.com:8853"}},
+		{"https://1.1.1.1/dns-query", "udp", [2]string{"https", "https://1.1.1.1/dns-query"}},
+	} {
+		if proto, addr := Upstream(tt.addr, tt.proto); proto != tt.want[0] || addr != tt.want[1] {
+			t.Errorf("test #%d: got (%q, %q), want %q", i, proto, addr, tt.want)
+		}
+	}
+}
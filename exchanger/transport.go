@@ -0,0 +1,191 @@
+package exchanger
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// A TLSClient is an Exchanger of DNS-over-TLS (RFC 7858) messages. Each
+// exchange happens over a new connection.
+type TLSClient struct {
+	// Config is the TLS configuration of connections. The server name is
+	// the host of the exchanged address if not set.
+	Config *tls.Config
+	// Timeout is the timeout of the whole exchange, if any.
+	Timeout time.Duration
+	// TsigSecret holds the secrets of the TSIG keys by name, as in
+	// dns.Client.
+	TsigSecret map[string]string
+}
+
+// Exchange implements the Exchanger interface.
+func (c *TLSClient) Exchange(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+	b, mac, err := packMsg(m, c.TsigSecret)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	config := c.Config
+	if config == nil || config.ServerName == "" {
+		host, _, err := net.SplitHostPort(a)
+		if err != nil {
+			return nil, 0, err
+		}
+		config = withServerName(config, host)
+	}
+
+	start := time.Now()
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", a, config)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = conn.Close() }()
+	if c.Timeout != 0 {
+		if err = conn.SetDeadline(start.Add(c.Timeout)); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	out := make([]byte, 2, 2+len(b))
+	binary.BigEndian.PutUint16(out, uint16(len(b)))
+	if _, err = conn.Write(append(out, b...)); err != nil {
+		return nil, 0, err
+	}
+
+	var n uint16
+	if err = binary.Read(conn, binary.BigEndian, &n); err != nil {
+		return nil, 0, err
+	}
+	in := make([]byte, n)
+	if _, err = io.ReadFull(conn, in); err != nil {
+		return nil, 0, err
+	}
+
+	r, err := unpackMsg(in, m, c.TsigSecret, mac)
+	return r, time.Since(start), err
+}
+
+// withServerName returns a copy of the given TLS configuration, if any, with
+// the given server name. Its fields are copied one by one, since copying it
+// isn't safe.
+func withServerName(c *tls.Config, name string) *tls.Config {
+	if c == nil {
+		return &tls.Config{ServerName: name}
+	}
+	return &tls.Config{
+		Rand:                     c.Rand,
+		Time:                     c.Time,
+		Certificates:             c.Certificates,
+		NameToCertificate:        c.NameToCertificate,
+		GetCertificate:           c.GetCertificate,
+		RootCAs:                  c.RootCAs,
+		NextProtos:               c.NextProtos,
+		ServerName:               name,
+		ClientAuth:               c.ClientAuth,
+		ClientCAs:                c.ClientCAs,
+		InsecureSkipVerify:       c.InsecureSkipVerify,
+		CipherSuites:             c.CipherSuites,
+		PreferServerCipherSuites: c.PreferServerCipherSuites,
+		SessionTicketsDisabled:   c.SessionTicketsDisabled,
+		SessionTicketKey:         c.SessionTicketKey,
+		ClientSessionCache:       c.ClientSessionCache,
+		MinVersion:               c.MinVersion,
+		MaxVersion:               c.MaxVersion,
+		CurvePreferences:         c.CurvePreferences,
+	}
+}
+
+// An HTTPSClient is an Exchanger of DNS-over-HTTPS (RFC 8484) messages,
+// POSTed to the URLs they're exchanged with.
+type HTTPSClient struct {
+	// Client is the HTTP client used, http.DefaultClient if nil.
+	Client *http.Client
+	// TsigSecret holds the secrets of the TSIG keys by name, as in
+	// dns.Client.
+	TsigSecret map[string]string
+}
+
+// Exchange implements the Exchanger interface.
+func (c *HTTPSClient) Exchange(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+	b, mac, err := packMsg(m, c.TsigSecret)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodPost, a, bytes.NewReader(b))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status from %s: %s", a, resp.Status)
+	}
+	in, err := ioutil.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r, err := unpackMsg(in, m, c.TsigSecret, mac)
+	return r, time.Since(start), err
+}
+
+// packMsg packs the given message, generating its TSIG record with the given
+// secrets if it has one, in which case it also returns its MAC.
+func packMsg(m *dns.Msg, secrets map[string]string) ([]byte, string, error) {
+	t := m.IsTsig()
+	if t == nil {
+		b, err := m.Pack()
+		return b, "", err
+	}
+	secret, ok := secrets[t.Hdr.Name]
+	if !ok {
+		return nil, "", dns.ErrSecret
+	}
+	// TsigGenerate removes the TSIG record of the message it packs
+	return dns.TsigGenerate(m.Copy(), secret, "", false)
+}
+
+// unpackMsg unpacks the given reply to the given request, verifying its TSIG
+// record with the given secrets and MAC of the request. The reply must be
+// signed with the key of the request if the request is signed.
+func unpackMsg(b []byte, m *dns.Msg, secrets map[string]string, mac string) (*dns.Msg, error) {
+	r := new(dns.Msg)
+	if err := r.Unpack(b); err != nil {
+		return nil, err
+	}
+	if t := m.IsTsig(); t != nil {
+		if err := signedWith(r, t.Hdr.Name); err != nil {
+			return r, err
+		}
+	}
+	if t := r.IsTsig(); t != nil {
+		secret, ok := secrets[t.Hdr.Name]
+		if !ok {
+			return r, dns.ErrSecret
+		}
+		return r, dns.TsigVerify(b, secret, mac, false)
+	}
+	return r, nil
+}
@@ -0,0 +1,193 @@
+package exchanger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var transportSecrets = map[string]string{"transport.": "c2VjcmV0"}
+
+// answer returns the packed reply to the given packed request, signed like
+// the request if it is, failing the test if the request is invalid.
+func answer(t *testing.T, b []byte) []byte {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		t.Error(err)
+		return nil
+	}
+	r := new(dns.Msg).SetReply(m)
+	r.Answer = append(r.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("1.2.3.4"),
+	})
+
+	ts := m.IsTsig()
+	if ts == nil {
+		out, err := r.Pack()
+		if err != nil {
+			t.Error(err)
+		}
+		return out
+	}
+	if err := dns.TsigVerify(b, transportSecrets[ts.Hdr.Name], "", false); err != nil {
+		t.Errorf("invalid TSIG of request: %v", err)
+	}
+	r.SetTsig(ts.Hdr.Name, ts.Algorithm, TSIGFudge, time.Now().Unix())
+	out, _, err := dns.TsigGenerate(r, transportSecrets[ts.Hdr.Name], ts.MAC, false)
+	if err != nil {
+		t.Error(err)
+	}
+	return out
+}
+
+// requests returns an unsigned and a signed request.
+func requests() []*dns.Msg {
+	unsigned := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	signed := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	signed.SetTsig("transport.", dns.HmacSHA256, TSIGFudge, time.Now().Unix())
+	return []*dns.Msg{unsigned, signed}
+}
+
+// trusting returns a TLS configuration trusting the certificate of the given
+// server.
+func trusting(t *testing.T, srv *httptest.Server) *tls.Config {
+	cert, err := x509.ParseCertificate(srv.TLS.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{RootCAs: pool}
+}
+
+func checkAnswer(t *testing.T, r *dns.Msg, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Answer) != 1 || !r.Answer[0].(*dns.A).A.Equal(net.ParseIP("1.2.3.4")) {
+		t.Fatalf("got %v, want 1.2.3.4", r)
+	}
+}
+
+func TestTLSClient(t *testing.T) {
+	// borrow the certificate of httptest, valid for 127.0.0.1
+	srv := httptest.NewTLSServer(nil)
+	defer srv.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			var n uint16
+			if err = binary.Read(conn, binary.BigEndian, &n); err == nil {
+				b := make([]byte, n)
+				if _, err = io.ReadFull(conn, b); err == nil {
+					out := answer(t, b)
+					_ = binary.Write(conn, binary.BigEndian, uint16(len(out)))
+					_, _ = conn.Write(out)
+				}
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	c := &TLSClient{
+		Config:     trusting(t, srv),
+		Timeout:    5 * time.Second,
+		TsigSecret: transportSecrets,
+	}
+	for _, m := range requests() {
+		r, _, err := c.Exchange(m, l.Addr().String())
+		checkAnswer(t, r, err)
+	}
+
+	// the certificate isn't trusted without the test's root CA
+	if _, _, err = (&TLSClient{Timeout: time.Second}).Exchange(requests()[0], l.Addr().String()); err == nil {
+		t.Error("got no error with an untrusted certificate")
+	}
+}
+
+func TestHTTPSClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			t.Errorf("got %s request of %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(answer(t, b))
+	}))
+	defer srv.Close()
+
+	c := &HTTPSClient{
+		Client:     &http.Client{Transport: &http.Transport{TLSClientConfig: trusting(t, srv)}},
+		TsigSecret: transportSecrets,
+	}
+	for _, m := range requests() {
+		r, _, err := c.Exchange(m, srv.URL+"/dns-query")
+		checkAnswer(t, r, err)
+	}
+
+	// unknown keys can't sign requests
+	c.TsigSecret = nil
+	if _, _, err := c.Exchange(requests()[1], srv.URL+"/dns-query"); err != dns.ErrSecret {
+		t.Errorf("got error %v, want %v", err, dns.ErrSecret)
+	}
+}
+
+func TestUnpackMsg(t *testing.T) {
+	secrets := map[string]string{"transport.": "c2VjcmV0", "other.": "b3RoZXI="}
+	for i, tt := range []struct {
+		request, reply string // keys, if signed
+		err            error
+	}{
+		{"", "", nil},
+		{"transport.", "transport.", nil},
+		{"transport.", "", dns.ErrNoSig},
+		{"transport.", "other.", dns.ErrSecret},
+	} {
+		m := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+		if tt.request != "" {
+			m.SetTsig(tt.request, dns.HmacSHA256, TSIGFudge, time.Now().Unix())
+		}
+		_, mac, err := packMsg(m, secrets)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := new(dns.Msg).SetReply(m)
+		var b []byte
+		if tt.reply == "" {
+			b, err = r.Pack()
+		} else {
+			r.SetTsig(tt.reply, dns.HmacSHA256, TSIGFudge, time.Now().Unix())
+			b, _, err = dns.TsigGenerate(r, secrets[tt.reply], mac, false)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = unpackMsg(b, m, secrets, mac); err != tt.err {
+			t.Errorf("test #%d: got error %v, want %v", i, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package exchanger
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Upstream selection policies, ordering the addresses messages are forwarded
+// to. With all of them, addresses which failed within their cooldown period
+// are tried last.
+const (
+	// Sequential tries addresses in the configured order.
+	Sequential = "sequential"
+	// RoundRobin rotates the address tried first with each message.
+	RoundRobin = "round-robin"
+	// Fastest tries addresses by increasing average RTT.
+	Fastest = "fastest"
+	// Race exchanges messages with the two fastest addresses in parallel,
+	// returning the first good reply, before trying the others in turn.
+	Race = "race"
+)
+
+// rttWeight is the weight of the latest RTT in the exponentially weighted
+// moving average of the RTTs of an upstream.
+const rttWeight = 0.3
+
+// Upstreams track the health of the addresses of DNS servers messages are
+// forwarded to, and order them according to a selection policy. They're safe
+// for concurrent use.
+type Upstreams struct {
+	addrs    []string
+	policy   string
+	cooldown time.Duration
+	now      func() time.Time
+
+	mu   sync.Mutex
+	ups  []*upstreamHealth // in the configured order
+	next int               // index of the address tried first with RoundRobin
+}
+
+// An upstreamHealth holds the health of an address.
+type upstreamHealth struct {
+	addr      string
+	rtt       time.Duration // moving average, zero until measured
+	failures  int           // consecutive
+	exchanges uint64
+	errors    uint64
+	downUntil time.Time // end of the cooldown after the last failure
+}
+
+// UpstreamStats are the health statistics of an upstream address.
+type UpstreamStats struct {
+	Addr                string
+	RTTMillis           float64
+	ConsecutiveFailures int
+	Exchanges           uint64
+	Errors              uint64
+	CoolingDown         bool
+}
+
+// NewUpstreams returns Upstreams of the given addresses, ordered with the
+// given policy. Failed addresses are tried last for the given cooldown.
+func NewUpstreams(addrs []string, policy string, cooldown time.Duration) *Upstreams {
+	u := &Upstreams{
+		addrs:    addrs,
+		policy:   policy,
+		cooldown: cooldown,
+		now:      time.Now,
+		ups:      make([]*upstreamHealth, len(addrs)),
+	}
+	for i, addr := range addrs {
+		u.ups[i] = &upstreamHealth{addr: addr}
+	}
+	return u
+}
+
+// Forwarder returns a Forwarder of the Upstreams with the given Exchangers, as
+// described by NewForwarder, trying the addresses in the order of their
+// policy and tracking their health.
+func (u *Upstreams) Forwarder(exs map[string]Exchanger) Forwarder {
+	return func(m *dns.Msg, proto string) (r *dns.Msg, err error) {
+		if _, ok := exs[proto]; !ok || len(u.addrs) == 0 {
+			return nil, &ForwardError{Addrs: u.addrs, Proto: proto}
+		}
+		ups := u.order()
+		if u.policy == Race && len(ups) > 1 {
+			if r, err = u.race(ups[:2], exs, m, proto); err == nil {
+				return r, nil
+			}
+			ups = ups[2:]
+		}
+		for _, up := range ups {
+			if r, err = u.exchange(up, exs, m, proto); err == nil {
+				break
+			}
+		}
+		return
+	}
+}
+
+// order returns the upstreams in the order they're tried in.
+func (u *Upstreams) order() []*upstreamHealth {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	ups := append([]*upstreamHealth(nil), u.ups...)
+	switch u.policy {
+	case RoundRobin:
+		n := u.next % len(ups)
+		u.next = n + 1
+		ups = append(ups[n:], ups[:n]...)
+	case Fastest, Race:
+		sort.Stable(byRTT(ups))
+	}
+	sort.Stable(byCooldown{ups, u.now()})
+	return ups
+}
+
+// byRTT implements sort.Interface for upstreams, by increasing average RTT.
+type byRTT []*upstreamHealth
+
+func (s byRTT) Len() int           { return len(s) }
+func (s byRTT) Less(i, j int) bool { return s[i].rtt < s[j].rtt }
+func (s byRTT) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// byCooldown implements sort.Interface for upstreams, putting those cooling
+// down at the given time last.
+type byCooldown struct {
+	ups []*upstreamHealth
+	now time.Time
+}
+
+func (s byCooldown) Len() int { return len(s.ups) }
+func (s byCooldown) Less(i, j int) bool {
+	return !s.now.Before(s.ups[i].downUntil) && s.now.Before(s.ups[j].downUntil)
+}
+func (s byCooldown) Swap(i, j int) { s.ups[i], s.ups[j] = s.ups[j], s.ups[i] }
+
+// race exchanges the given message with the given upstreams in parallel and
+// returns the first good reply, i.e. neither SERVFAIL nor REFUSED. If there's
+// none, it returns the last reply or error.
+func (u *Upstreams) race(ups []*upstreamHealth, exs map[string]Exchanger, m *dns.Msg, proto string) (r *dns.Msg, err error) {
+	type result struct {
+		r   *dns.Msg
+		err error
+	}
+	results := make(chan result, len(ups))
+	for _, up := range ups {
+		go func(up *upstreamHealth, m *dns.Msg) {
+			r, err := u.exchange(up, exs, m, proto)
+			results <- result{r, err}
+		}(up, m.Copy())
+	}
+	for range ups {
+		res := <-results
+		if r, err = res.r, res.err; err == nil &&
+			r.Rcode != dns.RcodeServerFailure && r.Rcode != dns.RcodeRefused {
+			break
+		}
+	}
+	return r, err
+}
+
+// exchange exchanges the given message with the given upstream and records
+// the outcome.
+func (u *Upstreams) exchange(up *upstreamHealth, exs map[string]Exchanger, m *dns.Msg, proto string) (*dns.Msg, error) {
+	p, a := Upstream(up.addr, proto)
+	ex, ok := exs[p]
+	if !ok {
+		return nil, &ForwardError{Addrs: []string{up.addr}, Proto: p}
+	}
+	r, rtt, err := ex.Exchange(m, a)
+	u.observe(up, rtt, err)
+	return r, err
+}
+
+// observe records the outcome of an exchange with the given upstream. Zero
+// RTTs aren't measurements.
+func (u *Upstreams) observe(up *upstreamHealth, rtt time.Duration, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	up.exchanges++
+	if err != nil {
+		up.errors++
+		up.failures++
+		up.downUntil = u.now().Add(u.cooldown)
+		return
+	}
+	up.failures, up.downUntil = 0, time.Time{}
+	switch {
+	case rtt <= 0:
+	case up.rtt == 0:
+		up.rtt = rtt
+	default:
+		up.rtt = time.Duration(rttWeight*float64(rtt) + (1-rttWeight)*float64(up.rtt))
+	}
+}
+
+// Stats returns the health statistics of the upstreams, in the configured
+// order.
+func (u *Upstreams) Stats() []UpstreamStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := u.now()
+	stats := make([]UpstreamStats, len(u.ups))
+	for i, up := range u.ups {
+		stats[i] = UpstreamStats{
+			Addr:                up.addr,
+			RTTMillis:           float64(up.rtt) / float64(time.Millisecond),
+			ConsecutiveFailures: up.failures,
+			Exchanges:           up.exchanges,
+			Errors:              up.errors,
+			CoolingDown:         now.Before(up.downUntil),
+		}
+	}
+	return stats
+}
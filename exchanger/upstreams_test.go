@@ -0,0 +1,162 @@
+package exchanger
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// addrsOf returns the addresses of the given upstreams.
+func addrsOf(ups []*upstreamHealth) []string {
+	addrs := make([]string, len(ups))
+	for i, up := range ups {
+		addrs[i] = up.addr
+	}
+	return addrs
+}
+
+func TestUpstreamsOrder(t *testing.T) {
+	addrs := []string{"a", "b", "c"}
+	for i, tt := range []struct {
+		policy string
+		rtts   []time.Duration
+		down   []bool
+		want   [][]string // of successive messages
+	}{
+		{Sequential, nil, nil, [][]string{{"a", "b", "c"}, {"a", "b", "c"}}},
+		{Sequential, nil, []bool{true, false, false}, [][]string{{"b", "c", "a"}}},
+		{Sequential, nil, []bool{true, true, false}, [][]string{{"c", "a", "b"}}},
+		{RoundRobin, nil, nil, [][]string{{"a", "b", "c"}, {"b", "c", "a"}, {"c", "a", "b"}, {"a", "b", "c"}}},
+		{RoundRobin, nil, []bool{false, true, false}, [][]string{{"a", "c", "b"}, {"c", "a", "b"}}},
+		{Fastest, []time.Duration{3, 1, 2}, nil, [][]string{{"b", "c", "a"}, {"b", "c", "a"}}},
+		{Fastest, []time.Duration{3, 1, 2}, []bool{false, true, false}, [][]string{{"c", "a", "b"}}},
+		{Race, []time.Duration{3, 0, 2}, nil, [][]string{{"b", "c", "a"}}}, // unmeasured first
+	} {
+		u := NewUpstreams(addrs, tt.policy, time.Minute)
+		clk := &clock{now: time.Unix(0, 0)}
+		u.now = clk.Now
+		for j, up := range u.ups {
+			if tt.rtts != nil {
+				up.rtt = tt.rtts[j]
+			}
+			if tt.down != nil && tt.down[j] {
+				up.downUntil = clk.Now().Add(time.Second)
+			}
+		}
+		for j, want := range tt.want {
+			if got := addrsOf(u.order()); !reflect.DeepEqual(got, want) {
+				t.Errorf("test #%d, message #%d: got %v, want %v", i, j, got, want)
+			}
+		}
+	}
+}
+
+func TestUpstreamsHealth(t *testing.T) {
+	clk := &clock{now: time.Unix(0, 0)}
+	var (
+		mu        sync.Mutex
+		exchanged []string
+		failing   = map[string]bool{"1.1.1.1:53": true}
+	)
+	ex := Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		exchanged = append(exchanged, a)
+		if failing[a] {
+			return nil, 0, errors.New("timeout")
+		}
+		return new(dns.Msg).SetReply(m), 10 * time.Millisecond, nil
+	})
+
+	u := NewUpstreams([]string{"1.1.1.1", "2.2.2.2"}, Sequential, time.Minute)
+	u.now = clk.Now
+	fwd := u.Forwarder(map[string]Exchanger{"udp": ex})
+	forward := func(want ...string) {
+		exchanged = nil
+		if _, err := fwd(new(dns.Msg).SetQuestion("example.com.", dns.TypeA), "udp"); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(exchanged, want) {
+			t.Fatalf("exchanged with %v, want %v", exchanged, want)
+		}
+	}
+
+	forward("1.1.1.1:53", "2.2.2.2:53")
+	forward("2.2.2.2:53") // the failed one is tried last while cooling down
+	clk.Advance(time.Minute)
+	failing["1.1.1.1:53"] = false
+	forward("1.1.1.1:53")
+
+	want := []UpstreamStats{
+		{Addr: "1.1.1.1", RTTMillis: 10, Exchanges: 2, Errors: 1},
+		{Addr: "2.2.2.2", RTTMillis: 10, Exchanges: 2},
+	}
+	if got := u.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+}
+
+func TestUpstreamsRTT(t *testing.T) {
+	u := NewUpstreams([]string{"a"}, Fastest, time.Minute)
+	for _, rtt := range []time.Duration{100, 0, 200} {
+		u.observe(u.ups[0], rtt*time.Millisecond, nil)
+	}
+	// the unmeasured RTT is ignored: 0.3*200 + 0.7*100
+	if got, want := u.Stats()[0].RTTMillis, 130.0; got != want {
+		t.Errorf("got RTT %vms, want %vms", got, want)
+	}
+
+	u.observe(u.ups[0], 0, errors.New("timeout"))
+	u.observe(u.ups[0], 0, errors.New("timeout"))
+	if got := u.Stats()[0]; got.ConsecutiveFailures != 2 || got.Errors != 2 || !got.CoolingDown {
+		t.Errorf("got stats %+v, want 2 consecutive failures, cooling down", got)
+	}
+}
+
+func TestUpstreamsRace(t *testing.T) {
+	release := make(chan struct{})
+	ex := Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+		r := new(dns.Msg).SetReply(m)
+		switch a {
+		case "1.1.1.1:53": // slow
+			<-release
+		case "2.2.2.2:53": // fast, but failing
+			r.Rcode = dns.RcodeServerFailure
+			return r, time.Millisecond, nil
+		}
+		r.Answer = append(r.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+			A:   []byte{1, 2, 3, 4},
+		})
+		return r, time.Millisecond, nil
+	})
+
+	u := NewUpstreams([]string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, Race, time.Minute)
+	fwd := u.Forwarder(map[string]Exchanger{"udp": ex})
+	done := make(chan *dns.Msg)
+	go func() {
+		r, err := fwd(new(dns.Msg).SetQuestion("example.com.", dns.TypeA), "udp")
+		if err != nil {
+			t.Error(err)
+		}
+		done <- r
+	}()
+
+	// the SERVFAIL of the fastest doesn't win the race
+	select {
+	case r := <-done:
+		t.Fatalf("got %v before the slow upstream answered", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if r := <-done; r == nil || len(r.Answer) != 1 {
+		t.Errorf("got %v, want the answer of the slow upstream", r)
+	}
+	if got := u.Stats()[2].Exchanges; got != 0 {
+		t.Errorf("got %d exchanges with the third upstream, want none", got)
+	}
+}
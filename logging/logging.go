@@ -39,35 +39,56 @@ func (lc *LogCounter) Inc() {
 	atomic.AddUint64(&lc.value, 1)
 }
 
+// Value returns the value of the counter.
+func (lc *LogCounter) Value() uint64 {
+	return atomic.LoadUint64(&lc.value)
+}
+
 // String returns a string represention of the counter.
 func (lc *LogCounter) String() string {
-	return strconv.FormatUint(atomic.LoadUint64(&lc.value), 10)
+	return strconv.FormatUint(lc.Value(), 10)
 }
 
 // LogOut holds metrics captured in an instrumented runtime.
 type LogOut struct {
-	MesosRequests     Counter
-	MesosSuccess      Counter
-	MesosNXDomain     Counter
-	MesosFailed       Counter
-	NonMesosRequests  Counter
-	NonMesosSuccess   Counter
-	NonMesosNXDomain  Counter
-	NonMesosFailed    Counter
-	NonMesosForwarded Counter
+	MesosRequests       Counter
+	MesosSuccess        Counter
+	MesosNXDomain       Counter
+	MesosFailed         Counter
+	NonMesosRequests    Counter
+	NonMesosSuccess     Counter
+	NonMesosNXDomain    Counter
+	NonMesosFailed      Counter
+	NonMesosForwarded   Counter
+	NonMesosCacheHits   Counter
+	NonMesosCacheMisses Counter
+	MesosRefused        Counter
+	NonMesosRefused     Counter
+	XFRRefused          Counter
+	HTTPForbidden       Counter
+	RateLimited         Counter
+	RateLimitSlipped    Counter
 }
 
 // CurLog is the default package level LogOut.
 var CurLog = LogOut{
-	MesosRequests:     &LogCounter{},
-	MesosSuccess:      &LogCounter{},
-	MesosNXDomain:     &LogCounter{},
-	MesosFailed:       &LogCounter{},
-	NonMesosRequests:  &LogCounter{},
-	NonMesosSuccess:   &LogCounter{},
-	NonMesosNXDomain:  &LogCounter{},
-	NonMesosFailed:    &LogCounter{},
-	NonMesosForwarded: &LogCounter{},
+	MesosRequests:       &LogCounter{},
+	MesosSuccess:        &LogCounter{},
+	MesosNXDomain:       &LogCounter{},
+	MesosFailed:         &LogCounter{},
+	NonMesosRequests:    &LogCounter{},
+	NonMesosSuccess:     &LogCounter{},
+	NonMesosNXDomain:    &LogCounter{},
+	NonMesosFailed:      &LogCounter{},
+	NonMesosForwarded:   &LogCounter{},
+	NonMesosCacheHits:   &LogCounter{},
+	NonMesosCacheMisses: &LogCounter{},
+	MesosRefused:        &LogCounter{},
+	NonMesosRefused:     &LogCounter{},
+	XFRRefused:          &LogCounter{},
+	HTTPForbidden:       &LogCounter{},
+	RateLimited:         &LogCounter{},
+	RateLimitSlipped:    &LogCounter{},
 }
 
 // PrintCurLog prints out the current LogOut and then resets
@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/mesos/mesos-go/detector"
 	"github.com/mesosphere/mesos-dns/detect"
 	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/mesosphere/mesos-dns/records"
+	"github.com/mesosphere/mesos-dns/records/state"
 	"github.com/mesosphere/mesos-dns/resolvers"
 	"github.com/mesosphere/mesos-dns/utils"
 )
@@ -26,6 +28,8 @@ func main() {
 	// parse flags
 	cjson := flag.String("config", "config.json", "path to config file (json)")
 	flag.BoolVar(&versionFlag, "version", false, "output the version")
+	replay := flag.String("replay", "", "path of a recorded state snapshot to print the records of, as configured, and exit")
+	stateFile := flag.String("state-file", "", "path or file:// URL of a state.json snapshot to serve records from, instead of the Mesos masters")
 	flag.Parse()
 
 	// -version
@@ -39,10 +43,24 @@ func main() {
 
 	// initialize config
 	config := records.SetConfig(*cjson)
+	if *stateFile != "" {
+		if err := records.ValidateStateFile(*stateFile); err != nil {
+			logging.Error.Fatalf("state-file validation failed: %v", err)
+		}
+		config.StateFile = *stateFile
+	}
+
+	// -replay
+	if *replay != "" {
+		if err := records.Replay(config, *replay, os.Stdout); err != nil {
+			logging.Error.Fatal(err)
+		}
+		os.Exit(0)
+	}
 
 	// initialize timers and error chan
 	errch := make(chan error)
-	reload := time.NewTicker(time.Second * time.Duration(config.RefreshSeconds))
+	reload := time.NewTimer(time.Second * time.Duration(config.RefreshSeconds))
 	zkTimeout := time.Second * time.Duration(config.ZkDetectionTimeout)
 	timeout := time.AfterFunc(zkTimeout, func() {
 		if zkTimeout > 0 {
@@ -54,8 +72,22 @@ func main() {
 	rg := records.NewRecordGenerator(config)
 
 	// initialize backends
-	changed := detectMasters(config.Zk, config.Masters)
+	var changed <-chan []string
+	var modified <-chan struct{}
+	if config.StateFile != "" {
+		// offline, the records are generated from the state file right away,
+		// and again whenever it changes
+		logging.Verbose.Println("Serving records from state file ", config.StateFile)
+		timeout.Stop()
+		modified = records.WatchStateFile(config.StateFile, time.Second)
+		utils.ResetTimer(reload, 0)
+	} else {
+		changed = detectMasters(config.Zk, config.Masters)
+	}
 	rs := resolvers.New(errch, rg, Version)
+	ref := &refresher{config: config, rs: rs, rg: rg, last: time.Now()}
+	states := make(chan state.State)
+	var sub subscriber
 
 	defer reload.Stop()
 	defer utils.HandleCrash()
@@ -64,7 +96,7 @@ func main() {
 	for {
 		select {
 		case <-reload.C:
-			reloadResolvers(config, errch, rs)
+			utils.ResetTimer(reload, ref.refresh(errch))
 		case masters := <-changed:
 			if len(masters) == 0 || masters[0] == "" { // no leader
 				timeout.Reset(zkTimeout)
@@ -72,25 +104,138 @@ func main() {
 				timeout.Stop()
 			}
 			logging.VeryVerbose.Printf("new masters detected: %v", masters)
+			setLeader(rs, masters)
 
 			config.Masters = masters
-			reloadResolvers(config, errch, rs)
+			sub.follow(config, masters, states)
+			utils.ResetTimer(reload, ref.refresh(errch))
+		case sj := <-states:
+			ref.update(sj)
+		case <-modified:
+			utils.ResetTimer(reload, ref.refresh(errch))
 		case err := <-errch:
 			logging.Error.Fatal(err)
 		}
 	}
 }
 
-func reloadResolvers(config *records.Config, errch chan error, rs []resolvers.Resolver) {
-	rg := records.NewRecordGenerator(config)
+// A refresher reloads the resolvers with records generated from the state of
+// the Mesos masters. While it fails to, the resolvers keep serving their last
+// records and the refresh is retried with exponential backoff.
+type refresher struct {
+	config  *records.Config
+	rs      []resolvers.Resolver
+	rg      *records.RecordGenerator // the resolvers were last reloaded with
+	last    time.Time                // of the last successful refresh, or of the start
+	backoff time.Duration            // before the next retry, zero after a success
+}
+
+// refresh reloads the resolvers and returns the delay until the next refresh.
+// If there was no successful refresh for MaxStaleSeconds, it sends an error
+// to the given channel.
+func (r *refresher) refresh(errch chan error) time.Duration {
+	interval := time.Second * time.Duration(r.config.RefreshSeconds)
+
+	rg := records.NewRecordGenerator(r.config)
 	err := rg.ParseState()
+	if err == nil {
+		r.last, r.backoff = time.Now(), 0
+		r.reload(rg)
+		if r.config.StateFile != "" {
+			// no masters are detected offline, so the leader is the one of
+			// the state file, e.g. 1.2.3.4:5050 of master@1.2.3.4:5050, or
+			// the state file itself if it has none
+			leader := rg.State.Leader
+			leader = leader[strings.Index(leader, "@")+1:]
+			if leader == "" {
+				leader = r.config.StateFile
+			}
+			setLeader(r.rs, []string{leader})
+		}
+		return interval
+	}
 
-	if err != nil {
-		logging.Error.Printf("Warning: Error generating records: %v; keeping old DNS state", err)
-		errch <- err
-	} else {
-		for _, resolver := range rs {
-			resolver.Reload(rg)
+	if r.backoff *= 2; r.backoff == 0 {
+		r.backoff = time.Second
+	}
+	if r.backoff > interval {
+		r.backoff = interval
+	}
+	stale := time.Since(r.last)
+	logging.Error.Printf("Warning: Error generating records: %v; keeping old DNS state, stale for %v, retrying in %v",
+		err, (stale/time.Second)*time.Second, r.backoff)
+	for _, resolver := range r.rs {
+		if fs, ok := resolver.(resolvers.FailureSetter); ok {
+			fs.SetFailure(err)
+		}
+	}
+
+	if max := time.Second * time.Duration(r.config.MaxStaleSeconds); max > 0 && stale > max {
+		go func() { errch <- fmt.Errorf("no records generated for %v: %v", (stale/time.Second)*time.Second, err) }()
+	}
+	return r.backoff
+}
+
+// update reloads the resolvers with records generated from the given state,
+// as streamed by the leading Mesos master.
+func (r *refresher) update(sj state.State) {
+	rg := records.NewRecordGenerator(r.config)
+	if err := rg.LoadState(sj); err != nil {
+		logging.Error.Printf("Warning: Error generating records from events: %v", err)
+		return
+	}
+	r.last, r.backoff = time.Now(), 0
+	r.reload(rg)
+}
+
+// reload reloads the resolvers with the given RecordGenerator, bumping its
+// SOA serial if its records changed.
+func (r *refresher) reload(rg *records.RecordGenerator) {
+	rg.UpdateSerial(r.rg)
+	for _, resolver := range r.rs {
+		resolver.Reload(rg)
+	}
+	r.rg = rg
+}
+
+// A subscriber streams the events of the leading Mesos master, if EventStream
+// is enabled, following its changes.
+type subscriber struct {
+	leader string        // streamed from, if any
+	stop   chan struct{} // stops the stream, if any
+}
+
+// follow streams the events of the leader of the given masters, if it
+// changed, to the given channel, stopping the stream of the previous one.
+func (s *subscriber) follow(config *records.Config, masters []string, states chan<- state.State) {
+	var leader string
+	if len(masters) > 0 {
+		leader = masters[0]
+	}
+	if !config.EventStream || leader == s.leader {
+		return
+	}
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	if s.leader = leader; leader != "" {
+		logging.Verbose.Println("streaming the events of master ", leader)
+		s.stop = make(chan struct{})
+		go records.NewRecordGenerator(config).Subscribe(leader, states, s.stop)
+	}
+}
+
+// setLeader tells the resolvers which are LeaderSetters about the leader of
+// the given masters.
+func setLeader(rs []resolvers.Resolver, masters []string) {
+	var leader string
+	if len(masters) > 0 {
+		leader = masters[0]
+	}
+	for _, r := range rs {
+		if ls, ok := r.(resolvers.LeaderSetter); ok {
+			ls.SetLeader(leader)
 		}
 	}
 }
@@ -0,0 +1,420 @@
+// Package metrics provides counters, gauges and histograms exposed in the
+// Prometheus text format (version 0.0.4).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are the default upper bounds of the buckets of histograms, in
+// seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A collector writes the samples of a metric of the given name.
+type collector interface {
+	collect(w *bufio.Writer, name string)
+}
+
+// A metric is a registered collector.
+type metric struct {
+	name, help, typ string
+	c               collector
+}
+
+// A Registry holds metrics and writes them out. It's safe for concurrent
+// use.
+type Registry struct {
+	mu      sync.RWMutex
+	metrics map[string]metric
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+// Default is the Registry the package level constructors register with.
+var Default = NewRegistry()
+
+// register adds the given collector of the given name and type to the
+// Registry. It panics if the name is taken.
+func (r *Registry) register(name, help, typ string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", name))
+	}
+	r.metrics[name] = metric{name: name, help: help, typ: typ, c: c}
+}
+
+// WriteTo writes out all metrics of the Registry, sorted by name, in the
+// Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, name := range names {
+		r.mu.RLock()
+		m := r.metrics[name]
+		r.mu.RUnlock()
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, escape(m.help, false), m.name, m.typ)
+		m.c.collect(bw, m.name)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = r.WriteTo(w)
+}
+
+// A countingWriter counts the bytes written to its io.Writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// A Counter is a monotonically increasing value. It implements the
+// logging.Counter interface.
+type Counter struct {
+	value uint64
+}
+
+// NewCounter returns a Counter registered with the Default Registry.
+func NewCounter(name, help string) *Counter { return Default.NewCounter(name, help) }
+
+// NewCounter returns a Counter registered with the Registry.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", c)
+	return c
+}
+
+// Inc increments the Counter by one.
+func (c *Counter) Inc() { atomic.AddUint64(&c.value, 1) }
+
+// Add increments the Counter by n.
+func (c *Counter) Add(n uint64) { atomic.AddUint64(&c.value, n) }
+
+// Value returns the current value of the Counter.
+func (c *Counter) Value() uint64 { return atomic.LoadUint64(&c.value) }
+
+// String returns a string representation of the Counter.
+func (c *Counter) String() string { return strconv.FormatUint(c.Value(), 10) }
+
+func (c *Counter) collect(w *bufio.Writer, name string) {
+	sample(w, name, "", float64(c.Value()))
+}
+
+// CounterFunc registers, with the Default Registry, a counter whose value is
+// returned by the given func.
+func CounterFunc(name, help string, f func() float64) { Default.CounterFunc(name, help, f) }
+
+// CounterFunc registers a counter whose value is returned by the given func.
+func (r *Registry) CounterFunc(name, help string, f func() float64) {
+	r.register(name, help, "counter", valueFunc(f))
+}
+
+// GaugeFunc registers, with the Default Registry, a gauge whose value is
+// returned by the given func.
+func GaugeFunc(name, help string, f func() float64) { Default.GaugeFunc(name, help, f) }
+
+// GaugeFunc registers a gauge whose value is returned by the given func.
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.register(name, help, "gauge", valueFunc(f))
+}
+
+// A valueFunc returns the value of a metric.
+type valueFunc func() float64
+
+func (f valueFunc) collect(w *bufio.Writer, name string) {
+	sample(w, name, "", f())
+}
+
+// A Gauge is a value which may go up and down.
+type Gauge struct {
+	bits uint64
+}
+
+// NewGauge returns a Gauge registered with the Default Registry.
+func NewGauge(name, help string) *Gauge { return Default.NewGauge(name, help) }
+
+// NewGauge returns a Gauge registered with the Registry.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", g)
+	return g
+}
+
+// Set sets the Gauge to the given value.
+func (g *Gauge) Set(v float64) { atomic.StoreUint64(&g.bits, math.Float64bits(v)) }
+
+// SetToTime sets the Gauge to the given time, in seconds since the epoch.
+func (g *Gauge) SetToTime(t time.Time) { g.Set(float64(t.UnixNano()) / 1e9) }
+
+// Value returns the current value of the Gauge.
+func (g *Gauge) Value() float64 { return math.Float64frombits(atomic.LoadUint64(&g.bits)) }
+
+func (g *Gauge) collect(w *bufio.Writer, name string) {
+	sample(w, name, "", g.Value())
+}
+
+// A Histogram counts observed values in buckets of configurable upper bounds.
+type Histogram struct {
+	bounds  []float64
+	counts  []uint64 // by bucket, not cumulative, the last one is +Inf
+	sumBits uint64
+}
+
+// NewHistogram returns a Histogram of the given bucket upper bounds, in
+// increasing order, registered with the Default Registry.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return Default.NewHistogram(name, help, buckets)
+}
+
+// NewHistogram returns a Histogram of the given bucket upper bounds, in
+// increasing order, registered with the Registry.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(buckets)
+	r.register(name, help, "histogram", h)
+	return h
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{bounds: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+// Observe adds the given value to the Histogram.
+func (h *Histogram) Observe(v float64) {
+	atomic.AddUint64(&h.counts[sort.SearchFloat64s(h.bounds, v)], 1)
+	for {
+		old := atomic.LoadUint64(&h.sumBits)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sumBits, old, sum) {
+			return
+		}
+	}
+}
+
+// ObserveSince adds the time elapsed since the given one, in seconds, to the
+// Histogram.
+func (h *Histogram) ObserveSince(t time.Time) { h.Observe(time.Since(t).Seconds()) }
+
+func (h *Histogram) collect(w *bufio.Writer, name string) {
+	h.collectLabeled(w, name, "")
+}
+
+func (h *Histogram) collectLabeled(w *bufio.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var count uint64
+	for i, bound := range h.bounds {
+		count += atomic.LoadUint64(&h.counts[i])
+		sample(w, name+"_bucket", labels+sep+`le="`+formatFloat(bound)+`"`, float64(count))
+	}
+	count += atomic.LoadUint64(&h.counts[len(h.bounds)])
+	sample(w, name+"_bucket", labels+sep+`le="+Inf"`, float64(count))
+	sample(w, name+"_sum", labels, math.Float64frombits(atomic.LoadUint64(&h.sumBits)))
+	sample(w, name+"_count", labels, float64(count))
+}
+
+// A vec holds the children of a metric, by the values of its labels.
+type vec struct {
+	labels   []string
+	new      func() interface{}
+	mu       sync.RWMutex
+	children map[string]interface{} // by joined label values
+}
+
+func newVec(labels []string, new func() interface{}) *vec {
+	return &vec{labels: labels, new: new, children: map[string]interface{}{}}
+}
+
+// child returns the child of the given label values, creating it if needed.
+func (v *vec) child(values []string) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values, want %d", len(values), len(v.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok = v.children[key]; !ok {
+		c = v.new()
+		v.children[key] = c
+	}
+	return c
+}
+
+// each calls f with the formatted labels of each child, sorted.
+func (v *vec) each(f func(labels string, c interface{})) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.mu.RLock()
+		c := v.children[key]
+		v.mu.RUnlock()
+		pairs := make([]string, len(v.labels))
+		for i, value := range strings.Split(key, "\xff") {
+			pairs[i] = v.labels[i] + `="` + escape(value, true) + `"`
+		}
+		f(strings.Join(pairs, ","), c)
+	}
+}
+
+// A CounterVec is a set of Counters partitioned by the values of labels.
+type CounterVec struct{ *vec }
+
+// NewCounterVec returns a CounterVec of the given labels registered with the
+// Default Registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewCounterVec returns a CounterVec of the given labels registered with the
+// Registry.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newVec(labels, func() interface{} { return &Counter{} })}
+	r.register(name, help, "counter", v)
+	return v
+}
+
+// WithLabelValues returns the Counter of the given label values.
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return v.child(values).(*Counter)
+}
+
+func (v *CounterVec) collect(w *bufio.Writer, name string) {
+	v.each(func(labels string, c interface{}) {
+		sample(w, name, labels, float64(c.(*Counter).Value()))
+	})
+}
+
+// A GaugeVec is a set of Gauges partitioned by the values of labels.
+type GaugeVec struct{ *vec }
+
+// NewGaugeVec returns a GaugeVec of the given labels registered with the
+// Default Registry.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// NewGaugeVec returns a GaugeVec of the given labels registered with the
+// Registry.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newVec(labels, func() interface{} { return &Gauge{} })}
+	r.register(name, help, "gauge", v)
+	return v
+}
+
+// WithLabelValues returns the Gauge of the given label values.
+func (v *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return v.child(values).(*Gauge)
+}
+
+func (v *GaugeVec) collect(w *bufio.Writer, name string) {
+	v.each(func(labels string, g interface{}) {
+		sample(w, name, labels, g.(*Gauge).Value())
+	})
+}
+
+// A HistogramVec is a set of Histograms partitioned by the values of labels.
+type HistogramVec struct{ *vec }
+
+// NewHistogramVec returns a HistogramVec of the given bucket upper bounds and
+// labels registered with the Default Registry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewHistogramVec returns a HistogramVec of the given bucket upper bounds and
+// labels registered with the Registry.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{newVec(labels, func() interface{} { return newHistogram(buckets) })}
+	r.register(name, help, "histogram", v)
+	return v
+}
+
+// WithLabelValues returns the Histogram of the given label values.
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return v.child(values).(*Histogram)
+}
+
+func (v *HistogramVec) collect(w *bufio.Writer, name string) {
+	v.each(func(labels string, h interface{}) {
+		h.(*Histogram).collectLabeled(w, name, labels)
+	})
+}
+
+// sample writes out a sample of the given name, formatted labels and value.
+func sample(w *bufio.Writer, name, labels string, v float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+// formatFloat formats the given value as in the Prometheus text format.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escape escapes backslashes and line feeds, and double quotes too in label
+// values, of the given string.
+func escape(s string, label bool) string {
+	r := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	if label {
+		r = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	}
+	return r.Replace(s)
+}
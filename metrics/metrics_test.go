@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests.")
+	g := r.NewGauge("temperature", "Temperature.")
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{.1, 1})
+	cv := r.NewCounterVec("responses_total", "Responses\nby code.", "code", "path")
+	gv := r.NewGaugeVec("records", "Records.", "kind")
+	hv := r.NewHistogramVec("duration_seconds", "Duration.", []float64{1}, "kind")
+	r.CounterFunc("func_total", "Func.", func() float64 { return 7 })
+
+	c.Inc()
+	c.Add(2)
+	g.Set(-1.5)
+	h.Observe(.05)
+	h.Observe(.1)
+	h.Observe(5)
+	cv.WithLabelValues("200", `/a"b\`).Inc()
+	cv.WithLabelValues("404", "/").Inc()
+	cv.WithLabelValues("200", `/a"b\`).Inc()
+	gv.WithLabelValues("A").Set(3)
+	hv.WithLabelValues("x").Observe(2)
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{kind="x",le="1"} 0
+duration_seconds_bucket{kind="x",le="+Inf"} 1
+duration_seconds_sum{kind="x"} 2
+duration_seconds_count{kind="x"} 1
+# HELP func_total Func.
+# TYPE func_total counter
+func_total 7
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.15
+latency_seconds_count 3
+# HELP records Records.
+# TYPE records gauge
+records{kind="A"} 3
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total 3
+# HELP responses_total Responses\nby code.
+# TYPE responses_total counter
+responses_total{code="200",path="/a\"b\\"} 2
+responses_total{code="404",path="/"} 1
+# HELP temperature Temperature.
+# TYPE temperature gauge
+temperature -1.5
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registered a duplicate metric")
+		}
+	}()
+	r.NewGauge("requests_total", "Requests.")
+}
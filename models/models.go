@@ -8,10 +8,12 @@ type AXFRResourceRecordSet map[string][]string
 // This is the internal structure of how mesos-dns works today and the transformation of string -> DNS Struct
 // happens on actual query time. Why this logic happens at query time? Who knows.
 
-// AXFRRecords are the As, and SRVs that actually make up the Mesos-DNS zone
+// AXFRRecords are the As, AAAAs, PTRs, and SRVs that actually make up the Mesos-DNS zone
 type AXFRRecords struct {
-	As   AXFRResourceRecordSet
-	SRVs AXFRResourceRecordSet
+	As    AXFRResourceRecordSet
+	AAAAs AXFRResourceRecordSet
+	PTRs  AXFRResourceRecordSet
+	SRVs  AXFRResourceRecordSet
 }
 
 // AXFR is a rough representation of a "transfer" of the Mesos-DNS data
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -26,10 +27,46 @@ type Config struct {
 	Masters []string
 	// Refresh frequency: the frequency in seconds of regenerating records (default 60)
 	RefreshSeconds int
+	// MaxStaleSeconds is how long in seconds the last records are served for
+	// while the state of the Mesos masters can't be fetched, after which
+	// mesos-dns exits. Default is 0, which means forever.
+	MaxStaleSeconds int
 	// Which backend resolvers to load (builtin by default)
 	Resolvers map[string]interface{}
 	// Timeout in seconds waiting for the master to return data from StateJson
 	StateTimeoutSeconds int
+	// StateAPI is the API the state of the masters is loaded with: "legacy"
+	// for /master/state.json, "v1" for the GET_STATE call of the v1 Operator
+	// API, or "auto" for the latter if the masters serve it (default).
+	StateAPI string
+	// EventStream enables updating the records with the events streamed by
+	// the SUBSCRIBE call of the v1 Operator API of the leading master, within
+	// a second of them, between the full refreshes every RefreshSeconds.
+	EventStream bool
+	// MesosHTTPSOn makes the masters be requested over HTTPS rather than HTTP
+	MesosHTTPSOn bool
+	// CACertFile is the PEM file of the CA certificates the masters are
+	// verified with over HTTPS, rather than those of the host
+	CACertFile string
+	// CertFile and KeyFile are the PEM files of the client certificate and
+	// key presented to the masters over HTTPS, if any
+	CertFile string
+	KeyFile  string
+	// MesosCredentialsFile is the JSON file of the credentials the masters
+	// are requested with: a "principal" and a "secret" for basic
+	// authentication, or a "token" for bearer authentication. It's read again
+	// when it changes.
+	MesosCredentialsFile string
+	// StateFile is the path, or file:// URL, of a state.json snapshot the
+	// records are generated from, instead of the state of the masters. It's
+	// read again when it changes.
+	StateFile string
+	// SnapshotDir is the directory the raw state responses of the masters are
+	// recorded in, gzipped, for replay and debugging. None are if it's empty.
+	SnapshotDir string
+	// SnapshotCount is how many state responses are kept in SnapshotDir, the
+	// oldest being removed first (default 100)
+	SnapshotCount int
 	// SOA record fields (see http://tools.ietf.org/html/rfc1035#page-18)
 	SOAExpire  uint32 // expiration time
 	SOAMinttl  uint32 // minimum TTL
@@ -37,12 +74,15 @@ type Config struct {
 	SOARefresh uint32 // refresh interval
 	SOARetry   uint32 // retry interval
 	SOARname   string // email of admin esponsible
-	SOASerial  uint32 // initial version number (incremented on refresh)
+	SOASerial  uint32 // initial version number (incremented on refresh when records change)
 	// Zookeeper: a single Zk url
 	Zk string
 	// Zookeeper Detection Timeout: how long in seconds to wait for Zookeeper to
 	// be initially responsive. Default is 30 and 0 means no timeout.
 	ZkDetectionTimeout int
+
+	transport   http.RoundTripper // the masters are requested with, if not the default one
+	credentials *credentials      // the masters are requested with, if any
 }
 
 // NewConfig return the default config of the resolver
@@ -52,6 +92,8 @@ func NewConfig() *Config {
 		IPSources:           []string{"netinfo", "mesos", "host"},
 		RefreshSeconds:      60,
 		StateTimeoutSeconds: 300,
+		StateAPI:            StateAPIAuto,
+		SnapshotCount:       100,
 		SOAExpire:           86400,
 		SOAMinttl:           60,
 		SOAMname:            "ns1.mesos",
@@ -76,10 +118,38 @@ func SetConfig(cjson string) *Config {
 		logging.Error.Fatalf("Masters validation failed: %v", err)
 	}
 
-	if err = validateIPSources(c.IPSources); err != nil {
+	if err = ValidateIPSources(c.IPSources); err != nil {
 		logging.Error.Fatalf("IPSources validation failed: %v", err)
 	}
 
+	if err = validateStateAPI(c.StateAPI); err != nil {
+		logging.Error.Fatalf("StateAPI validation failed: %v", err)
+	}
+
+	if c.EventStream && c.StateAPI == StateAPILegacy {
+		logging.Error.Fatal("EventStream validation failed: the legacy state API has no event stream")
+	}
+
+	if err = ValidateStateFile(c.StateFile); err != nil {
+		logging.Error.Fatalf("StateFile validation failed: %v", err)
+	}
+
+	if c.SnapshotDir != "" && c.SnapshotCount < 1 {
+		logging.Error.Fatalf("SnapshotCount validation failed: %d is not positive", c.SnapshotCount)
+	}
+
+	if c.MesosCredentialsFile != "" && !c.MesosHTTPSOn {
+		logging.Error.Fatal("MesosCredentialsFile validation failed: credentials require MesosHTTPSOn")
+	}
+
+	if c.transport, err = newTransport(c); err != nil {
+		logging.Error.Fatalf("Mesos HTTP client configuration failed: %v", err)
+	}
+
+	if c.credentials, err = newCredentials(c.MesosCredentialsFile); err != nil {
+		logging.Error.Fatalf("MesosCredentialsFile validation failed: %v", err)
+	}
+
 	// Default to builtin config if none have been specified
 	if c.Resolvers == nil {
 		c.Resolvers = map[string]interface{}{"builtin": nil}
@@ -99,6 +169,7 @@ func SetConfig(cjson string) *Config {
 	logging.Verbose.Println("   - IPSources: ", c.IPSources)
 	logging.Verbose.Println("   - Masters: " + strings.Join(c.Masters, ", "))
 	logging.Verbose.Println("   - RefreshSeconds: ", c.RefreshSeconds)
+	logging.Verbose.Println("   - MaxStaleSeconds: ", c.MaxStaleSeconds)
 	logging.Verbose.Println("   - SOAMname: " + c.SOAMname)
 	logging.Verbose.Println("   - SOARname: " + c.SOARname)
 	logging.Verbose.Println("   - SOASerial: ", c.SOASerial)
@@ -106,6 +177,16 @@ func SetConfig(cjson string) *Config {
 	logging.Verbose.Println("   - SOARetry: ", c.SOARetry)
 	logging.Verbose.Println("   - SOAExpire: ", c.SOAExpire)
 	logging.Verbose.Println("   - StateTimeoutSeconds: ", c.StateTimeoutSeconds)
+	logging.Verbose.Println("   - StateAPI: ", c.StateAPI)
+	logging.Verbose.Println("   - EventStream: ", c.EventStream)
+	logging.Verbose.Println("   - MesosHTTPSOn: ", c.MesosHTTPSOn)
+	logging.Verbose.Println("   - CACertFile: ", c.CACertFile)
+	logging.Verbose.Println("   - CertFile: ", c.CertFile)
+	logging.Verbose.Println("   - KeyFile: ", c.KeyFile)
+	logging.Verbose.Println("   - MesosCredentialsFile: ", c.MesosCredentialsFile)
+	logging.Verbose.Println("   - StateFile: ", c.StateFile)
+	logging.Verbose.Println("   - SnapshotDir: ", c.SnapshotDir)
+	logging.Verbose.Println("   - SnapshotCount: ", c.SnapshotCount)
 	logging.Verbose.Println("   - Zookeeper: ", c.Zk)
 	logging.Verbose.Println("   - ZookeeperDetectionTimeout: ", c.ZkDetectionTimeout)
 
@@ -8,7 +8,7 @@ import (
 
 func TestNewConfigValidates(t *testing.T) {
 	c := NewConfig()
-	err := validateIPSources(c.IPSources)
+	err := ValidateIPSources(c.IPSources)
 	if err != nil {
 		t.Error(err)
 	}
@@ -0,0 +1,214 @@
+package records
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/errorutil"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/mesosphere/mesos-dns/utils"
+)
+
+const (
+	// eventBatchDelay is how long events are batched for before the state
+	// they changed is sent, bounding how often records are generated.
+	eventBatchDelay = 500 * time.Millisecond
+	// maxRecordSize bounds the size of the records of event streams.
+	maxRecordSize = 256 << 20
+)
+
+var stateEvents = metrics.NewCounterVec("mesos_dns_state_events_total",
+	"Number of events received from the event stream of the leading Mesos master, by type.",
+	"type")
+
+// Subscribe streams the events of the SUBSCRIBE call of the v1 Operator API
+// of the leading Mesos master of the given address, applies them to its
+// state, and sends that state to the given channel once its events are
+// batched, until the given channel is closed. It reconnects with exponential
+// backoff, up to RefreshSeconds, when the stream breaks, and gives up if the
+// master doesn't serve the v1 Operator API.
+func (rg *RecordGenerator) Subscribe(addr string, states chan<- state.State, done <-chan struct{}) {
+	max := time.Duration(rg.Config.RefreshSeconds) * time.Second
+	var backoff time.Duration
+	for {
+		subscribed, err := rg.subscribe(addr, states, done)
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		if err == errOperatorUnsupported {
+			logging.Error.Printf("master %s doesn't serve the v1 Operator API, not streaming its events", addr)
+			return
+		}
+		if subscribed {
+			backoff = 0 // the stream worked for a while
+		}
+		if backoff *= 2; backoff == 0 {
+			backoff = time.Second
+		}
+		if backoff > max {
+			backoff = max
+		}
+		logging.Error.Printf("event stream of master %s broke: %v; reconnecting in %v", addr, err, backoff)
+
+		select {
+		case <-done:
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// subscribe streams the events of the leading Mesos master of the given
+// address, as Subscribe does, until the stream breaks or the given channel is
+// closed. It returns whether it was subscribed to the stream, i.e. whether it
+// received its SUBSCRIBED event, and why the stream broke.
+func (rg *RecordGenerator) subscribe(addr string, states chan<- state.State, done <-chan struct{}) (subscribed bool, err error) {
+	var master state.OperatorResponse
+	if _, err = rg.callOperator(addr, "GET_MASTER", &master); err != nil {
+		return false, err
+	}
+	if master.GetMaster == nil {
+		return false, fmt.Errorf("unexpected v1 Operator API response %q from %s", master.Type, addr)
+	}
+	leader := master.GetMaster.MasterInfo.Leader()
+
+	req, err := http.NewRequest("POST", rg.masterURL(addr, "/api/v1"),
+		strings.NewReader(`{"type":"SUBSCRIBE"}`))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Cancel = done
+
+	// The stream lasts as long as the master leads, so only the liveness of
+	// the connection is bounded, by the heartbeats of the master.
+	client := rg.httpClient
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer errorutil.Ignore(resp.Body.Close)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return false, errOperatorUnsupported
+	default:
+		return false, fmt.Errorf("SUBSCRIBE call to %s failed: %s", addr, resp.Status)
+	}
+
+	events := make(chan *state.OperatorEvent)
+	errs := make(chan error, 1)
+	quit := make(chan struct{}) // the events aren't received anymore
+	defer close(quit)
+	go func() {
+		r := newRecordIOReader(resp.Body)
+		for {
+			var e state.OperatorEvent
+			rec, err := r.ReadRecord()
+			if err == nil {
+				err = json.Unmarshal(rec, &e)
+			}
+			if err != nil {
+				errs <- err
+				close(events)
+				return
+			}
+			select {
+			case events <- &e:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	// Until SUBSCRIBED, which tells the interval of the heartbeats of the
+	// master, the connection is bounded by StateTimeoutSeconds.
+	var (
+		st       state.OperatorState
+		batch    <-chan time.Time
+		liveness = time.Duration(rg.Config.StateTimeoutSeconds) * time.Second
+		watchdog = time.NewTimer(liveness)
+	)
+	defer watchdog.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return subscribed, <-errs
+			}
+			stateEvents.WithLabelValues(e.Type).Inc()
+			if s := e.Subscribed; s != nil {
+				subscribed = true
+				if s.HeartbeatIntervalSeconds > 0 {
+					// tolerates missing a couple of heartbeats
+					liveness = 3 * time.Duration(s.HeartbeatIntervalSeconds*float64(time.Second))
+				}
+			}
+			utils.ResetTimer(watchdog, liveness)
+			if st.Apply(e) && batch == nil {
+				batch = time.After(eventBatchDelay)
+			}
+		case <-batch:
+			batch = nil
+			sj := st.State()
+			sj.Leader = leader
+			select {
+			case states <- sj:
+			case <-done:
+				return subscribed, nil
+			}
+		case <-watchdog.C:
+			return subscribed, fmt.Errorf("no events for %v", liveness)
+		case <-done:
+			return subscribed, nil
+		}
+	}
+}
+
+// A recordIOReader reads the records of RecordIO streams, which are prefixed
+// with their size in bytes, in decimal, followed by a line feed.
+type recordIOReader struct {
+	r *bufio.Reader
+}
+
+func newRecordIOReader(r io.Reader) *recordIOReader {
+	return &recordIOReader{r: bufio.NewReader(r)}
+}
+
+// ReadRecord reads the next record.
+func (r *recordIOReader) ReadRecord() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	n, err := strconv.ParseUint(string(bytes.TrimSpace(line)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RecordIO size: %v", err)
+	}
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("RecordIO record of %d bytes exceeds %d", n, maxRecordSize)
+	}
+	rec := make([]byte, n)
+	if _, err = io.ReadFull(r.r, rec); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return rec, err
+}
@@ -0,0 +1,137 @@
+package records
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+func TestRecordIOReader(t *testing.T) {
+	for i, tt := range []struct {
+		in   string
+		recs []string
+		err  error
+	}{
+		{"", nil, io.EOF},
+		{"5\nhello", []string{"hello"}, io.EOF},
+		{"5\nhello0\n2\n{}", []string{"hello", "", "{}"}, io.EOF},
+		{"5\nhell", nil, io.ErrUnexpectedEOF},
+		{"5", nil, io.ErrUnexpectedEOF},
+		{"x\n", nil, fmt.Errorf("invalid RecordIO size: %v", `strconv.ParseUint: parsing "x": invalid syntax`)},
+	} {
+		r := newRecordIOReader(strings.NewReader(tt.in))
+		var recs []string
+		var err error
+		for {
+			var rec []byte
+			if rec, err = r.ReadRecord(); err != nil {
+				break
+			}
+			recs = append(recs, string(rec))
+		}
+		if !reflect.DeepEqual(recs, tt.recs) {
+			t.Errorf("test #%d: got records %q, want %q", i, recs, tt.recs)
+		}
+		if err.Error() != tt.err.Error() {
+			t.Errorf("test #%d: got error %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// streamHandler serves the GET_MASTER and SUBSCRIBE calls of the v1 Operator
+// API, streaming the given events, RecordIO framed, until the test ends.
+type streamHandler struct {
+	events []string
+	done   chan struct{}
+}
+
+func (h *streamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var call struct{ Type string }
+	if err := json.NewDecoder(r.Body).Decode(&call); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch call.Type {
+	case "GET_MASTER":
+		io.WriteString(w, `{"type":"GET_MASTER","get_master":{"master_info":{"pid":"master@10.0.0.1:5050"}}}`)
+	case "SUBSCRIBE":
+		for _, e := range h.events {
+			fmt.Fprintf(w, "%d\n%s", len(e), e)
+		}
+		w.(http.Flusher).Flush()
+		select {
+		case <-h.done:
+		case <-w.(http.CloseNotifier).CloseNotify():
+		}
+	default:
+		http.Error(w, "unexpected call", http.StatusBadRequest)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	h := &streamHandler{
+		events: []string{
+			`{"type":"SUBSCRIBED","subscribed":{"get_state":{"get_frameworks":{"frameworks":[{"framework_info":{"id":{"value":"f1"},"name":"marathon"}}]}},"heartbeat_interval_seconds":15}}`,
+			`{"type":"TASK_ADDED","task_added":{"task":{"task_id":{"value":"t1"},"framework_id":{"value":"f1"},"name":"nginx","state":"TASK_RUNNING"}}}`,
+			`{"type":"HEARTBEAT"}`,
+		},
+		done: make(chan struct{}),
+	}
+	server := httptest.NewServer(h)
+	defer server.Close()
+	defer close(h.done)
+
+	rg := NewRecordGenerator(NewConfig())
+	states := make(chan state.State)
+	done := make(chan struct{})
+	returned := make(chan struct{})
+	go func() {
+		rg.Subscribe(server.Listener.Addr().String(), states, done)
+		close(returned)
+	}()
+
+	select {
+	case sj := <-states:
+		if sj.Leader != "master@10.0.0.1:5050" {
+			t.Errorf("got leader %q, want %q", sj.Leader, "master@10.0.0.1:5050")
+		}
+		if len(sj.Frameworks) != 1 || len(sj.Frameworks[0].Tasks) != 1 || sj.Frameworks[0].Tasks[0].Name != "nginx" {
+			t.Errorf("got frameworks %+v, want marathon with nginx", sj.Frameworks)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no state streamed")
+	}
+
+	close(done)
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe didn't return")
+	}
+}
+
+func TestSubscribeUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	rg := NewRecordGenerator(NewConfig())
+	returned := make(chan struct{})
+	go func() {
+		rg.Subscribe(server.Listener.Addr().String(), nil, make(chan struct{}))
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscribe didn't give up on a master without the v1 Operator API")
+	}
+}
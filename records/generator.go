@@ -13,6 +13,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/mesosphere/mesos-dns/errorutil"
@@ -20,6 +21,7 @@ import (
 	"github.com/mesosphere/mesos-dns/models"
 	"github.com/mesosphere/mesos-dns/records/labels"
 	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/miekg/dns"
 	"github.com/tv42/zbase32"
 )
 
@@ -75,6 +77,10 @@ const (
 	A rrsKind = "A"
 	// SRV record types
 	SRV = "SRV"
+	// AAAA record types
+	AAAA rrsKind = "AAAA"
+	// PTR record types
+	PTR rrsKind = "PTR"
 )
 
 func (kind rrsKind) rrs(rg *RecordGenerator) rrs {
@@ -83,6 +89,10 @@ func (kind rrsKind) rrs(rg *RecordGenerator) rrs {
 		return rg.As
 	case SRV:
 		return rg.SRVs
+	case AAAA:
+		return rg.AAAAs
+	case PTR:
+		return rg.PTRs
 	default:
 		return nil
 	}
@@ -92,12 +102,15 @@ func (kind rrsKind) rrs(rg *RecordGenerator) rrs {
 // them. TODO(kozyraki): Refactor when discovery id is available.
 type RecordGenerator struct {
 	As         rrs
+	AAAAs      rrs
 	Config     *Config
+	PTRs       rrs
 	SRVs       rrs
 	State      state.State
 	SlaveIPs   map[string]string
 	EnumData   EnumerationData
 	httpClient http.Client
+	snapshot   *Snapshot // of the last state loaded from a master, if recorded
 }
 
 // EnumerableRecord is the lowest level object, and should map 1:1 with DNS records
@@ -136,36 +149,60 @@ func NewRecordGenerator(config *Config) *RecordGenerator {
 
 	rg := &RecordGenerator{
 		Config:     config,
-		httpClient: http.Client{Timeout: httpTimeout},
+		httpClient: http.Client{Timeout: httpTimeout, Transport: config.roundTripper()},
 		EnumData:   enumData,
 	}
 
 	return rg
 }
 
-// ParseState retrieves and parses the Mesos master /state.json and converts it
-// into DNS records.
-func (rg *RecordGenerator) ParseState() error {
-	// find master -- return if error
-	sj, err := rg.findMaster(rg.Config.Masters)
-	if err != nil {
+// ParseState retrieves and parses the Mesos master /state.json, or the
+// StateFile if any, and converts it into DNS records.
+func (rg *RecordGenerator) ParseState() (err error) {
+	defer func(start time.Time) { rg.observeState(start, err) }(time.Now())
+
+	var sj state.State
+	if rg.Config.StateFile != "" {
+		sj, err = loadStateFile(rg.Config.StateFile)
+	} else if sj, err = rg.findMaster(rg.Config.Masters); err != nil { // find master -- return if error
 		logging.Error.Println("no master")
+	}
+	if err != nil {
 		return err
 	}
+	rg.recordSnapshot()
 
+	return rg.LoadState(sj)
+}
+
+// LoadState converts the given state of the leading Mesos master into DNS
+// records, as configured.
+func (rg *RecordGenerator) LoadState(sj state.State) error {
 	if sj.Leader == "" {
 		logging.Error.Println("Unexpected error")
-		err = errors.New("empty master")
-		return err
+		return errors.New("empty master")
 	}
 
-	hostSpec := labels.RFC1123
+	return rg.InsertState(sj, rg.Config.Domain, rg.Config.SOARname, rg.Config.Masters,
+		rg.Config.IPSources, rg.hostSpec())
+}
+
+// View returns a RecordGenerator of the same state, whose task records are
+// generated from the given IP sources rather than the configured ones.
+func (rg *RecordGenerator) View(ipSources []string) (*RecordGenerator, error) {
+	config := *rg.Config
+	config.IPSources = ipSources
+	view := NewRecordGenerator(&config)
+	return view, view.InsertState(rg.State, config.Domain, config.SOARname, config.Masters,
+		ipSources, rg.hostSpec())
+}
+
+// hostSpec returns the labels.Func host names are generated with.
+func (rg *RecordGenerator) hostSpec() labels.Func {
 	if rg.Config.EnforceRFC952 {
-		hostSpec = labels.RFC952
+		return labels.RFC952
 	}
-
-	return rg.InsertState(sj, rg.Config.Domain, rg.Config.SOARname, rg.Config.Masters,
-		rg.Config.IPSources, hostSpec)
+	return labels.RFC1123
 }
 
 // Tries each master and looks for the leader
@@ -217,18 +254,47 @@ func (rg *RecordGenerator) findMaster(masters []string) (state.State, error) {
 	return sj, errors.New("no master")
 }
 
-// Loads state.json from mesos master
+// loadFromMaster loads the state of the Mesos master of the given address
+// with its configured StateAPI.
 func (rg *RecordGenerator) loadFromMaster(ip string, port string) (state.State, error) {
-	// REFACTOR: state.json security
+	addr := net.JoinHostPort(ip, port)
+	switch rg.stateAPI(addr) {
+	case StateAPIV1:
+		return rg.loadFromOperator(addr)
+	case StateAPILegacy:
+		return rg.loadStateJSON(addr)
+	}
+
+	sj, err := rg.loadFromOperator(addr)
+	switch err {
+	case nil:
+		detectedAPIs.set(addr, StateAPIV1)
+	case errOperatorUnsupported:
+		logging.Verbose.Printf("master %s doesn't serve the v1 Operator API, falling back to state.json", addr)
+		detectedAPIs.set(addr, StateAPILegacy)
+		return rg.loadStateJSON(addr)
+	}
+	return sj, err
+}
 
-	var sj state.State
+// masterURL returns the URL of the given path on the Mesos master of the
+// given address.
+func (rg *RecordGenerator) masterURL(addr, path string) string {
 	u := url.URL{
 		Scheme: "http",
-		Host:   net.JoinHostPort(ip, port),
-		Path:   "/master/state.json",
+		Host:   addr,
+		Path:   path,
+	}
+	if rg.Config.MesosHTTPSOn {
+		u.Scheme = "https"
 	}
+	return u.String()
+}
 
-	req, err := http.NewRequest("GET", u.String(), nil)
+// Loads state.json from mesos master
+func (rg *RecordGenerator) loadStateJSON(addr string) (state.State, error) {
+	var sj state.State
+	req, err := http.NewRequest("GET", rg.masterURL(addr, "/master/state.json"), nil)
 	if err != nil {
 		logging.Error.Println(err)
 		return state.State{}, err
@@ -255,6 +321,7 @@ func (rg *RecordGenerator) loadFromMaster(ip string, port string) (state.State,
 		return state.State{}, err
 	}
 
+	rg.loaded(addr, StateAPILegacy, body)
 	return sj, nil
 }
 
@@ -289,15 +356,20 @@ func hashString(s string) string {
 	return zbase32.EncodeToString(hash[:])[:5]
 }
 
-// attempt to translate the hostname into an IPv4 address. logs an error if IP
-// lookup fails. if an IP address cannot be found, returns the same hostname
-// that was given. upon success returns the IP address as a string.
-func hostToIP4(hostname string) (string, bool) {
+// attempt to translate the hostname into an IP address. IP literals of either
+// family are returned as they are, other hostnames are resolved to an IPv4
+// address with a fallback to IPv6. logs an error if IP lookup fails. if an IP
+// address cannot be found, returns the same hostname that was given. upon
+// success returns the IP address as a string.
+func hostToIP(hostname string) (string, bool) {
 	ip := net.ParseIP(hostname)
 	if ip == nil {
 		t, err := net.ResolveIPAddr("ip4", hostname)
 		if err != nil {
-			logging.Error.Printf("cannot translate hostname %q into an ip4 address", hostname)
+			t, err = net.ResolveIPAddr("ip6", hostname)
+		}
+		if err != nil {
+			logging.Error.Printf("cannot translate hostname %q into an ip address", hostname)
 			return hostname, false
 		}
 		ip = t.IP
@@ -305,33 +377,110 @@ func hostToIP4(hostname string) (string, bool) {
 	return ip.String(), true
 }
 
+// addrKind returns the kind of address record (A or AAAA) matching the family
+// of the given IP address. anything that isn't an IPv6 address is considered
+// to be an A record, as it has always been.
+func addrKind(addr string) rrsKind {
+	if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+		return AAAA
+	}
+	return A
+}
+
+// firstIPs returns the string representation of the first IPv4 and of the
+// first IPv6 address in the given slice. either is empty if no address of
+// its family is found.
+func firstIPs(ips []net.IP) (ip4, ip6 string) {
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			if ip4 == "" {
+				ip4 = ip.String()
+			}
+		} else if ip6 == "" {
+			ip6 = ip.String()
+		}
+	}
+	return ip4, ip6
+}
+
 // InsertState transforms a StateJSON into RecordGenerator RRs
 func (rg *RecordGenerator) InsertState(sj state.State, domain string, ns string, masters, ipSources []string, spec labels.Func) error {
 
 	rg.SlaveIPs = map[string]string{}
 	rg.SRVs = rrs{}
 	rg.As = rrs{}
+	rg.AAAAs = rrs{}
+	rg.PTRs = rrs{}
 	rg.frameworkRecords(sj, domain, spec)
 	rg.slaveRecords(sj, domain, spec)
 	rg.masterRecord(domain, masters, sj.Leader)
 	rg.taskRecords(sj, domain, spec, ipSources)
 	rg.State = sj
 
-	rg.Config.SOASerial = uint32(time.Now().Unix())
-
+	// The SOA serial is bumped by UpdateSerial, and only if the records
+	// changed, so that secondaries don't transfer identical zones.
 	return nil
 }
 
-// frameworkRecords injects A and SRV records into the generator store:
+// UpdateSerial bumps the SOA serial of the RecordGenerator's configuration,
+// following the one of the given previous RecordGenerator, if any, unless
+// they hold the same records. It returns whether the serial was bumped.
+func (rg *RecordGenerator) UpdateSerial(prev *RecordGenerator) bool {
+	serial := atomic.LoadUint32(&rg.Config.SOASerial)
+	if prev != nil {
+		if serial = atomic.LoadUint32(&prev.Config.SOASerial); rg.sameRecords(prev) {
+			atomic.StoreUint32(&rg.Config.SOASerial, serial)
+			return false
+		}
+	}
+	atomic.StoreUint32(&rg.Config.SOASerial, nextSerial(serial))
+	return true
+}
+
+// nextSerial returns the SOA serial following the given one. It's the
+// current UNIX time, as it has always been, unless that doesn't increase the
+// serial (see RFC 1982), e.g. when records change twice within a second.
+func nextSerial(serial uint32) uint32 {
+	if next := uint32(time.Now().Unix()); int32(next-serial) > 0 {
+		return next
+	}
+	return serial + 1
+}
+
+// sameRecords returns true if both RecordGenerators hold the same records.
+func (rg *RecordGenerator) sameRecords(other *RecordGenerator) bool {
+	as := []rrs{rg.As, rg.AAAAs, rg.SRVs, rg.PTRs}
+	bs := []rrs{other.As, other.AAAAs, other.SRVs, other.PTRs}
+	for i := range as {
+		if len(as[i]) != len(bs[i]) {
+			return false
+		}
+		for name, hosts := range as[i] {
+			others := bs[i][name]
+			if len(hosts) != len(others) {
+				return false
+			}
+			for host := range hosts {
+				if _, ok := others[host]; !ok {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// frameworkRecords injects A (or AAAA), PTR and SRV records into the generator store:
 //     frameworkname.domain.                 // resolves to IPs of each framework
 //     _framework._tcp.frameworkname.domain. // resolves to the driver port and IP of each framework
 func (rg *RecordGenerator) frameworkRecords(sj state.State, domain string, spec labels.Func) {
 	for _, f := range sj.Frameworks {
 		fname := labels.DomainFrag(f.Name, labels.Sep, spec)
 		host, port := f.HostPort()
-		if address, ok := hostToIP4(host); ok {
+		if address, ok := hostToIP(host); ok {
 			a := fname + "." + domain + "."
-			rg.insertRR(a, address, A)
+			rg.insertRR(a, address, addrKind(address))
+			rg.insertPTR(address, a)
 			if port != "" {
 				srvAddress := net.JoinHostPort(a, port)
 				rg.insertRR("_framework._tcp."+a, srvAddress, SRV)
@@ -340,15 +489,16 @@ func (rg *RecordGenerator) frameworkRecords(sj state.State, domain string, spec
 	}
 }
 
-// slaveRecords injects A and SRV records into the generator store:
+// slaveRecords injects A (or AAAA), PTR and SRV records into the generator store:
 //     slave.domain.      // resolves to IPs of all slaves
 //     _slave._tc.domain. // resolves to the driver port and IP of all slaves
 func (rg *RecordGenerator) slaveRecords(sj state.State, domain string, spec labels.Func) {
 	for _, slave := range sj.Slaves {
-		address, ok := hostToIP4(slave.PID.Host)
+		address, ok := hostToIP(slave.PID.Host)
 		if ok {
 			a := "slave." + domain + "."
-			rg.insertRR(a, address, A)
+			rg.insertRR(a, address, addrKind(address))
+			rg.insertPTR(address, a)
 			srv := net.JoinHostPort(a, slave.PID.Port)
 			rg.insertRR("_slave._tcp."+domain+".", srv, SRV)
 		} else {
@@ -364,6 +514,9 @@ func (rg *RecordGenerator) slaveRecords(sj state.State, domain string, spec labe
 //     masterN.domain. // one IP address for each master
 //     leader.domain.  // one IP address for the leading master
 //
+// The IPs of the leader and of each masterN record also get PTR records
+// pointing back to those names.
+//
 // The current func implementation makes an assumption about the order of masters:
 // it's the order in which you expect the enumerated masterN records to be created.
 // This is probably important: if a new leader is elected, you may not want it to
@@ -401,6 +554,7 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 	}
 	arec := "leader." + domain + "."
 	rg.insertRR(arec, ip, A)
+	rg.insertPTR(ip, arec)
 	arec = "master." + domain + "."
 	rg.insertRR(arec, ip, A)
 
@@ -438,6 +592,7 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 
 		arec := "master" + strconv.Itoa(idx) + "." + domain + "."
 		rg.insertRR(arec, masterIP, A)
+		rg.insertPTR(masterIP, arec)
 		idx++
 
 		if master == leaderAddress {
@@ -452,6 +607,7 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 		}
 		arec = "master" + strconv.Itoa(idx) + "." + domain + "."
 		rg.insertRR(arec, ip, A)
+		rg.insertPTR(ip, arec)
 	}
 }
 
@@ -491,6 +647,7 @@ type context struct {
 	taskID,
 	slaveID,
 	taskIP,
+	taskIP6,
 	slaveIP string
 }
 
@@ -501,11 +658,22 @@ func (rg *RecordGenerator) taskRecord(task state.Task, f state.Framework, domain
 	enumFW.Tasks = append(enumFW.Tasks, newTask)
 
 	// define context
+	// both addresses are those of the first IP source having any, so that
+	// e.g. a task on an IPv6 only network isn't given the IPv4 address of
+	// its host
+	var taskIP, taskIP6 string
+	for _, src := range ipSources {
+		if ips := task.IPs(src); len(ips) > 0 {
+			taskIP, taskIP6 = firstIPs(ips)
+			break
+		}
+	}
 	ctx := context{
 		spec(task.Name),
 		hashString(task.ID),
 		slaveIDTail(task.SlaveID),
-		task.IP(ipSources...),
+		taskIP,
+		taskIP6,
 		task.SlaveIP,
 	}
 
@@ -522,6 +690,15 @@ func (rg *RecordGenerator) taskRecord(task state.Task, f state.Framework, domain
 		rg.taskContextRecord(ctx, task, f, domain, spec, newTask)
 	}
 
+	// insert PTR records to the canonical name of tasks with IPs of their
+	// own; agent IPs are already mapped back to the agents by slaveRecords
+	fname := labels.DomainFrag(f.Name, labels.Sep, spec)
+	canonical := ctx.taskName + "-" + ctx.taskID + "-" + ctx.slaveID + "." + fname + "." + domain + "."
+	for _, ip := range []string{ctx.taskIP, ctx.taskIP6} {
+		if ip != ctx.slaveIP {
+			rg.insertPTR(ip, canonical)
+		}
+	}
 }
 func (rg *RecordGenerator) taskContextRecord(ctx context, task state.Task, f state.Framework, domain string, spec labels.Func, enumTask *EnumerableTask) {
 	fname := labels.DomainFrag(f.Name, labels.Sep, spec)
@@ -535,8 +712,13 @@ func (rg *RecordGenerator) taskContextRecord(ctx context, task state.Task, f sta
 	rg.insertTaskRR(arec+tail, ctx.taskIP, A, enumTask)
 	rg.insertTaskRR(canonical+tail, ctx.taskIP, A, enumTask)
 
-	rg.insertTaskRR(arec+".slave"+tail, ctx.slaveIP, A, enumTask)
-	rg.insertTaskRR(canonical+".slave"+tail, ctx.slaveIP, A, enumTask)
+	// insert canonical AAAA records
+	rg.insertTaskRR(arec+tail, ctx.taskIP6, AAAA, enumTask)
+	rg.insertTaskRR(canonical+tail, ctx.taskIP6, AAAA, enumTask)
+
+	slaveKind := addrKind(ctx.slaveIP)
+	rg.insertTaskRR(arec+".slave"+tail, ctx.slaveIP, slaveKind, enumTask)
+	rg.insertTaskRR(canonical+".slave"+tail, ctx.slaveIP, slaveKind, enumTask)
 
 	// recordName generates records for ctx.taskName, given some generation chain
 	recordName := func(gen chain) { gen("_" + ctx.taskName) }
@@ -577,7 +759,7 @@ func (rg *RecordGenerator) taskContextRecord(ctx context, task state.Task, f sta
 	}
 }
 
-// A records for each local interface
+// A and AAAA records for each local interface
 // If this causes problems you should explicitly set the
 // listener address in config.json
 func (rg *RecordGenerator) setFromLocal(host string, ns string) {
@@ -604,16 +786,15 @@ func (rg *RecordGenerator) setFromLocal(host string, ns string) {
 				ip = v.IP
 			}
 
-			if ip == nil || ip.IsLoopback() {
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
 				continue
 			}
 
-			ip = ip.To4()
-			if ip == nil {
-				continue
+			if ip4 := ip.To4(); ip4 != nil {
+				rg.insertRR(ns, ip4.String(), A)
+			} else {
+				rg.insertRR(ns, ip.String(), AAAA)
 			}
-
-			rg.insertRR(ns, ip.String(), A)
 		}
 	}
 }
@@ -630,6 +811,17 @@ func (rg *RecordGenerator) insertTaskRR(name, host string, kind rrsKind, enumTas
 	return false
 }
 
+// insertPTR adds a PTR record mapping the reverse name of the given address
+// (in-addr.arpa. or ip6.arpa.) to the given name. addresses that aren't valid
+// IPs are ignored. returns true if added, false otherwise.
+func (rg *RecordGenerator) insertPTR(addr, name string) bool {
+	arpa, err := dns.ReverseAddr(addr)
+	if err != nil {
+		return false
+	}
+	return rg.insertRR(arpa, name, PTR)
+}
+
 func (rg *RecordGenerator) insertRR(name, host string, kind rrsKind) (added bool) {
 	if rrs := kind.rrs(rg); rrs != nil {
 		if added = rrs.add(name, host); added {
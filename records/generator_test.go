@@ -2,6 +2,7 @@ package records
 
 import (
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
@@ -9,8 +10,10 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"sort"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/mesosphere/mesos-dns/records/labels"
@@ -203,6 +206,7 @@ func TestInsertState(t *testing.T) {
 	rgDocker := testRecordGenerator(t, labels.RFC952, []string{"docker", "host"})
 	rgMesos := testRecordGenerator(t, labels.RFC952, []string{"mesos", "host"})
 	rgSlave := testRecordGenerator(t, labels.RFC952, []string{"host"})
+	rgNetinfo := testRecordGenerator(t, labels.RFC952, []string{"netinfo", "mesos", "host"})
 
 	for i, tt := range []struct {
 		rrs  rrs
@@ -269,6 +273,26 @@ func TestInsertState(t *testing.T) {
 		{rgDocker.As, "liquor-store.marathon.slave.mesos.", []string{"1.2.3.11", "1.2.3.12"}},
 		{rgDocker.As, "nginx.marathon.mesos.", []string{"1.2.3.11"}},
 		{rgDocker.As, "car-store.marathon.slave.mesos.", []string{"1.2.3.11"}},
+
+		{rg.AAAAs, "nginx.marathon.mesos.", nil},
+		{rgNetinfo.As, "nginx.marathon.mesos.", nil}, // netinfo only has an IPv6 address
+		{rgNetinfo.AAAAs, "nginx.marathon.mesos.", []string{"fd01:b::1:8000:3"}},
+		{rgNetinfo.AAAAs, "nginx.marathon.slave.mesos.", nil},
+		{rgNetinfo.As, "liquor-store.marathon.mesos.", []string{"1.2.3.11", "1.2.3.12"}},
+		{rgNetinfo.AAAAs, "liquor-store.marathon.mesos.", nil},
+
+		{rg.PTRs, "37.157.76.144.in-addr.arpa.", []string{"leader.mesos.", "master0.mesos."}},
+		{rg.PTRs, "10.3.2.1.in-addr.arpa.", []string{"slave.mesos."}},
+		{rg.PTRs, "11.3.2.1.in-addr.arpa.", []string{"slave.mesos.", "marathon.mesos."}},
+		{rg.PTRs, "1.0.3.10.in-addr.arpa.", []string{
+			"big-dog-4dfjd-0.marathon.mesos.",
+			"liquor-store-4dfjd-0.marathon.mesos.",
+		}},
+		{rg.PTRs, "3.0.3.10.in-addr.arpa.", []string{"nginx-6ud99-0.marathon.mesos."}},
+		{rgSlave.PTRs, "3.0.3.10.in-addr.arpa.", nil},
+		{rgNetinfo.PTRs, "3.0.0.0.0.0.0.8.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.b.0.0.0.1.0.d.f.ip6.arpa.", []string{
+			"nginx-6ud99-0.marathon.mesos.",
+		}},
 	} {
 		// convert want into a map[string]struct{} (string set) for simpler comparison
 		// via reflect.DeepEqual
@@ -301,6 +325,141 @@ func TestNTasks(t *testing.T) {
 	}
 }
 
+// ensure views regenerate the records with their own IP sources, leaving
+// the configuration of the original generator alone
+func TestView(t *testing.T) {
+	rg := testRecordGenerator(t, labels.RFC952, []string{"docker", "mesos", "host"})
+	view, err := rg.View([]string{"host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := "liquor-store.marathon.mesos."
+	for i, tt := range []struct {
+		rg   *RecordGenerator
+		want []string
+	}{
+		{&rg, []string{"10.3.0.1", "10.3.0.2"}},
+		{view, []string{"1.2.3.11", "1.2.3.12"}},
+	} {
+		var got []string
+		for host := range tt.rg.As[name] {
+			got = append(got, host)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got %v, want %v", i, got, tt.want)
+		}
+	}
+	if got := rg.Config.IPSources; reflect.DeepEqual(got, view.Config.IPSources) {
+		t.Errorf("got IP sources %v of the view configured", got)
+	}
+}
+
+func TestAddrKind(t *testing.T) {
+	for i, tt := range []struct {
+		addr string
+		want rrsKind
+	}{
+		{"1.2.3.4", A},
+		{"::ffff:1.2.3.4", A},
+		{"fd01:b::1", AAAA},
+		{"2001:db8::1", AAAA},
+		{"some-host", A},
+		{"", A},
+	} {
+		if got := addrKind(tt.addr); got != tt.want {
+			t.Errorf("test #%d: addrKind(%q): got %q, want %q", i, tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestFirstIPs(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("fd01:b::1"),
+		net.ParseIP("10.0.0.1"),
+		net.ParseIP("fd01:b::2"),
+		net.ParseIP("10.0.0.2"),
+	}
+	if ip4, ip6 := firstIPs(ips); ip4 != "10.0.0.1" || ip6 != "fd01:b::1" {
+		t.Errorf("got (%q, %q), want (%q, %q)", ip4, ip6, "10.0.0.1", "fd01:b::1")
+	}
+	if ip4, ip6 := firstIPs(nil); ip4 != "" || ip6 != "" {
+		t.Errorf("got (%q, %q), want empty addresses", ip4, ip6)
+	}
+}
+
+func TestTaskRecordIPSources(t *testing.T) {
+	task := state.Task{
+		ID:      "nginx.1",
+		Name:    "nginx",
+		SlaveIP: "1.2.3.11",
+		Statuses: []state.Status{{
+			State: "TASK_RUNNING",
+			ContainerStatus: state.ContainerStatus{NetworkInfos: []state.NetworkInfo{{
+				IPAddresses: []state.IPAddress{{IPAddress: "fd01:b::1:8000:3"}},
+			}}},
+		}},
+	}
+	for i, tt := range []struct {
+		ipSources []string
+		a, aaaa   []string
+	}{
+		{[]string{"netinfo", "host"}, nil, []string{"fd01:b::1:8000:3"}},
+		{[]string{"host", "netinfo"}, []string{"1.2.3.11"}, nil},
+	} {
+		rg := &RecordGenerator{As: rrs{}, AAAAs: rrs{}, SRVs: rrs{}, PTRs: rrs{}}
+		rg.taskRecord(task, state.Framework{Name: "marathon"}, "mesos", labels.RFC952, tt.ipSources, &EnumerableFramework{})
+
+		name := "nginx.marathon.mesos."
+		for _, c := range []struct {
+			rrs  rrs
+			want []string
+		}{{rg.As, tt.a}, {rg.AAAAs, tt.aaaa}} {
+			var got []string
+			for addr := range c.rrs[name] {
+				got = append(got, addr)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("test #%d: got %v, want %v", i, got, c.want)
+			}
+		}
+	}
+}
+
+func TestUpdateSerial(t *testing.T) {
+	prev := testRecordGenerator(t, labels.RFC952, []string{"host"})
+	prev.Config = &Config{SOASerial: 42}
+
+	rg := testRecordGenerator(t, labels.RFC952, []string{"host"})
+	rg.Config = &Config{}
+	if rg.UpdateSerial(&prev) || rg.Config.SOASerial != 42 {
+		t.Errorf("unchanged records: got serial %d, want 42", rg.Config.SOASerial)
+	}
+
+	rg.As["new.mesos."] = map[string]struct{}{"1.2.3.4": {}}
+	if !rg.UpdateSerial(&prev) || rg.Config.SOASerial <= 42 {
+		t.Errorf("changed records: got serial %d, want a new one", rg.Config.SOASerial)
+	}
+}
+
+func TestNextSerial(t *testing.T) {
+	now := uint32(time.Now().Unix())
+	for i, tt := range []struct {
+		serial uint32
+		want   func(uint32) bool
+	}{
+		{0, func(s uint32) bool { return s >= now }},
+		{now - 10, func(s uint32) bool { return s >= now }},
+		{now + 10, func(s uint32) bool { return s == now+11 }},
+		{now + 1<<31 - 1, func(s uint32) bool { return s == now+1<<31 }},
+	} {
+		if got := nextSerial(tt.serial); !tt.want(got) {
+			t.Errorf("test #%d: unexpected serial following %d: %d", i, tt.serial, got)
+		}
+	}
+}
+
 func TestHashString(t *testing.T) {
 	val := hashString("test")
 	if len(val) != 5 {
@@ -334,6 +493,7 @@ func TestTimeout(t *testing.T) {
 
 	sleepForeverHandler := func(w http.ResponseWriter, req *http.Request) {
 		req.Close = true
+		_, _ = io.Copy(ioutil.Discard, req.Body) // or the close isn't noticed
 		notify := w.(http.CloseNotifier).CloseNotify()
 		<-notify
 	}
@@ -0,0 +1,38 @@
+package records
+
+import (
+	"time"
+
+	"github.com/mesosphere/mesos-dns/metrics"
+)
+
+var (
+	stateFetchDuration = metrics.NewHistogram("mesos_dns_state_fetch_duration_seconds",
+		"Duration of the fetches of the state of the Mesos masters.",
+		[]float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300})
+	stateFetchFailures = metrics.NewCounter("mesos_dns_state_fetch_failures_total",
+		"Number of failed fetches of the state of the Mesos masters.")
+	stateFetchTimestamp = metrics.NewGauge("mesos_dns_state_fetch_timestamp_seconds",
+		"Time of the last successful fetch of the state of the Mesos masters.")
+	recordCount = metrics.NewGaugeVec("mesos_dns_records",
+		"Number of records of the last generation, by type.", "type")
+)
+
+// observeState updates the metrics of a fetch of the state of the Mesos
+// masters started at the given time and of the records generated from it,
+// if it didn't fail.
+func (rg *RecordGenerator) observeState(start time.Time, err error) {
+	stateFetchDuration.ObserveSince(start)
+	if err != nil {
+		stateFetchFailures.Inc()
+		return
+	}
+	stateFetchTimestamp.SetToTime(time.Now())
+	for _, kind := range []rrsKind{A, AAAA, SRV, PTR} {
+		n := 0
+		for _, hosts := range kind.rrs(rg) {
+			n += len(hosts)
+		}
+		recordCount.WithLabelValues(string(kind)).Set(float64(n))
+	}
+}
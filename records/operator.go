@@ -0,0 +1,131 @@
+package records
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/mesosphere/mesos-dns/errorutil"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// APIs the state of the Mesos masters is loaded with.
+const (
+	// StateAPIAuto uses the v1 Operator API of the masters serving it, and
+	// the legacy state.json endpoint of the others.
+	StateAPIAuto = "auto"
+	// StateAPILegacy uses the legacy /master/state.json endpoint.
+	StateAPILegacy = "legacy"
+	// StateAPIV1 uses the GET_STATE call of the v1 Operator API.
+	StateAPIV1 = "v1"
+)
+
+// errOperatorUnsupported is returned by loadFromOperator if the master
+// doesn't serve the v1 Operator API.
+var errOperatorUnsupported = errors.New("v1 Operator API unsupported")
+
+// detectedAPIs are the state APIs detected for each master, by address, if
+// the configured StateAPI is StateAPIAuto.
+var detectedAPIs = apis{byAddr: map[string]string{}}
+
+// apis maps addresses of masters to state APIs. It's safe for concurrent use.
+type apis struct {
+	mu     sync.Mutex
+	byAddr map[string]string
+}
+
+func (a *apis) get(addr string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.byAddr[addr]
+}
+
+func (a *apis) set(addr, api string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.byAddr[addr] = api
+}
+
+// stateAPI returns the state API of the master of the given address: the
+// configured one, or the one detected if it's StateAPIAuto, if any.
+func (rg *RecordGenerator) stateAPI(addr string) string {
+	switch api := rg.Config.StateAPI; api {
+	case StateAPILegacy, StateAPIV1:
+		return api
+	}
+	if api := detectedAPIs.get(addr); api != "" {
+		return api
+	}
+	return StateAPIAuto
+}
+
+// loadFromOperator loads the state of the Mesos master of the given address
+// with the GET_MASTER and GET_STATE calls of its v1 Operator API.
+func (rg *RecordGenerator) loadFromOperator(addr string) (state.State, error) {
+	var master, st state.OperatorResponse
+	if _, err := rg.callOperator(addr, "GET_MASTER", &master); err != nil {
+		return state.State{}, err
+	}
+	raw, err := rg.callOperator(addr, "GET_STATE", &st)
+	if err != nil {
+		return state.State{}, err
+	}
+	if master.GetMaster == nil || st.GetState == nil {
+		return state.State{}, fmt.Errorf("unexpected v1 Operator API responses %q and %q from %s",
+			master.Type, st.Type, addr)
+	}
+
+	sj := st.GetState.State()
+	sj.Leader = master.GetMaster.MasterInfo.Leader()
+	rg.loaded(addr, StateAPIV1, raw)
+	return sj, nil
+}
+
+// callOperator makes a call of the given type to the v1 Operator API of the
+// Mesos master of the given address, decoding its JSON response into v, and
+// returns that raw response. Non-leading masters redirect calls to the
+// leader, but http.Client doesn't follow redirects of POST requests, so such
+// calls fail and findMaster moves on to the next master.
+func (rg *RecordGenerator) callOperator(addr, call string, v interface{}) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{"type": call})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", rg.masterURL(addr, "/api/v1"), bytes.NewReader(body))
+	if err != nil {
+		logging.Error.Println(err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := rg.httpClient.Do(req)
+	if err != nil {
+		logging.Error.Println(err)
+		return nil, err
+	}
+	defer errorutil.Ignore(resp.Body.Close)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, errOperatorUnsupported
+	default:
+		return nil, fmt.Errorf("%s call to %s failed: %s", call, addr, resp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		err = json.Unmarshal(raw, v)
+	}
+	if err != nil {
+		logging.Error.Println(err)
+		return nil, err
+	}
+	return raw, nil
+}
@@ -0,0 +1,100 @@
+package records
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// operatorHandler serves GET_MASTER and GET_STATE calls of the v1 Operator
+// API, and the legacy state.json endpoint, if the respective flags are set.
+type operatorHandler struct {
+	v1, legacy bool
+	calls      []string
+}
+
+func (h *operatorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/api/v1" && h.v1:
+		var call struct{ Type string }
+		if err := json.NewDecoder(r.Body).Decode(&call); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.calls = append(h.calls, call.Type)
+		switch call.Type {
+		case "GET_MASTER":
+			io.WriteString(w, `{"type":"GET_MASTER","get_master":{"master_info":{"pid":"master@10.0.0.1:5050"}}}`)
+		case "GET_STATE":
+			io.WriteString(w, `{"type":"GET_STATE","get_state":{"get_frameworks":{"frameworks":[{"framework_info":{"id":{"value":"f1"},"name":"v1"}}]}}}`)
+		default:
+			http.Error(w, "unexpected call", http.StatusBadRequest)
+		}
+	case r.URL.Path == "/master/state.json" && h.legacy:
+		h.calls = append(h.calls, "state.json")
+		io.WriteString(w, `{"leader":"master@10.0.0.2:5050","frameworks":[{"name":"legacy"}]}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestLoadFromMaster(t *testing.T) {
+	for i, tt := range []struct {
+		api        string
+		v1, legacy bool
+		framework  string
+		leader     string
+		calls      []string
+		err        bool
+	}{
+		{StateAPIAuto, true, true, "v1", "master@10.0.0.1:5050", []string{"GET_MASTER", "GET_STATE"}, false},
+		{StateAPIAuto, false, true, "legacy", "master@10.0.0.2:5050", []string{"state.json"}, false},
+		{StateAPIV1, true, true, "v1", "master@10.0.0.1:5050", []string{"GET_MASTER", "GET_STATE"}, false},
+		{StateAPIV1, false, true, "", "", nil, true},
+		{StateAPILegacy, true, true, "legacy", "master@10.0.0.2:5050", []string{"state.json"}, false},
+	} {
+		h := &operatorHandler{v1: tt.v1, legacy: tt.legacy}
+		server := httptest.NewServer(h)
+
+		config := NewConfig()
+		config.StateAPI = tt.api
+		rg := NewRecordGenerator(config)
+		host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Loading twice checks that the detected API is remembered.
+		for j := 0; j < 2; j++ {
+			sj, err := rg.loadFromMaster(host, port)
+			if (err != nil) != tt.err {
+				t.Errorf("test #%d: got error %v, want error %t", i+1, err, tt.err)
+				continue
+			}
+			if tt.err {
+				continue
+			}
+			if len(sj.Frameworks) != 1 || sj.Frameworks[0].Name != tt.framework {
+				t.Errorf("test #%d: got frameworks %+v, want %q", i+1, sj.Frameworks, tt.framework)
+			}
+			if sj.Leader != tt.leader {
+				t.Errorf("test #%d: got leader %q, want %q", i+1, sj.Leader, tt.leader)
+			}
+		}
+		server.Close()
+
+		if want := append(tt.calls, tt.calls...); len(h.calls) != len(want) {
+			t.Errorf("test #%d: got calls %v, want %v", i+1, h.calls, want)
+		} else {
+			for j := range want {
+				if h.calls[j] != want[j] {
+					t.Errorf("test #%d: got calls %v, want %v", i+1, h.calls, want)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package records
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/errorutil"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// A Snapshot is a raw state response of a Mesos master, as recorded in a
+// gzipped JSON file of SnapshotDir.
+type Snapshot struct {
+	Time     time.Time       `json:"time"`     // of the recording
+	Master   string          `json:"master"`   // address of the master which responded
+	API      string          `json:"api"`      // StateAPILegacy or StateAPIV1
+	Response json.RawMessage `json:"response"` // as served
+}
+
+// State decodes the state of the Snapshot.
+func (s *Snapshot) State() (state.State, error) {
+	var sj state.State
+	switch s.API {
+	case StateAPILegacy:
+		err := json.Unmarshal(s.Response, &sj)
+		return sj, err
+	case StateAPIV1:
+		var resp state.OperatorResponse
+		if err := json.Unmarshal(s.Response, &resp); err != nil {
+			return sj, err
+		}
+		if resp.GetState == nil {
+			return sj, fmt.Errorf("unexpected v1 Operator API response %q", resp.Type)
+		}
+		sj = resp.GetState.State()
+		// GET_MASTER isn't recorded, but only the leader answers GET_STATE
+		sj.Leader = "master@" + s.Master
+		return sj, nil
+	default:
+		return sj, fmt.Errorf("unknown state API %q", s.API)
+	}
+}
+
+// ReadSnapshot reads the Snapshot of the given file.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer errorutil.Ignore(f.Close)
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %q: %v", path, err)
+	}
+	var s Snapshot
+	if err = json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snapshot %q: %v", path, err)
+	}
+	return &s, nil
+}
+
+// loaded keeps the given raw state response of the master of the given
+// address, as loaded with the given API, to be recorded if SnapshotDir is
+// set.
+func (rg *RecordGenerator) loaded(addr, api string, raw []byte) {
+	if rg.Config.SnapshotDir != "" {
+		rg.snapshot = &Snapshot{Master: addr, API: api, Response: raw}
+	}
+}
+
+// recordSnapshot records the state response last loaded, if any, in
+// SnapshotDir, keeping the SnapshotCount last ones. It only logs failures,
+// which don't prevent generating records.
+func (rg *RecordGenerator) recordSnapshot() {
+	s := rg.snapshot
+	if s == nil {
+		return
+	}
+	rg.snapshot = nil // frees the response
+	s.Time = time.Now()
+	if err := writeSnapshot(rg.Config.SnapshotDir, s); err != nil {
+		logging.Error.Printf("failed to record state snapshot: %v", err)
+		return
+	}
+	if err := pruneSnapshots(rg.Config.SnapshotDir, rg.Config.SnapshotCount); err != nil {
+		logging.Error.Printf("failed to prune state snapshots: %v", err)
+	}
+}
+
+// snapshotPattern matches the files of snapshots, which are named after the
+// time of their recording so that they sort chronologically.
+const snapshotPattern = "state-*.json.gz"
+
+// writeSnapshot writes the given Snapshot to a new file of the given
+// directory, atomically.
+func writeSnapshot(dir string, s *Snapshot) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".state-")
+	if err != nil {
+		return err
+	}
+	defer errorutil.Ignore(func() error { return os.Remove(f.Name()) }) // unless renamed
+
+	w := gzip.NewWriter(f)
+	if err = json.NewEncoder(w).Encode(s); err == nil {
+		err = w.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	name := strings.Replace(snapshotPattern, "*", s.Time.UTC().Format("20060102T150405.000000000Z"), 1)
+	return os.Rename(f.Name(), filepath.Join(dir, name))
+}
+
+// pruneSnapshots removes the oldest snapshots of the given directory but the
+// given number of them.
+func pruneSnapshots(dir string, keep int) error {
+	paths, err := filepath.Glob(filepath.Join(dir, snapshotPattern))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for len(paths) > keep {
+		if err = os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
+
+// Replay writes the records generated, as configured, from the state of the
+// Snapshot of the given file to the given writer, one per line, sorted.
+func Replay(config *Config, path string, w io.Writer) error {
+	s, err := ReadSnapshot(path)
+	if err != nil {
+		return err
+	}
+	sj, err := s.State()
+	if err != nil {
+		return fmt.Errorf("failed to decode snapshot %q: %v", path, err)
+	}
+
+	rg := NewRecordGenerator(config)
+	if err = rg.InsertState(sj, config.Domain, config.SOARname, config.Masters,
+		config.IPSources, rg.hostSpec()); err != nil {
+		return err
+	}
+	return rg.writeRecords(w)
+}
+
+// writeRecords writes the records of the RecordGenerator to the given writer,
+// one per line, sorted by name, type and host, e.g.
+//
+//	leader.mesos.	A	1.2.3.4
+func (rg *RecordGenerator) writeRecords(w io.Writer) error {
+	var lines []string
+	for _, kind := range []rrsKind{A, AAAA, SRV, PTR} {
+		for name, hosts := range kind.rrs(rg) {
+			for host := range hosts {
+				lines = append(lines, name+"\t"+string(kind)+"\t"+host)
+			}
+		}
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
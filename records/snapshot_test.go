@@ -0,0 +1,125 @@
+package records
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordSnapshot(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+	body = `{"leader":"master@` + addr + `","frameworks":[{"name":"marathon"}]}`
+
+	tmp, err := ioutil.TempDir("", "mesos-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	dir := filepath.Join(tmp, "snapshots")
+	config := NewConfig()
+	config.Masters = []string{addr}
+	config.StateAPI = StateAPILegacy
+	config.SnapshotDir = dir
+	config.SnapshotCount = 2
+	for i := 0; i < 3; i++ {
+		if err := NewRecordGenerator(config).ParseState(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("got snapshots %v, want 2", paths)
+	}
+	s, err := ReadSnapshot(paths[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Master != addr || s.API != StateAPILegacy || time.Since(s.Time) > time.Minute {
+		t.Errorf("got snapshot of %q with %q at %v, want of %q with %q now", s.Master, s.API, s.Time, addr, StateAPILegacy)
+	}
+	sj, err := s.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sj.Leader != "master@"+addr || len(sj.Frameworks) != 1 || sj.Frameworks[0].Name != "marathon" {
+		t.Errorf("got state %+v", sj)
+	}
+}
+
+func TestSnapshotState(t *testing.T) {
+	for i, tt := range []struct {
+		s      Snapshot
+		leader string
+		err    bool
+	}{
+		{Snapshot{Master: "10.0.0.1:5050", API: StateAPILegacy,
+			Response: []byte(`{"leader":"master@10.0.0.2:5050"}`)}, "master@10.0.0.2:5050", false},
+		{Snapshot{Master: "10.0.0.1:5050", API: StateAPIV1,
+			Response: []byte(`{"type":"GET_STATE","get_state":{}}`)}, "master@10.0.0.1:5050", false},
+		{Snapshot{Master: "10.0.0.1:5050", API: StateAPIV1,
+			Response: []byte(`{"type":"GET_MASTER"}`)}, "", true},
+		{Snapshot{Master: "10.0.0.1:5050", API: StateAPIAuto, Response: []byte(`{}`)}, "", true},
+	} {
+		sj, err := tt.s.State()
+		if (err != nil) != tt.err {
+			t.Errorf("test #%d: got error %v, want error %t", i+1, err, tt.err)
+		}
+		if sj.Leader != tt.leader {
+			t.Errorf("test #%d: got leader %q, want %q", i+1, sj.Leader, tt.leader)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	response, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "mesos-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	s := &Snapshot{Time: time.Now(), Master: "1.2.3.4:5050", API: StateAPILegacy, Response: response}
+	if err = writeSnapshot(dir, s); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, snapshotPattern))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("got snapshots %v and error %v, want 1", paths, err)
+	}
+
+	var buf bytes.Buffer
+	if err = Replay(NewConfig(), paths[0], &buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if !sort.StringsAreSorted(lines) {
+		t.Error("records aren't sorted")
+	}
+	for _, want := range []string{
+		"leader.mesos.\tA\t1.2.3.4",
+		"_leader._tcp.mesos.\tSRV\tleader.mesos.:5050",
+	} {
+		if i := sort.SearchStrings(lines, want); i == len(lines) || lines[i] != want {
+			t.Errorf("missing record %q", want)
+		}
+	}
+}
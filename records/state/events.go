@@ -0,0 +1,165 @@
+package state
+
+// OperatorEvent holds an event of the SUBSCRIBE call of the Mesos v1 Operator
+// HTTP API, as streamed by the /api/v1 endpoint in JSON.
+type OperatorEvent struct {
+	Type       string `json:"type"`
+	Subscribed *struct {
+		GetState                 OperatorState `json:"get_state"`
+		HeartbeatIntervalSeconds float64       `json:"heartbeat_interval_seconds"`
+	} `json:"subscribed,omitempty"`
+	TaskAdded *struct {
+		Task OperatorTask `json:"task"`
+	} `json:"task_added,omitempty"`
+	TaskUpdated *struct {
+		FrameworkID OperatorID     `json:"framework_id"`
+		Status      OperatorStatus `json:"status"`
+		State       string         `json:"state"`
+	} `json:"task_updated,omitempty"`
+	AgentAdded *struct {
+		Agent OperatorAgent `json:"agent"`
+	} `json:"agent_added,omitempty"`
+	AgentRemoved *struct {
+		AgentID OperatorID `json:"agent_id"`
+	} `json:"agent_removed,omitempty"`
+	FrameworkAdded *struct {
+		Framework OperatorFramework `json:"framework"`
+	} `json:"framework_added,omitempty"`
+	FrameworkUpdated *struct {
+		Framework OperatorFramework `json:"framework"`
+	} `json:"framework_updated,omitempty"`
+	FrameworkRemoved *struct {
+		FrameworkInfo struct {
+			ID OperatorID `json:"id"`
+		} `json:"framework_info"`
+	} `json:"framework_removed,omitempty"`
+}
+
+// terminal holds the states of tasks which don't run anymore, and are dropped
+// from the tasks of an OperatorState like Mesos drops them from its own.
+var terminal = map[string]bool{
+	"TASK_FINISHED":         true,
+	"TASK_FAILED":           true,
+	"TASK_KILLED":           true,
+	"TASK_LOST":             true,
+	"TASK_ERROR":            true,
+	"TASK_DROPPED":          true,
+	"TASK_GONE":             true,
+	"TASK_GONE_BY_OPERATOR": true,
+}
+
+// Apply applies the given event to the OperatorState and returns whether it
+// changed. SUBSCRIBED events replace it with their snapshot, and events of
+// other types, like HEARTBEAT, leave it unchanged.
+func (s *OperatorState) Apply(e *OperatorEvent) bool {
+	switch {
+	case e.Subscribed != nil:
+		*s = e.Subscribed.GetState
+	case e.TaskAdded != nil:
+		s.putTask(e.TaskAdded.Task)
+	case e.TaskUpdated != nil:
+		return s.updateTask(e.TaskUpdated.State, e.TaskUpdated.Status)
+	case e.AgentAdded != nil:
+		s.putAgent(e.AgentAdded.Agent)
+	case e.AgentRemoved != nil:
+		return s.removeAgent(e.AgentRemoved.AgentID.Value)
+	case e.FrameworkAdded != nil:
+		s.putFramework(e.FrameworkAdded.Framework)
+	case e.FrameworkUpdated != nil:
+		s.putFramework(e.FrameworkUpdated.Framework)
+	case e.FrameworkRemoved != nil:
+		return s.removeFramework(e.FrameworkRemoved.FrameworkInfo.ID.Value)
+	default:
+		return false
+	}
+	return true
+}
+
+// putTask adds the given task, or replaces the one of the same ID.
+func (s *OperatorState) putTask(t OperatorTask) {
+	tasks := &s.GetTasks.Tasks
+	for i := range *tasks {
+		if (*tasks)[i].TaskID == t.TaskID {
+			(*tasks)[i] = t
+			return
+		}
+	}
+	*tasks = append(*tasks, t)
+}
+
+// updateTask updates the task of the given status with it and the given
+// state, or drops it if that state is terminal. It returns whether there's
+// such a task.
+func (s *OperatorState) updateTask(state string, st OperatorStatus) bool {
+	tasks := &s.GetTasks.Tasks
+	for i := range *tasks {
+		if t := &(*tasks)[i]; t.TaskID == st.TaskID {
+			if terminal[state] {
+				*tasks = append((*tasks)[:i], (*tasks)[i+1:]...)
+			} else {
+				t.State = state
+				t.Statuses = append(t.Statuses, st)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// putAgent adds the given agent, or replaces the one of the same ID.
+func (s *OperatorState) putAgent(a OperatorAgent) {
+	agents := &s.GetAgents.Agents
+	for i := range *agents {
+		if (*agents)[i].AgentInfo.ID == a.AgentInfo.ID {
+			(*agents)[i] = a
+			return
+		}
+	}
+	*agents = append(*agents, a)
+}
+
+// removeAgent removes the agent of the given ID, and returns whether there
+// was one.
+func (s *OperatorState) removeAgent(id string) bool {
+	agents := &s.GetAgents.Agents
+	for i := range *agents {
+		if (*agents)[i].AgentInfo.ID.Value == id {
+			*agents = append((*agents)[:i], (*agents)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// putFramework adds the given framework, or replaces the one of the same ID.
+func (s *OperatorState) putFramework(f OperatorFramework) {
+	frameworks := &s.GetFrameworks.Frameworks
+	for i := range *frameworks {
+		if (*frameworks)[i].FrameworkInfo.ID == f.FrameworkInfo.ID {
+			(*frameworks)[i] = f
+			return
+		}
+	}
+	*frameworks = append(*frameworks, f)
+}
+
+// removeFramework removes the framework of the given ID and its tasks, and
+// returns whether there was one.
+func (s *OperatorState) removeFramework(id string) bool {
+	frameworks := &s.GetFrameworks.Frameworks
+	for i := range *frameworks {
+		if (*frameworks)[i].FrameworkInfo.ID.Value != id {
+			continue
+		}
+		*frameworks = append((*frameworks)[:i], (*frameworks)[i+1:]...)
+		tasks := s.GetTasks.Tasks[:0]
+		for _, t := range s.GetTasks.Tasks {
+			if t.FrameworkID.Value != id {
+				tasks = append(tasks, t)
+			}
+		}
+		s.GetTasks.Tasks = tasks
+		return true
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package state_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	. "github.com/mesosphere/mesos-dns/records/state"
+)
+
+func TestOperatorState_Apply(t *testing.T) {
+	type want struct {
+		changed    bool
+		frameworks map[string][]string // task IDs by framework name
+		agents     []string
+	}
+	var s OperatorState
+	for i, tt := range []struct {
+		event string
+		want
+	}{
+		{`{"type":"HEARTBEAT"}`, want{false, map[string][]string{}, []string{}}},
+		{`{"type":"SUBSCRIBED","subscribed":{"get_state":{
+			"get_frameworks":{"frameworks":[{"framework_info":{"id":{"value":"f1"},"name":"a"}}]},
+			"get_agents":{"agents":[{"agent_info":{"id":{"value":"a1"},"hostname":"h1"}}]},
+			"get_tasks":{"tasks":[{"task_id":{"value":"t1"},"framework_id":{"value":"f1"},"state":"TASK_STAGING"}]}},
+			"heartbeat_interval_seconds":15}}`,
+			want{true, map[string][]string{"a": {"t1"}}, []string{"a1"}}},
+		{`{"type":"TASK_ADDED","task_added":{"task":{"task_id":{"value":"t2"},"framework_id":{"value":"f1"},"state":"TASK_STAGING"}}}`,
+			want{true, map[string][]string{"a": {"t1", "t2"}}, []string{"a1"}}},
+		{`{"type":"TASK_UPDATED","task_updated":{"framework_id":{"value":"f1"},"state":"TASK_RUNNING",
+			"status":{"task_id":{"value":"t1"},"state":"TASK_RUNNING","timestamp":2}}}`,
+			want{true, map[string][]string{"a": {"t1", "t2"}}, []string{"a1"}}},
+		{`{"type":"TASK_UPDATED","task_updated":{"framework_id":{"value":"f1"},"state":"TASK_RUNNING",
+			"status":{"task_id":{"value":"unknown"},"state":"TASK_RUNNING"}}}`,
+			want{false, map[string][]string{"a": {"t1", "t2"}}, []string{"a1"}}},
+		{`{"type":"TASK_UPDATED","task_updated":{"framework_id":{"value":"f1"},"state":"TASK_FINISHED",
+			"status":{"task_id":{"value":"t2"},"state":"TASK_FINISHED"}}}`,
+			want{true, map[string][]string{"a": {"t1"}}, []string{"a1"}}},
+		{`{"type":"AGENT_ADDED","agent_added":{"agent":{"agent_info":{"id":{"value":"a2"},"hostname":"h2"}}}}`,
+			want{true, map[string][]string{"a": {"t1"}}, []string{"a1", "a2"}}},
+		{`{"type":"AGENT_REMOVED","agent_removed":{"agent_id":{"value":"a1"}}}`,
+			want{true, map[string][]string{"a": {"t1"}}, []string{"a2"}}},
+		{`{"type":"FRAMEWORK_ADDED","framework_added":{"framework":{"framework_info":{"id":{"value":"f2"},"name":"b"}}}}`,
+			want{true, map[string][]string{"a": {"t1"}, "b": nil}, []string{"a2"}}},
+		{`{"type":"FRAMEWORK_UPDATED","framework_updated":{"framework":{"framework_info":{"id":{"value":"f1"},"name":"c"}}}}`,
+			want{true, map[string][]string{"c": {"t1"}, "b": nil}, []string{"a2"}}},
+		{`{"type":"FRAMEWORK_REMOVED","framework_removed":{"framework_info":{"id":{"value":"f1"}}}}`,
+			want{true, map[string][]string{"b": nil}, []string{"a2"}}},
+		{`{"type":"FRAMEWORK_REMOVED","framework_removed":{"framework_info":{"id":{"value":"f1"}}}}`,
+			want{false, map[string][]string{"b": nil}, []string{"a2"}}},
+	} {
+		var e OperatorEvent
+		if err := json.Unmarshal([]byte(tt.event), &e); err != nil {
+			t.Fatalf("test #%d: %v", i, err)
+		}
+		if changed := s.Apply(&e); changed != tt.changed {
+			t.Errorf("test #%d: got changed %t, want %t", i, changed, tt.changed)
+		}
+
+		st := s.State()
+		frameworks := map[string][]string{}
+		for _, f := range st.Frameworks {
+			var ids []string
+			for _, task := range f.Tasks {
+				ids = append(ids, task.ID)
+			}
+			frameworks[f.Name] = ids
+		}
+		agents := []string{}
+		for _, a := range st.Slaves {
+			agents = append(agents, a.ID)
+		}
+		if !reflect.DeepEqual(frameworks, tt.frameworks) {
+			t.Errorf("test #%d: got frameworks %v, want %v", i, frameworks, tt.frameworks)
+		}
+		if !reflect.DeepEqual(agents, tt.agents) {
+			t.Errorf("test #%d: got agents %v, want %v", i, agents, tt.agents)
+		}
+	}
+}
+
+func TestOperatorState_ApplyTaskUpdated(t *testing.T) {
+	var s OperatorState
+	for _, event := range []string{
+		`{"type":"TASK_ADDED","task_added":{"task":{"task_id":{"value":"t1"},"framework_id":{"value":"f1"},"state":"TASK_STAGING"}}}`,
+		`{"type":"FRAMEWORK_ADDED","framework_added":{"framework":{"framework_info":{"id":{"value":"f1"},"name":"a"}}}}`,
+		`{"type":"TASK_UPDATED","task_updated":{"framework_id":{"value":"f1"},"state":"TASK_RUNNING",
+			"status":{"task_id":{"value":"t1"},"state":"TASK_RUNNING","timestamp":2,
+			"container_status":{"network_infos":[{"ip_addresses":[{"ip_address":"10.0.0.2"}]}]}}}}`,
+	} {
+		var e OperatorEvent
+		if err := json.Unmarshal([]byte(event), &e); err != nil {
+			t.Fatal(err)
+		}
+		s.Apply(&e)
+	}
+
+	task := s.State().Frameworks[0].Tasks[0]
+	if task.State != "TASK_RUNNING" {
+		t.Errorf("got state %q, want TASK_RUNNING", task.State)
+	}
+	if got, want := task.IPs("netinfo"), ips("10.0.0.2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got IPs %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,296 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mesos/mesos-go/upid"
+)
+
+// OperatorResponse holds a response of the Mesos v1 Operator HTTP API, as
+// served by the /api/v1 endpoint in JSON, to GET_STATE or GET_MASTER calls.
+type OperatorResponse struct {
+	Type      string         `json:"type"`
+	GetState  *OperatorState `json:"get_state,omitempty"`
+	GetMaster *struct {
+		MasterInfo OperatorMasterInfo `json:"master_info"`
+	} `json:"get_master,omitempty"`
+}
+
+// OperatorState holds the state of a Mesos cluster as defined by the GET_STATE
+// call of the Mesos v1 Operator HTTP API.
+type OperatorState struct {
+	GetTasks struct {
+		Tasks []OperatorTask `json:"tasks"`
+	} `json:"get_tasks"`
+	GetFrameworks struct {
+		Frameworks []OperatorFramework `json:"frameworks"`
+	} `json:"get_frameworks"`
+	GetAgents struct {
+		Agents []OperatorAgent `json:"agents"`
+	} `json:"get_agents"`
+}
+
+// State returns the State of the OperatorState, with the tasks grouped by
+// framework, and without a leader.
+func (s *OperatorState) State() State {
+	st := State{
+		Frameworks: make([]Framework, 0, len(s.GetFrameworks.Frameworks)),
+		Slaves:     make([]Slave, 0, len(s.GetAgents.Agents)),
+	}
+	index := make(map[string]int, len(s.GetFrameworks.Frameworks))
+	for _, f := range s.GetFrameworks.Frameworks {
+		index[f.FrameworkInfo.ID.Value] = len(st.Frameworks)
+		st.Frameworks = append(st.Frameworks, f.Framework())
+	}
+	for _, t := range s.GetTasks.Tasks {
+		if i, ok := index[t.FrameworkID.Value]; ok {
+			st.Frameworks[i].Tasks = append(st.Frameworks[i].Tasks, t.Task())
+		}
+	}
+	for _, a := range s.GetAgents.Agents {
+		st.Slaves = append(st.Slaves, a.Slave())
+	}
+	return st
+}
+
+// OperatorID holds an ID, e.g. of a task, as defined by the Mesos v1
+// Operator HTTP API.
+type OperatorID struct {
+	Value string `json:"value"`
+}
+
+// OperatorLabels holds labels as defined by the Mesos v1 Operator HTTP API.
+type OperatorLabels struct {
+	Labels []Label `json:"labels"`
+}
+
+// OperatorTask holds a task as defined by the Mesos v1 Operator HTTP API.
+type OperatorTask struct {
+	Name        string             `json:"name"`
+	TaskID      OperatorID         `json:"task_id"`
+	FrameworkID OperatorID         `json:"framework_id"`
+	AgentID     OperatorID         `json:"agent_id"`
+	State       string             `json:"state"`
+	Resources   []OperatorResource `json:"resources"`
+	Statuses    []OperatorStatus   `json:"statuses"`
+	Labels      OperatorLabels     `json:"labels"`
+	Discovery   *struct {
+		Visibility  string `json:"visibility"`
+		Name        string `json:"name"`
+		Environment string `json:"environment"`
+		Location    string `json:"location"`
+		Version     string `json:"version"`
+		Ports       *struct {
+			Ports []DiscoveryPort `json:"ports"`
+		} `json:"ports"`
+		Labels OperatorLabels `json:"labels"`
+	} `json:"discovery"`
+}
+
+// Task returns the Task of the OperatorTask.
+func (t *OperatorTask) Task() Task {
+	task := Task{
+		FrameworkID: t.FrameworkID.Value,
+		ID:          t.TaskID.Value,
+		Name:        t.Name,
+		SlaveID:     t.AgentID.Value,
+		State:       t.State,
+		Statuses:    make([]Status, 0, len(t.Statuses)),
+		Labels:      t.Labels.Labels,
+	}
+	for _, s := range t.Statuses {
+		task.Statuses = append(task.Statuses, s.Status())
+	}
+
+	var ranges []string
+	for _, r := range t.Resources {
+		if r.Name != "ports" || r.Ranges == nil {
+			continue
+		}
+		for _, rg := range r.Ranges.Range {
+			ranges = append(ranges, strconv.FormatUint(uint64(rg.Begin), 10)+"-"+strconv.FormatUint(uint64(rg.End), 10))
+		}
+	}
+	if len(ranges) > 0 {
+		task.PortRanges = "[" + strings.Join(ranges, ", ") + "]"
+	}
+
+	if d := t.Discovery; d != nil {
+		task.DiscoveryInfo = DiscoveryInfo{
+			Visibilty:   d.Visibility,
+			Version:     d.Version,
+			Name:        d.Name,
+			Location:    d.Location,
+			Environment: d.Environment,
+		}
+		task.DiscoveryInfo.Labels.Labels = d.Labels.Labels
+		if d.Ports != nil {
+			task.DiscoveryInfo.Ports.DiscoveryPorts = d.Ports.Ports
+		}
+	}
+	return task
+}
+
+// OperatorResource holds a resource as defined by the Mesos v1 Operator HTTP
+// API. Only the ranges of ports resources are of interest.
+type OperatorResource struct {
+	Name   string `json:"name"`
+	Ranges *struct {
+		Range []struct {
+			Begin Uint64 `json:"begin"`
+			End   Uint64 `json:"end"`
+		} `json:"range"`
+	} `json:"ranges"`
+}
+
+// OperatorStatus holds a task status as defined by the Mesos v1 Operator HTTP
+// API.
+type OperatorStatus struct {
+	TaskID          OperatorID      `json:"task_id"`
+	State           string          `json:"state"`
+	Timestamp       float64         `json:"timestamp"`
+	Labels          OperatorLabels  `json:"labels"`
+	ContainerStatus ContainerStatus `json:"container_status"`
+}
+
+// Status returns the Status of the OperatorStatus.
+func (s *OperatorStatus) Status() Status {
+	return Status{
+		Timestamp:       s.Timestamp,
+		State:           s.State,
+		Labels:          s.Labels.Labels,
+		ContainerStatus: s.ContainerStatus,
+	}
+}
+
+// OperatorFramework holds a framework as defined by the Mesos v1 Operator
+// HTTP API.
+type OperatorFramework struct {
+	FrameworkInfo struct {
+		ID       OperatorID `json:"id"`
+		Name     string     `json:"name"`
+		Hostname string     `json:"hostname"`
+	} `json:"framework_info"`
+	Active bool `json:"active"`
+}
+
+// Framework returns the Framework of the OperatorFramework, without tasks.
+// The v1 API doesn't expose the PIDs of frameworks, so their schedulers are
+// only known by hostname.
+func (f *OperatorFramework) Framework() Framework {
+	return Framework{
+		Name:     f.FrameworkInfo.Name,
+		Hostname: f.FrameworkInfo.Hostname,
+		Active:   f.Active,
+	}
+}
+
+// OperatorAgent holds an agent as defined by the Mesos v1 Operator HTTP API.
+type OperatorAgent struct {
+	AgentInfo struct {
+		ID         OperatorID          `json:"id"`
+		Hostname   string              `json:"hostname"`
+		Port       int                 `json:"port"`
+		Attributes []OperatorAttribute `json:"attributes"`
+	} `json:"agent_info"`
+	Active bool   `json:"active"`
+	PID    string `json:"pid"`
+}
+
+// Slave returns the Slave of the OperatorAgent. Its PID is made up of its
+// hostname and port if it has none.
+func (a *OperatorAgent) Slave() Slave {
+	s := Slave{
+		ID:       a.AgentInfo.ID.Value,
+		Hostname: a.AgentInfo.Hostname,
+		Active:   a.Active,
+	}
+	var err error
+	if s.PID.UPID, err = upid.Parse(a.PID); err != nil {
+		s.PID.UPID = &upid.UPID{
+			ID:   "slave(1)",
+			Host: a.AgentInfo.Hostname,
+			Port: strconv.Itoa(a.AgentInfo.Port),
+		}
+	}
+	for _, attr := range a.AgentInfo.Attributes {
+		v := attr.Value()
+		switch attr.Name {
+		case "dc":
+			s.Attrs.DC = v
+		case "master":
+			s.Attrs.Master = v
+		case "rack":
+			s.Attrs.Rack = v
+		}
+	}
+	return s
+}
+
+// OperatorAttribute holds an agent attribute as defined by the Mesos v1
+// Operator HTTP API.
+type OperatorAttribute struct {
+	Name string `json:"name"`
+	Text *struct {
+		Value string `json:"value"`
+	} `json:"text"`
+	Scalar *struct {
+		Value float64 `json:"value"`
+	} `json:"scalar"`
+}
+
+// Value returns the value of the text or scalar OperatorAttribute.
+func (a *OperatorAttribute) Value() string {
+	switch {
+	case a.Text != nil:
+		return a.Text.Value
+	case a.Scalar != nil:
+		return strconv.FormatFloat(a.Scalar.Value, 'f', -1, 64)
+	}
+	return ""
+}
+
+// OperatorMasterInfo holds the info of a master as defined by the Mesos v1
+// Operator HTTP API.
+type OperatorMasterInfo struct {
+	PID      string `json:"pid"`
+	Hostname string `json:"hostname"`
+	Port     int    `json:"port"`
+	Address  *struct {
+		IP   string `json:"ip"`
+		Port int    `json:"port"`
+	} `json:"address"`
+}
+
+// Leader returns the OperatorMasterInfo formatted as the leader of a State,
+// i.e. as a PID like "master@10.0.0.1:5050".
+func (m *OperatorMasterInfo) Leader() string {
+	if m.PID != "" {
+		return m.PID
+	}
+	if m.Address != nil && m.Address.IP != "" {
+		return "master@" + net.JoinHostPort(m.Address.IP, strconv.Itoa(m.Address.Port))
+	}
+	if m.Hostname != "" {
+		return "master@" + net.JoinHostPort(m.Hostname, strconv.Itoa(m.Port))
+	}
+	return ""
+}
+
+// Uint64 is a uint64 which unmarshals from either a JSON number or a string,
+// as 64 bit integers are encoded in the JSON mapping of protobuf.
+type Uint64 uint64
+
+// UnmarshalJSON implements the json.Unmarshaler interface for Uint64s.
+func (u *Uint64) UnmarshalJSON(data []byte) error {
+	var n uint64
+	if err := json.Unmarshal(bytes.Trim(data, `"`), &n); err != nil {
+		return err
+	}
+	*u = Uint64(n)
+	return nil
+}
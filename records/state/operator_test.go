@@ -0,0 +1,162 @@
+package state_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mesos/mesos-go/upid"
+	. "github.com/mesosphere/mesos-dns/records/state"
+)
+
+const getState = `{
+  "type": "GET_STATE",
+  "get_state": {
+    "get_tasks": {
+      "tasks": [
+        {
+          "name": "nginx",
+          "task_id": {"value": "nginx.1"},
+          "framework_id": {"value": "f1"},
+          "agent_id": {"value": "a1"},
+          "state": "TASK_RUNNING",
+          "resources": [
+            {"name": "cpus", "type": "SCALAR", "scalar": {"value": 0.1}},
+            {"name": "ports", "type": "RANGES", "ranges": {"range": [{"begin": 31000, "end": 31001}, {"begin": "31005", "end": "31005"}]}}
+          ],
+          "statuses": [
+            {
+              "state": "TASK_RUNNING",
+              "timestamp": 1.5,
+              "labels": {"labels": [{"key": "Docker.NetworkSettings.IPAddress", "value": "172.17.0.2"}]},
+              "container_status": {"network_infos": [{"ip_addresses": [{"ip_address": "10.0.0.2"}]}]}
+            }
+          ],
+          "labels": {"labels": [{"key": "k", "value": "v"}]},
+          "discovery": {
+            "visibility": "FRAMEWORK",
+            "name": "web",
+            "ports": {"ports": [{"number": 80, "name": "http", "protocol": "tcp"}]},
+            "labels": {"labels": [{"key": "l", "value": "w"}]}
+          }
+        },
+        {
+          "name": "orphan",
+          "task_id": {"value": "orphan.1"},
+          "framework_id": {"value": "unknown"},
+          "agent_id": {"value": "a1"},
+          "state": "TASK_RUNNING"
+        }
+      ]
+    },
+    "get_frameworks": {
+      "frameworks": [
+        {"framework_info": {"id": {"value": "f1"}, "name": "marathon", "hostname": "m1"}, "active": true},
+        {"framework_info": {"id": {"value": "f2"}, "name": "idle", "hostname": "m2"}, "active": false}
+      ]
+    },
+    "get_agents": {
+      "agents": [
+        {
+          "agent_info": {
+            "id": {"value": "a1"},
+            "hostname": "agent1",
+            "port": 5051,
+            "attributes": [
+              {"name": "rack", "type": "TEXT", "text": {"value": "r1"}},
+              {"name": "dc", "type": "SCALAR", "scalar": {"value": 2}}
+            ]
+          },
+          "active": true,
+          "pid": "slave(1)@10.0.0.1:5051"
+        },
+        {
+          "agent_info": {"id": {"value": "a2"}, "hostname": "agent2", "port": 5052},
+          "active": true
+        }
+      ]
+    }
+  }
+}`
+
+func TestOperatorState_State(t *testing.T) {
+	var resp OperatorResponse
+	if err := json.Unmarshal([]byte(getState), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	var disco DiscoveryInfo
+	disco.Visibilty = "FRAMEWORK"
+	disco.Name = "web"
+	disco.Labels.Labels = []Label{{Key: "l", Value: "w"}}
+	disco.Ports.DiscoveryPorts = []DiscoveryPort{{Protocol: "tcp", Number: 80, Name: "http"}}
+
+	want := State{
+		Frameworks: []Framework{
+			{
+				Name:     "marathon",
+				Hostname: "m1",
+				Active:   true,
+				Tasks: []Task{{
+					FrameworkID: "f1",
+					ID:          "nginx.1",
+					Name:        "nginx",
+					SlaveID:     "a1",
+					State:       "TASK_RUNNING",
+					Statuses: []Status{status(
+						state("TASK_RUNNING"),
+						timestamp(1.5),
+						labels("Docker.NetworkSettings.IPAddress", "172.17.0.2"),
+						netinfos(netinfo("10.0.0.2")),
+					)},
+					Resources:     Resources{PortRanges: "[31000-31001, 31005-31005]"},
+					DiscoveryInfo: disco,
+					Labels:        []Label{{Key: "k", Value: "v"}},
+				}},
+			},
+			{Name: "idle", Hostname: "m2"},
+		},
+		Slaves: []Slave{
+			{
+				ID:       "a1",
+				Hostname: "agent1",
+				PID:      PID{&upid.UPID{ID: "slave(1)", Host: "10.0.0.1", Port: "5051"}},
+				Attrs:    Attributes{DC: "2", Rack: "r1"},
+				Active:   true,
+			},
+			{
+				ID:       "a2",
+				Hostname: "agent2",
+				PID:      PID{&upid.UPID{ID: "slave(1)", Host: "agent2", Port: "5052"}},
+				Active:   true,
+			},
+		},
+	}
+
+	if resp.GetState == nil {
+		t.Fatal("no get_state")
+	}
+	if got := resp.GetState.State(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestOperatorMasterInfo_Leader(t *testing.T) {
+	for i, tt := range []struct {
+		data string
+		want string
+	}{
+		{`{}`, ""},
+		{`{"pid": "master@10.0.0.1:5050", "hostname": "m1", "port": 5050}`, "master@10.0.0.1:5050"},
+		{`{"hostname": "m1", "port": 5050, "address": {"ip": "10.0.0.1", "port": 5050}}`, "master@10.0.0.1:5050"},
+		{`{"hostname": "m1", "port": 5050}`, "master@m1:5050"},
+	} {
+		var m OperatorMasterInfo
+		if err := json.Unmarshal([]byte(tt.data), &m); err != nil {
+			t.Fatalf("test #%d: %v", i, err)
+		}
+		if got := m.Leader(); got != tt.want {
+			t.Errorf("test #%d: got: %q, want: %q", i, got, tt.want)
+		}
+	}
+}
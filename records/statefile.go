@@ -0,0 +1,87 @@
+package records
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// ValidateStateFile checks that the given StateFile is either a path or a
+// file:// URL of the local host.
+func ValidateStateFile(file string) error {
+	_, err := stateFilePath(file)
+	return err
+}
+
+// stateFilePath returns the path of the given StateFile, which is either a
+// path or a file:// URL of the local host.
+func stateFilePath(file string) (string, error) {
+	if !strings.HasPrefix(file, "file:") {
+		return file, nil
+	}
+	u, err := url.Parse(file)
+	if err != nil {
+		return "", err
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("state file %q isn't local", file)
+	}
+	if u.Path == "" {
+		return "", fmt.Errorf("state file %q has no path", file)
+	}
+	return u.Path, nil
+}
+
+// loadStateFile loads the state of the Mesos master from the state.json
+// snapshot of the given StateFile.
+func loadStateFile(file string) (state.State, error) {
+	var sj state.State
+	path, err := stateFilePath(file)
+	if err != nil {
+		return sj, err
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		logging.Error.Println(err)
+		return sj, err
+	}
+	if err = json.Unmarshal(bs, &sj); err != nil {
+		logging.Error.Println(err)
+		return state.State{}, fmt.Errorf("failed to unmarshal state file %q: %v", path, err)
+	}
+	return sj, nil
+}
+
+// WatchStateFile checks the given StateFile for changes every interval and
+// notifies them on the returned channel, coalescing those which aren't
+// received yet.
+func WatchStateFile(file string, interval time.Duration) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	go func() {
+		var modTime time.Time
+		var size int64
+		path, _ := stateFilePath(file)
+		for range time.Tick(interval) {
+			fi, err := os.Stat(path)
+			if err != nil || fi.ModTime().Equal(modTime) && fi.Size() == size {
+				continue
+			}
+			if !modTime.IsZero() {
+				logging.Verbose.Printf("state file %q changed", path)
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+			modTime, size = fi.ModTime(), fi.Size()
+		}
+	}()
+	return changed
+}
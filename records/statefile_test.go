@@ -0,0 +1,80 @@
+package records
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateFilePath(t *testing.T) {
+	for i, tt := range []struct {
+		file, path string
+		err        bool
+	}{
+		{"state.json", "state.json", false},
+		{"/var/lib/state.json", "/var/lib/state.json", false},
+		{"file:///var/lib/state.json", "/var/lib/state.json", false},
+		{"file://localhost/var/lib/state.json", "/var/lib/state.json", false},
+		{"file://remote/var/lib/state.json", "", true},
+		{"file://", "", true},
+	} {
+		path, err := stateFilePath(tt.file)
+		if (err != nil) != tt.err {
+			t.Errorf("test #%d: got error %v, want error %t", i+1, err, tt.err)
+		}
+		if path != tt.path {
+			t.Errorf("test #%d: got %q, want %q", i+1, path, tt.path)
+		}
+	}
+}
+
+func TestParseStateFile(t *testing.T) {
+	config := NewConfig()
+	config.StateFile = "../factories/fake.json"
+	rg := NewRecordGenerator(config)
+	if err := rg.ParseState(); err != nil {
+		t.Fatal(err)
+	}
+	if rg.State.Leader != "master@1.2.3.4:5050" {
+		t.Errorf("got leader %q, want master@1.2.3.4:5050", rg.State.Leader)
+	}
+	if !rg.exists("leader.mesos.", "1.2.3.4", A) {
+		t.Error("no leader record")
+	}
+
+	config.StateFile = "../factories/missing.json"
+	if err := NewRecordGenerator(config).ParseState(); err == nil {
+		t.Error("got no error for a missing state file")
+	}
+}
+
+func TestWatchStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "state.json")
+	if err = ioutil.WriteFile(path, []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	changed := WatchStateFile("file://"+path, 10*time.Millisecond)
+
+	select {
+	case <-changed:
+		t.Fatal("notified of the initial state file")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	mod := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not notified of the changed state file")
+	}
+}
@@ -0,0 +1,208 @@
+package records
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// newTransport returns the http.RoundTripper the Mesos masters are requested
+// with, over TLS verified with CACertFile and authenticated with CertFile and
+// KeyFile, if MesosHTTPSOn. It returns nil otherwise, for the default one.
+// The fields of http.DefaultTransport are repeated, since copying it isn't
+// safe.
+func newTransport(c *Config) (http.RoundTripper, error) {
+	if !c.MesosHTTPSOn {
+		return nil, nil
+	}
+	cfg, err := newTLSConfig(c.CACertFile, c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSClientConfig:       cfg,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}, nil
+}
+
+// newCredentials returns the credentials of the given file, or nil if there's
+// none, checking that they can be read.
+func newCredentials(path string) (*credentials, error) {
+	if path == "" {
+		return nil, nil
+	}
+	creds := &credentials{path: path}
+	if _, err := creds.authorization(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// roundTripper returns the http.RoundTripper the masters are requested with:
+// the transport, authorizing the requests of the hosts of Masters with the
+// credentials, if any. Those of other hosts, e.g. redirected to, aren't.
+func (c *Config) roundTripper() http.RoundTripper {
+	if c.credentials == nil {
+		return c.transport
+	}
+	t := &authTransport{base: c.transport, creds: c.credentials, hosts: map[string]bool{}}
+	for _, m := range c.Masters {
+		t.hosts[hostOf(m)] = true
+	}
+	return t
+}
+
+// hostOf returns the host of the given address, which may lack a port.
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return strings.ToLower(host)
+	}
+	return strings.ToLower(strings.Trim(addr, "[]"))
+}
+
+// newTLSConfig returns a tls.Config verifying servers with the CA
+// certificates of the given PEM file, if any, rather than with those of the
+// host, and presenting the certificate of the given PEM files, if any.
+func newTLSConfig(caCertFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if caCertFile != "" {
+		pem, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates in %q", caCertFile)
+		}
+	}
+
+	switch {
+	case certFile == "" && keyFile == "":
+	case certFile == "" || keyFile == "":
+		return nil, errors.New("CertFile and KeyFile must be set together")
+	default:
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// An authTransport is an http.RoundTripper authorizing the requests of its
+// base one to the given hosts with its credentials.
+type authTransport struct {
+	base  http.RoundTripper // http.DefaultTransport if nil
+	creds *credentials
+	hosts map[string]bool
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !t.hosts[hostOf(req.URL.Host)] {
+		return base.RoundTrip(req)
+	}
+
+	auth, err := t.creds.authorization()
+	if err != nil {
+		return nil, err
+	}
+	// RoundTrippers mustn't modify requests
+	authorized := *req
+	authorized.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		authorized.Header[k] = v
+	}
+	authorized.Header.Set("Authorization", auth)
+	return base.RoundTrip(&authorized)
+}
+
+// credentials are read from a JSON file holding either a "principal" and a
+// "secret", for HTTP basic authentication, or a "token", for bearer
+// authentication. The file is re-read when it changes. It's safe for
+// concurrent use.
+type credentials struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time // of the file as last read
+	size    int64     // of the file as last read
+	auth    string    // the Authorization header of the file as last read
+}
+
+// authorization returns the Authorization header of the credentials, reading
+// them again if their file changed. If it can't, it keeps the last ones read,
+// if any.
+func (c *credentials) authorization() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fi, err := os.Stat(c.path)
+	if err == nil && fi.ModTime().Equal(c.modTime) && fi.Size() == c.size {
+		return c.auth, nil
+	}
+	var auth string
+	if err == nil {
+		auth, err = readAuthorization(c.path)
+	}
+	if err != nil {
+		if c.auth == "" {
+			return "", err
+		}
+		logging.Error.Printf("failed to read credentials again, keeping the last ones: %v", err)
+		return c.auth, nil
+	}
+
+	logging.Verbose.Printf("credentials read from %q", c.path)
+	c.modTime, c.size, c.auth = fi.ModTime(), fi.Size(), auth
+	return auth, nil
+}
+
+// readAuthorization returns the Authorization header of the credentials of
+// the given file.
+func readAuthorization(path string) (string, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var creds struct {
+		Principal string `json:"principal"`
+		Secret    string `json:"secret"`
+		Token     string `json:"token"`
+	}
+	if err = json.Unmarshal(bs, &creds); err != nil {
+		return "", fmt.Errorf("failed to unmarshal credentials file %q: %v", path, err)
+	}
+
+	switch {
+	case creds.Token != "" && creds.Principal == "":
+		return "Bearer " + creds.Token, nil
+	case creds.Principal != "" && creds.Token == "":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds.Principal+":"+creds.Secret)), nil
+	default:
+		return "", fmt.Errorf("credentials file %q must hold either a principal or a token", path)
+	}
+}
@@ -0,0 +1,204 @@
+package records
+
+import (
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "credentials.json")
+	write := func(data string, age time.Duration) {
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// the modification time may not change otherwise
+		mod := time.Now().Add(-age)
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	creds := &credentials{path: path}
+	for i, tt := range []struct {
+		data string
+		age  time.Duration
+		auth string
+		err  bool
+	}{
+		{`{"principal": "p"}`, 4 * time.Hour, "Basic cDo=", false},
+		{`{"principal": "mesos-dns", "secret": "s3cr3t"}`, 3 * time.Hour, "Basic bWVzb3MtZG5zOnMzY3IzdA==", false},
+		{`{"token": "t0k3n"}`, 2 * time.Hour, "Bearer t0k3n", false},
+		{`{"principal": "p", "token": "t0k3n"}`, time.Hour, "Bearer t0k3n", false}, // keeps the last ones
+		{`{`, 0, "Bearer t0k3n", false},
+	} {
+		write(tt.data, tt.age)
+		auth, err := creds.authorization()
+		if (err != nil) != tt.err {
+			t.Errorf("test #%d: got error %v, want error %t", i+1, err, tt.err)
+		}
+		if auth != tt.auth {
+			t.Errorf("test #%d: got %q, want %q", i+1, auth, tt.auth)
+		}
+	}
+
+	for i, data := range []string{`{}`, `{`, `{"principal": "p", "token": "t"}`} {
+		write(data, 0)
+		if auth, err := (&credentials{path: path}).authorization(); err == nil {
+			t.Errorf("test #%d: got %q, want error", i+1, auth)
+		}
+	}
+	if _, err := (&credentials{path: path + ".missing"}).authorization(); err == nil {
+		t.Error("got no error for a missing file")
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range []struct {
+		ca, cert, key string
+		err           bool
+	}{
+		{"", "", "", false},
+		{empty, "", "", true},
+		{filepath.Join(dir, "missing.pem"), "", "", true},
+		{"", empty, "", true},
+		{"", "", empty, true},
+		{"", empty, empty, true},
+	} {
+		if _, err := newTLSConfig(tt.ca, tt.cert, tt.key); (err != nil) != tt.err {
+			t.Errorf("test #%d: got error %v, want error %t", i+1, err, tt.err)
+		}
+	}
+}
+
+func TestLoadFromMasterSecured(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{"leader":"master@10.0.0.1:5050"}`)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "mesos-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	ca := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+	if err := ioutil.WriteFile(ca, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	creds := filepath.Join(dir, "credentials.json")
+	if err := ioutil.WriteFile(creds, []byte(`{"token": "t0k3n"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range []struct {
+		https     bool
+		ca, creds string
+		ok        bool
+	}{
+		{false, "", "", false},
+		{true, "", creds, false}, // unknown authority
+		{true, ca, "", false},    // unauthorized
+		{true, ca, creds, true},
+	} {
+		config := NewConfig()
+		config.StateAPI = StateAPILegacy
+		config.MesosHTTPSOn = tt.https
+		config.CACertFile = tt.ca
+		config.Masters = []string{server.Listener.Addr().String()}
+		if config.transport, err = newTransport(config); err != nil {
+			t.Fatalf("test #%d: %v", i+1, err)
+		}
+		if config.credentials, err = newCredentials(tt.creds); err != nil {
+			t.Fatalf("test #%d: %v", i+1, err)
+		}
+
+		sj, err := NewRecordGenerator(config).loadFromMaster(host, port)
+		if ok := err == nil && sj.Leader == "master@10.0.0.1:5050"; ok != tt.ok {
+			t.Errorf("test #%d: got leader %q and error %v, want success %t", i+1, sj.Leader, err, tt.ok)
+		}
+	}
+}
+
+func TestAuthTransport(t *testing.T) {
+	var got []string // the Authorization headers received by the other host
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+	_, port, err := net.SplitHostPort(other.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			t.Errorf("got Authorization %q, want the master's", r.Header.Get("Authorization"))
+		}
+		http.Redirect(w, r, "http://localhost:"+port+"/", http.StatusFound)
+	}))
+	defer master.Close()
+
+	dir, err := ioutil.TempDir("", "mesos-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "credentials.json")
+	if err = ioutil.WriteFile(path, []byte(`{"token": "t0k3n"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewConfig()
+	config.Masters = []string{master.Listener.Addr().String()}
+	if config.credentials, err = newCredentials(path); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", master.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: config.roundTripper()}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if len(got) != 1 || got[0] != "" {
+		t.Errorf("got Authorization %q from the other host, want none", got)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Error("the original request was modified")
+	}
+}
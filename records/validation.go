@@ -40,8 +40,8 @@ func validateMasters(ms []string) error {
 	return nil
 }
 
-// validateIPSources checks validity of ip sources
-func validateIPSources(srcs []string) error {
+// ValidateIPSources checks validity of ip sources
+func ValidateIPSources(srcs []string) error {
 	if len(srcs) == 0 {
 		return fmt.Errorf("empty ip sources")
 	}
@@ -59,3 +59,13 @@ func validateIPSources(srcs []string) error {
 
 	return nil
 }
+
+// validateStateAPI checks that the given state API is supported.
+func validateStateAPI(api string) error {
+	switch api {
+	case StateAPIAuto, StateAPILegacy, StateAPIV1:
+		return nil
+	default:
+		return fmt.Errorf("unknown state API %q", api)
+	}
+}
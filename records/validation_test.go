@@ -44,3 +44,21 @@ func validate(t *testing.T, i int, tc validationTest, f func([]string) error) {
 		t.Fatalf("test %d failed, expected validation error for ExternalDNS(%d) %v", i, len(tc.in), tc.in)
 	}
 }
+
+func TestValidateStateAPI(t *testing.T) {
+	for i, tc := range []struct {
+		in    string
+		valid bool
+	}{
+		{"", false},
+		{"auto", true},
+		{"legacy", true},
+		{"v1", true},
+		{"v0", false},
+		{"V1", false},
+	} {
+		if err := validateStateAPI(tc.in); (err == nil) != tc.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i+1, err, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package builtin
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// Capabilities restricted by ACLs, besides HTTP routes by path.
+const (
+	// aclMesos restricts queries of the Mesos domain and reverse zones.
+	aclMesos = "mesos"
+	// aclForward restricts queries forwarded to the ExternalDNS and
+	// StubZones servers.
+	aclForward = "forward"
+	// aclXFR restricts zone transfers, on top of XFRAllowed.
+	aclXFR = "xfr"
+	// aclHTTP restricts the HTTP routes without an ACL of their own.
+	aclHTTP = "http"
+)
+
+// httpRoutes are the paths of the HTTP routes which ACLs may restrict.
+var httpRoutes = []string{
+	"/v1/version",
+	"/v1/config",
+	"/v1/hosts/{host}",
+	"/v1/hosts/{host}/ports",
+	"/v1/services/{service}",
+	"/v1/upstreams",
+	"/v1/status",
+	"/v1/enumerate",
+	"/v1/axfr",
+	"/metrics",
+	"/health",
+	"/ready",
+}
+
+// An acl is a parsed ACL.
+type acl struct {
+	allow, deny []*net.IPNet
+}
+
+// newACLs returns the acls of the given ACLs, by capability. ACLs with
+// invalid networks deny everyone.
+func newACLs(acls map[string]ACL) map[string]*acl {
+	parsed := make(map[string]*acl, len(acls))
+	for capability, a := range acls {
+		allow, err := parseNets(a.Allow)
+		if err == nil {
+			var deny []*net.IPNet
+			if deny, err = parseNets(a.Deny); err == nil {
+				parsed[capability] = &acl{allow: allow, deny: deny}
+				continue
+			}
+		}
+		logging.Error.Printf("denying %q to everyone due to invalid ACL: %v", capability, err)
+		parsed[capability] = &acl{deny: []*net.IPNet{
+			{IP: net.IPv4zero, Mask: net.CIDRMask(0, 8*net.IPv4len)},
+			{IP: net.IPv6zero, Mask: net.CIDRMask(0, 8*net.IPv6len)},
+		}}
+	}
+	return parsed
+}
+
+// allows returns true if the given address belongs to none of the denied
+// networks of the acl and, unless there are none, to one of the allowed
+// ones. Unknown addresses are only allowed if the acl is empty.
+func (a *acl) allows(ip net.IP) bool {
+	if ip == nil {
+		return len(a.allow) == 0 && len(a.deny) == 0
+	}
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed returns true if the ACL of the given capability, if any, allows the
+// given address.
+func (res *Resolver) allowed(capability string, addr net.Addr) bool {
+	a, ok := res.acls[capability]
+	return !ok || a.allows(remoteIP(addr))
+}
+
+// refuse answers the given request with REFUSED if the ACL of the given
+// capability doesn't allow its client, incrementing the given counter, and
+// returns true if so.
+func (res *Resolver) refuse(capability string, w dns.ResponseWriter, r *dns.Msg, refused logging.Counter) bool {
+	if res.allowed(capability, w.RemoteAddr()) {
+		return false
+	}
+	logging.VeryVerbose.Printf("refused %q request from %v", capability, w.RemoteAddr())
+	refused.Inc()
+	reply(w, new(dns.Msg).SetRcode(r, dns.RcodeRefused))
+	return true
+}
+
+// allowHTTP returns the given route function for the route of the given path
+// if its ACL, or else the one of all HTTP routes, allows the client of the
+// request. Forbidden requests are responded to with 403.
+func (res *Resolver) allowHTTP(path string, f restful.RouteFunction) restful.RouteFunction {
+	a, ok := res.acls[path]
+	if !ok {
+		if a, ok = res.acls[aclHTTP]; !ok {
+			return f
+		}
+	}
+	return func(req *restful.Request, resp *restful.Response) {
+		host, _, err := net.SplitHostPort(req.Request.RemoteAddr)
+		if err == nil && a.allows(net.ParseIP(host)) {
+			f(req, resp)
+			return
+		}
+		logging.CurLog.HTTPForbidden.Inc()
+		if err = resp.WriteErrorString(http.StatusForbidden, "forbidden"); err != nil {
+			logging.Error.Println(err)
+		}
+	}
+}
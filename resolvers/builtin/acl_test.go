@@ -0,0 +1,130 @@
+package builtin
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+func TestACLAllows(t *testing.T) {
+	acls := newACLs(map[string]ACL{
+		"mesos":   {Allow: []string{"10.0.0.0/8", "2001:db8::/32"}, Deny: []string{"10.1.0.0/16"}},
+		"forward": {Deny: []string{"192.168.1.1"}},
+		"xfr":     {Allow: []string{"10.0.0.0/33"}}, // invalid
+		"http":    {},
+	})
+	for i, tt := range []struct {
+		capability string
+		ip         string
+		allowed    bool
+	}{
+		{"mesos", "10.0.0.1", true},
+		{"mesos", "2001:db8::1", true},
+		{"mesos", "10.1.0.1", false},
+		{"mesos", "192.168.1.1", false},
+		{"mesos", "", false},
+		{"forward", "192.168.1.2", true},
+		{"forward", "192.168.1.1", false},
+		{"xfr", "10.0.0.1", false},
+		{"xfr", "2001:db8::1", false},
+		{"http", "10.0.0.1", true},
+		{"http", "", true},
+	} {
+		if got := acls[tt.capability].allows(net.ParseIP(tt.ip)); got != tt.allowed {
+			t.Errorf("test #%d: got allowed %t, want %t", i, got, tt.allowed)
+		}
+	}
+}
+
+func TestACLRefused(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.fwd = func(m *dns.Msg, net string) (*dns.Msg, error) {
+		return new(dns.Msg).SetReply(m), nil
+	}
+	if res.xfrNets, err = parseNets([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+	res.acls = newACLs(map[string]ACL{
+		"mesos":   {Allow: []string{"10.0.0.0/8"}},
+		"forward": {Allow: []string{"10.1.0.0/16"}},
+		"xfr":     {Deny: []string{"10.2.0.0/16"}},
+	})
+
+	for i, tt := range []struct {
+		client  string
+		handle  func(dns.ResponseWriter, *dns.Msg)
+		q       *dns.Msg
+		rcode   int
+		refused *logging.LogCounter
+	}{
+		{"10.0.0.1", res.HandleMesos, Message(Question("leader.mesos.", dns.TypeA)), dns.RcodeSuccess, nil},
+		{"192.168.0.1", res.HandleMesos, Message(Question("leader.mesos.", dns.TypeA)), dns.RcodeRefused,
+			logging.CurLog.MesosRefused.(*logging.LogCounter)},
+		{"10.1.0.1", res.HandleNonMesos, Message(Question("example.com.", dns.TypeA)), dns.RcodeSuccess, nil},
+		{"10.0.0.1", res.HandleNonMesos, Message(Question("example.com.", dns.TypeA)), dns.RcodeRefused,
+			logging.CurLog.NonMesosRefused.(*logging.LogCounter)},
+		{"10.0.0.1", res.HandleMesos, new(dns.Msg).SetAxfr("mesos."), dns.RcodeSuccess, nil},
+		{"10.2.0.1", res.HandleMesos, new(dns.Msg).SetAxfr("mesos."), dns.RcodeRefused,
+			logging.CurLog.XFRRefused.(*logging.LogCounter)},
+	} {
+		var before string
+		if tt.refused != nil {
+			before = tt.refused.String()
+		}
+		rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP(tt.client)}}
+		tt.handle(&rw, tt.q)
+		if got := rw.Msg.Rcode; got != tt.rcode {
+			t.Errorf("test #%d: got rcode %d, want %d", i, got, tt.rcode)
+		}
+		if tt.refused != nil && tt.refused.String() == before {
+			t.Errorf("test #%d: refusal not counted", i)
+		}
+	}
+}
+
+func TestAllowHTTP(t *testing.T) {
+	res := &Resolver{acls: newACLs(map[string]ACL{
+		"/v1/config": {Allow: []string{"127.0.0.1"}},
+		"http":       {Deny: []string{"10.0.0.0/8"}},
+	})}
+	ok := func(req *restful.Request, resp *restful.Response) {
+		resp.WriteHeader(http.StatusOK)
+	}
+
+	forbidden := logging.CurLog.HTTPForbidden.(*logging.LogCounter)
+	for i, tt := range []struct {
+		path   string
+		remote string
+		code   int
+	}{
+		{"/v1/config", "127.0.0.1:1234", http.StatusOK},
+		{"/v1/config", "10.1.2.3:1234", http.StatusForbidden},
+		{"/v1/config", "192.168.0.1:1234", http.StatusForbidden},
+		{"/v1/version", "192.168.0.1:1234", http.StatusOK},
+		{"/v1/version", "10.1.2.3:1234", http.StatusForbidden},
+	} {
+		before := forbidden.String()
+		req, err := http.NewRequest(http.MethodGet, tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = tt.remote
+		rec := httptest.NewRecorder()
+		res.allowHTTP(tt.path, ok)(restful.NewRequest(req), restful.NewResponse(rec))
+		if rec.Code != tt.code {
+			t.Errorf("test #%d: got status %d, want %d", i, rec.Code, tt.code)
+		}
+		if counted := forbidden.String() != before; counted != (tt.code == http.StatusForbidden) {
+			t.Errorf("test #%d: got forbidden request counted: %t", i, counted)
+		}
+	}
+}
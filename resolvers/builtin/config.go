@@ -1,6 +1,7 @@
 package builtin
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/mesosphere/mesos-dns/logging"
@@ -25,8 +26,78 @@ type Config struct {
 	Port int
 	// Value of RecursionAvailable for responses in Mesos domain
 	RecurseOn bool
-	// External DNS servers: IP address(es) of DNS server(s) for unauthoritative queries
+	// External DNS servers: address(es) of DNS server(s) for unauthoritative
+	// queries, as an IP with an optional port, a tls:// or an https:// URL
 	ExternalDNS []string
+	// CacheSize is the maximum number of replies of the ExternalDNS servers
+	// which are cached. Caching is disabled if zero.
+	CacheSize int
+	// CacheMaxTTL is the maximum number of seconds replies are cached for,
+	// regardless of their TTL
+	CacheMaxTTL int
+	// CachePrefetch is the number of hits after which cached replies are
+	// refreshed shortly before they expire. Prefetching is disabled if zero.
+	CachePrefetch int
+	// UpstreamPolicy is the order in which the ExternalDNS and StubZones
+	// servers are tried: "sequential", "round-robin", "fastest" or "race"
+	UpstreamPolicy string
+	// UpstreamCooldown is the number of seconds servers are tried last for
+	// after failing
+	UpstreamCooldown int
+	// UpstreamUDPSize is the EDNS0 UDP buffer size advertised to the
+	// ExternalDNS and StubZones servers. EDNS0 is only used if requested by
+	// clients if zero.
+	UpstreamUDPSize int
+	// UDPSize is the EDNS0 UDP buffer size advertised to clients, bounding
+	// the size of UDP replies to clients advertising larger ones
+	UDPSize int
+	// CookieSecret is the base64 encoded secret, of at least 16 bytes,
+	// server cookies (RFC 7873) are generated with. It must be shared by
+	// servers behind the same address. A random one is used if empty.
+	CookieSecret string
+	// StubZones maps domains (e.g. "corp.example.com") to the addresses, as
+	// in ExternalDNS, of the DNS servers queries for names within them are forwarded to,
+	// rather than the ExternalDNS servers. The longest matching domain wins.
+	StubZones map[string][]string
+	// ReverseCIDRs are the networks (e.g. "10.0.0.0/8") for which reverse
+	// (PTR) lookups are answered authoritatively from the Mesos records
+	ReverseCIDRs []string
+	// AnswerOrder is the order of the answers of A, AAAA and SRV replies:
+	// "random", "topology" to answer agents with the tasks in their rack,
+	// then in their dc, first, or "local" to answer them with the nearest
+	// tasks only
+	AnswerOrder string
+	// Views answer the clients of some networks differently than others.
+	// The first view whose CIDRs contain the address of a client is used.
+	Views []View
+	// ACLs restrict the clients of capabilities, by name: "mesos" for queries
+	// of the Mesos domain and reverse zones, "forward" for the ones forwarded
+	// to the ExternalDNS and StubZones servers, "xfr" for zone transfers, the
+	// path of an HTTP route (e.g. "/v1/hosts/{host}") for it, and "http"
+	// for the routes without an ACL of their own. Capabilities without an
+	// ACL are unrestricted.
+	ACLs map[string]ACL
+	// RRLResponsesPerSecond, RRLNXDomainsPerSecond and RRLErrorsPerSecond
+	// are the rates of identical answers, NXDOMAIN answers of the same zone
+	// and errors sent over UDP to the clients of each network prefix above
+	// which Response Rate Limiting drops or slips them. Unlimited if zero.
+	RRLResponsesPerSecond int
+	RRLNXDomainsPerSecond int
+	RRLErrorsPerSecond    int
+	// RRLWindow is the number of seconds over which the rates are averaged,
+	// bounding bursts to the rates times the window
+	RRLWindow int
+	// RRLSlip is how often, every Nth, rate limited responses are sent
+	// truncated rather than dropped, so that legitimate clients retry over
+	// TCP. None are if zero.
+	RRLSlip int
+	// RRLIPv4PrefixLength and RRLIPv6PrefixLength are the lengths of the
+	// network prefixes clients are grouped by
+	RRLIPv4PrefixLength int
+	RRLIPv6PrefixLength int
+	// RRLLogOnly logs and counts the responses which would be rate limited
+	// without limiting them
+	RRLLogOnly bool
 	// Timeout is the default connect/read/write timeout for outbound
 	// queries
 	Timeout int
@@ -34,6 +105,87 @@ type Config struct {
 	TTL int32
 	// Enumeration enabled via the API enumeration endpoint
 	EnumerationOn bool
+	// ReadyRefreshMultiple is the number of RefreshSeconds intervals after
+	// which records not refreshed make the /ready endpoint fail. Never if
+	// zero.
+	ReadyRefreshMultiple int
+	// XFRAllowed are the IP addresses or CIDRs of the secondaries allowed to
+	// request zone transfers (AXFR and IXFR). Transfers are refused if empty.
+	XFRAllowed []string
+	// NotifySecondaries are the addresses (IP or IP:port, port 53 by default)
+	// of the secondaries sent a NOTIFY whenever the records change.
+	NotifySecondaries []string
+	// TSIGKeys are the keys clients and secondaries may sign their requests
+	// with (RFC 2845). Zone transfers must be signed with one of them if any
+	// is configured.
+	TSIGKeys []TSIGKey
+	// NotifyTSIGKey is the name of the TSIG key NOTIFY messages are signed
+	// with, if any
+	NotifyTSIGKey string
+	// ExternalTSIGKey is the name of the TSIG key queries forwarded to the
+	// ExternalDNS servers are signed with, if any
+	ExternalTSIGKey string
+	// DNSSECKSK and DNSSECZSK are the paths, without extension, of the .key
+	// and .private files of the Key and Zone Signing Keys of the Mesos domain,
+	// e.g. "/etc/mesos-dns/Kmesos.+013+12345". Answers are signed online if
+	// both are set.
+	DNSSECKSK string
+	DNSSECZSK string
+	// DNSSECNSEC3 enables NSEC3 (RFC 5155) rather than NSEC denial of
+	// existence
+	DNSSECNSEC3 bool
+	// DoTPort is the port DNS-over-TLS (RFC 7858) requests are served on,
+	// usually 853. Disabled if zero.
+	DoTPort int
+	// DoTListener is the DNS-over-TLS server listener IP address
+	DoTListener string
+	// DoHPort is the port DNS-over-HTTPS (RFC 8484) requests are served on,
+	// usually 443. Disabled if zero.
+	DoHPort int
+	// DoHListener is the DNS-over-HTTPS server listener IP address
+	DoHListener string
+	// TLSCertFile and TLSKeyFile are the paths of the PEM encoded certificate
+	// and private key of the DNS-over-TLS and DNS-over-HTTPS servers. They're
+	// reloaded when changed.
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+// A TSIGKey is a secret shared with other name servers or clients to
+// authenticate DNS messages.
+type TSIGKey struct {
+	// Name of the key, e.g. "transfer.mesos."
+	Name string
+	// Algorithm of the key: hmac-md5, hmac-sha1, hmac-sha256 or hmac-sha512
+	Algorithm string
+	// Secret is the base64 encoded secret of the key
+	Secret string
+}
+
+// A View answers the clients of some networks differently than the others,
+// e.g. with the overlay IP addresses of tasks inside the cluster and the ones
+// of their agents outside of it. Unset fields default to the ones of the
+// Config.
+type View struct {
+	// Name of the view, e.g. "internal"
+	Name string
+	// CIDRs are the networks (e.g. "10.0.0.0/8") or IP addresses of the
+	// clients of the view
+	CIDRs []string
+	// IPSources is the prioritized list of task IP sources of the records of
+	// the view, like the IPSources of records.Config
+	IPSources []string
+	// ExternalOn and RecurseOn override the ones of the Config
+	ExternalOn *bool
+	RecurseOn  *bool
+}
+
+// An ACL allows the clients of the networks (e.g. "10.0.0.0/8") or IP
+// addresses of its Allow list, or any client if it's empty, except those of
+// its Deny list.
+type ACL struct {
+	Allow []string
+	Deny  []string
 }
 
 // NewConfig return the default config of the resolver
@@ -45,32 +197,120 @@ func NewConfig() *Config {
 		HTTPPort:      8123,
 		HTTPListener:  "0.0.0.0",
 		Listener:      "0.0.0.0",
+		DoTListener:   "0.0.0.0",
+		DoHListener:   "0.0.0.0",
 		Port:          53,
 		RecurseOn:     true,
 		ExternalDNS:   []string{"8.8.8.8"},
+		CacheMaxTTL:   3600,
+		CachePrefetch: 10,
 		Timeout:       5,
 		TTL:           60,
 		EnumerationOn: true,
+		AnswerOrder:   "random",
+
+		ReadyRefreshMultiple: 3,
+
+		UpstreamPolicy:   "sequential",
+		UpstreamCooldown: 30,
+		UpstreamUDPSize:  1232,
+		UDPSize:          1232,
+
+		RRLWindow:           15,
+		RRLSlip:             2,
+		RRLIPv4PrefixLength: 24,
+		RRLIPv6PrefixLength: 56,
 	}
 }
 
-func (c *Config) SetConfig(conf map[string]interface{}) {
+// SetConfig validates the config, defaulting the external DNS servers to the
+// ones of /etc/resolv.conf if forwarding is enabled without any.
+func (c *Config) SetConfig() error {
 	var err error
 
 	// Builtin resolver validation
-	if c.ExternalOn {
+	externalOn := c.ExternalOn
+	for _, v := range c.Views {
+		externalOn = externalOn || v.ExternalOn != nil && *v.ExternalOn
+	}
+	if externalOn {
 		if len(c.ExternalDNS) == 0 {
 			c.ExternalDNS = GetLocalDNS()
 		}
 		if err = validateExternalDNS(c.ExternalDNS); err != nil {
-			logging.Error.Fatalf("External DNS servers validation failed: %v", err)
+			return fmt.Errorf("External DNS servers validation failed: %v", err)
 		}
 	}
 
-	err = validateEnabledServices(c)
-	if err != nil {
-		logging.Error.Fatalf("service validation failed: %v", err)
+	if c.CacheSize > 0 && c.CacheMaxTTL <= 0 {
+		return fmt.Errorf("Cache validation failed: CacheMaxTTL must be positive if caching is enabled")
+	}
+
+	if err = validateUpstreamPolicy(c.UpstreamPolicy); err != nil {
+		return fmt.Errorf("Upstream policy validation failed: %v", err)
+	}
+
+	if c.UpstreamUDPSize != 0 && (c.UpstreamUDPSize < dns.MinMsgSize || c.UpstreamUDPSize > dns.MaxMsgSize) {
+		return fmt.Errorf("Upstream UDP size validation failed: must be within [%d, %d]",
+			dns.MinMsgSize, dns.MaxMsgSize)
+	}
+
+	if c.ReadyRefreshMultiple < 0 {
+		return fmt.Errorf("Ready refresh multiple validation failed: must not be negative")
+	}
+
+	if err = validateEDNS(c.UDPSize, c.CookieSecret); err != nil {
+		return fmt.Errorf("EDNS0 validation failed: %v", err)
+	}
+
+	if err = validateAnswerOrder(c.AnswerOrder); err != nil {
+		return fmt.Errorf("Answer order validation failed: %v", err)
+	}
+
+	if err = validateViews(c.Views); err != nil {
+		return fmt.Errorf("Views validation failed: %v", err)
+	}
+
+	if err = validateACLs(c.ACLs); err != nil {
+		return fmt.Errorf("ACLs validation failed: %v", err)
+	}
+
+	if err = validateRRL(c); err != nil {
+		return fmt.Errorf("Response rate limiting validation failed: %v", err)
+	}
+
+	if err = validateStubZones(c.StubZones); err != nil {
+		return fmt.Errorf("Stub zones validation failed: %v", err)
+	}
+
+	if err = validateReverseCIDRs(c.ReverseCIDRs); err != nil {
+		return fmt.Errorf("Reverse CIDRs validation failed: %v", err)
+	}
+
+	if err = validateXFRAllowed(c.XFRAllowed); err != nil {
+		return fmt.Errorf("Zone transfer secondaries validation failed: %v", err)
+	}
+
+	if err = validateNotifySecondaries(c.NotifySecondaries); err != nil {
+		return fmt.Errorf("NOTIFY secondaries validation failed: %v", err)
+	}
+
+	if (c.DNSSECKSK == "") != (c.DNSSECZSK == "") {
+		return fmt.Errorf("DNSSEC validation failed: both DNSSECKSK and DNSSECZSK must be set")
+	}
+
+	if err = validateTLS(c); err != nil {
+		return fmt.Errorf("DNS-over-TLS/HTTPS validation failed: %v", err)
+	}
+
+	if err = validateTSIGKeys(c.TSIGKeys, c.NotifyTSIGKey, c.ExternalTSIGKey); err != nil {
+		return fmt.Errorf("TSIG keys validation failed: %v", err)
+	}
+
+	if err = validateEnabledServices(c); err != nil {
+		return fmt.Errorf("service validation failed: %v", err)
 	}
+	return nil
 }
 
 // GetLocalDNS returns the first nameserver in /etc/resolv.conf
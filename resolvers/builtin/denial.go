@@ -0,0 +1,129 @@
+package builtin
+
+import (
+	"bytes"
+	"encoding/base32"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Online signing can't enumerate the zone in canonical order to find the
+// neighbours of a missing name, so NSEC and NSEC3 records are produced as
+// "white lies" (RFC 4470 and RFC 7129, section 5): their intervals only
+// cover the names being denied, which also prevents zone walking.
+
+// predecessor returns a name preceding the given one in canonical order
+// (RFC 4034, section 6.1), such that no name which may exist in the zone lies
+// between them. It decrements the last octet of the first label and appends
+// a \255 octet, e.g. "foo.mesos." yields "fon\255.mesos.". Labels of the
+// Mesos records never hold octets beyond the ASCII range.
+func predecessor(name string) string {
+	first, rest := splitFirst(name)
+	octets := unescapeLabel(first)
+	switch n := len(octets); {
+	case n > 1 && octets[n-1] == 0:
+		octets = octets[:n-1]
+	case n > 0:
+		octets[n-1]--
+		if n < 63 {
+			octets = append(octets, 0xff)
+		}
+	}
+	return joinFirst(escapeLabel(octets), rest)
+}
+
+// successor returns the name immediately following the given one, and all
+// of its subdomains, in canonical order by appending a \000 octet to its
+// first label, e.g. "foo.mesos." yields "foo\000.mesos.".
+func successor(name string) string {
+	first, rest := splitFirst(name)
+	octets := unescapeLabel(first)
+	if len(octets) < 63 {
+		octets = append(octets, 0)
+	}
+	return joinFirst(escapeLabel(octets), rest)
+}
+
+// splitFirst splits the given fully qualified name into its first label and
+// the rest of the name.
+func splitFirst(name string) (first, rest string) {
+	idx := dns.Split(name)
+	if len(idx) < 2 {
+		return strings.TrimSuffix(name, "."), "."
+	}
+	return name[:idx[1]-1], name[idx[1]:]
+}
+
+// joinFirst is the inverse of splitFirst.
+func joinFirst(first, rest string) string {
+	if rest == "." {
+		return first + rest
+	}
+	return first + "." + rest
+}
+
+// unescapeLabel returns the octets of the given label in presentation
+// format, i.e. with \DDD and \X escapes.
+func unescapeLabel(label string) []byte {
+	octets := make([]byte, 0, len(label))
+	for i := 0; i < len(label); i++ {
+		if label[i] != '\\' || i+1 == len(label) {
+			octets = append(octets, label[i])
+			continue
+		}
+		if i+3 < len(label) {
+			if d, err := strconv.ParseUint(label[i+1:i+4], 10, 8); err == nil {
+				octets = append(octets, byte(d))
+				i += 3
+				continue
+			}
+		}
+		octets = append(octets, label[i+1])
+		i++
+	}
+	return octets
+}
+
+// escapeLabel returns the given label octets in presentation format, escaping
+// all of those which aren't valid in hostnames.
+func escapeLabel(octets []byte) string {
+	var b bytes.Buffer
+	for _, o := range octets {
+		switch {
+		case o >= 'a' && o <= 'z', o >= '0' && o <= '9', o == '-', o == '_':
+			b.WriteByte(o)
+		default:
+			fmt.Fprintf(&b, "\\%03d", o)
+		}
+	}
+	return b.String()
+}
+
+// nsec3Hash returns the NSEC3 hash (RFC 5155) of the given name, computed
+// with no iterations and no salt as recommended by RFC 9276.
+func nsec3Hash(name string) string {
+	return dns.HashName(name, dns.SHA1, 0, "")
+}
+
+// nsec3Neighbours returns the hashes right before and after the given one.
+func nsec3Neighbours(hash string) (prev, next string) {
+	b, err := base32.HexEncoding.DecodeString(hash)
+	if err != nil {
+		return hash, hash
+	}
+	p, n := append([]byte(nil), b...), append([]byte(nil), b...)
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i]--; p[i] != 0xff {
+			break
+		}
+	}
+	for i := len(n) - 1; i >= 0; i-- {
+		if n[i]++; n[i] != 0 {
+			break
+		}
+	}
+	return base32.HexEncoding.EncodeToString(p), base32.HexEncoding.EncodeToString(n)
+}
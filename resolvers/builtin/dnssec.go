@@ -0,0 +1,369 @@
+package builtin
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// sigCacheSize is the maximum number of RRSIGs kept by a zoneSigner.
+const sigCacheSize = 10000
+
+// sigInception is how far back in time signatures are made valid, to allow
+// for clock skew between Mesos-DNS and validating resolvers.
+const sigInception = time.Hour
+
+// A dnssecKey pairs a DNSKEY with its private key.
+type dnssecKey struct {
+	*dns.DNSKEY
+	priv crypto.Signer
+}
+
+// loadKey reads the DNSKEY of the given zone and its private key from the
+// .key and .private files of the given path, in the format written by BIND's
+// dnssec-keygen, e.g. "Kmesos.+013+12345".
+func loadKey(path, zone string) (*dnssecKey, error) {
+	path = strings.TrimSuffix(strings.TrimSuffix(path, ".key"), ".private")
+
+	f, err := os.Open(path + ".key")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	rr, err := dns.ReadRR(f, path+".key")
+	if err != nil {
+		return nil, err
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("%s.key: not a DNSKEY record: %v", path, rr)
+	}
+	if !strings.EqualFold(key.Hdr.Name, zone) {
+		return nil, fmt.Errorf("%s.key: DNSKEY of %q, not %q", path, key.Hdr.Name, zone)
+	}
+
+	p, err := os.Open(path + ".private")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = p.Close() }()
+
+	priv, err := key.ReadPrivateKey(p, path+".private")
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s.private: unsupported private key", path)
+	}
+
+	key.Hdr.Name = zone
+	return &dnssecKey{DNSKEY: key, priv: signer}, nil
+}
+
+// A zoneSigner signs the RRsets of a zone as they're served, with its Key
+// Signing Key for the DNSKEY RRset and with its Zone Signing Key for all the
+// others. Signatures are cached until half of their validity has elapsed.
+// It's safe for concurrent use.
+type zoneSigner struct {
+	zone     string
+	ksk, zsk *dnssecKey
+	nsec3    bool // deny existence with NSEC3 rather than NSEC records
+
+	mu   sync.Mutex
+	sigs map[string]*dns.RRSIG // keyed by RRset
+}
+
+// newZoneSigner returns a zoneSigner of the given zone, with the keys read
+// from the given paths. See loadKey.
+func newZoneSigner(zone, ksk, zsk string, nsec3 bool) (*zoneSigner, error) {
+	z := &zoneSigner{zone: zone, nsec3: nsec3, sigs: map[string]*dns.RRSIG{}}
+
+	var err error
+	if z.ksk, err = loadKey(ksk, zone); err != nil {
+		return nil, fmt.Errorf("loading KSK: %v", err)
+	}
+	if z.zsk, err = loadKey(zsk, zone); err != nil {
+		return nil, fmt.Errorf("loading ZSK: %v", err)
+	}
+	return z, nil
+}
+
+// dnskeys returns the DNSKEY RRset of the zone with the given TTL.
+func (z *zoneSigner) dnskeys(ttl uint32) []dns.RR {
+	rrs := make([]dns.RR, 0, 2)
+	for _, k := range []*dnssecKey{z.ksk, z.zsk} {
+		key := *k.DNSKEY
+		key.Hdr.Ttl = ttl
+		rrs = append(rrs, &key)
+	}
+	return rrs
+}
+
+// sign returns the RRSIG of the given RRset, valid for the given duration.
+func (z *zoneSigner) sign(rrset []dns.RR, validity time.Duration) (*dns.RRSIG, error) {
+	key := z.zsk
+	if rrset[0].Header().Rrtype == dns.TypeDNSKEY {
+		key = z.ksk
+	}
+
+	id := rrsetID(rrset)
+	now := time.Now()
+
+	z.mu.Lock()
+	sig, ok := z.sigs[id]
+	z.mu.Unlock()
+	if ok && time.Unix(int64(sig.Expiration), 0).Sub(now) > validity/2 {
+		return sig, nil
+	}
+
+	sig = &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		Algorithm:  key.Algorithm,
+		Inception:  uint32(now.Add(-sigInception).Unix()),
+		Expiration: uint32(now.Add(validity).Unix()),
+		KeyTag:     key.KeyTag(),
+		SignerName: z.zone,
+	}
+	if err := sig.Sign(key.priv, rrset); err != nil {
+		return nil, err
+	}
+
+	z.mu.Lock()
+	if len(z.sigs) >= sigCacheSize {
+		z.sigs = make(map[string]*dns.RRSIG, sigCacheSize)
+	}
+	z.sigs[id] = sig
+	z.mu.Unlock()
+
+	return sig, nil
+}
+
+// rrsetID returns a key identifying the given RRset regardless of the order
+// of its records.
+func rrsetID(rrset []dns.RR) string {
+	rrs := make([]string, len(rrset))
+	for i, rr := range rrset {
+		rrs[i] = strings.ToLower(rr.String())
+	}
+	sort.Strings(rrs)
+	return strings.Join(rrs, "\n")
+}
+
+// dnssecOK returns true if the answer to the given request for the given
+// name is to be signed, i.e. if the requestor set the DNSSEC OK bit and the
+// name belongs to the signed zone.
+func (res *Resolver) dnssecOK(r *dns.Msg, name string) bool {
+	if res.dnssec == nil || !dns.IsSubDomain(res.dnssec.zone, name) {
+		return false
+	}
+	opt := r.IsEdns0()
+	return opt != nil && opt.Do()
+}
+
+// sign appends the RRSIGs of each RRset in the sections of the given
+// message to the section holding it.
+func (res *Resolver) sign(m *dns.Msg) error {
+	validity := time.Duration(res.rg.Config.SOAExpire) * time.Second
+
+	var errs multiError
+	for _, section := range []*[]dns.RR{&m.Answer, &m.Ns, &m.Extra} {
+		for _, rrset := range rrsets(*section) {
+			sig, err := res.dnssec.sign(rrset, validity)
+			if err != nil {
+				errs.Add(err)
+				continue
+			}
+			*section = append(*section, sig)
+		}
+	}
+	return errs
+}
+
+// rrsets groups the given records by owner name and type, in the order in
+// which they first appear, leaving out OPT and RRSIG records.
+func rrsets(rrs []dns.RR) [][]dns.RR {
+	type key struct {
+		name  string
+		rtype uint16
+	}
+	var (
+		sets  [][]dns.RR
+		index = map[key]int{}
+	)
+	for _, rr := range rrs {
+		switch rr.Header().Rrtype {
+		case dns.TypeOPT, dns.TypeRRSIG:
+			continue
+		}
+		k := key{strings.ToLower(rr.Header().Name), rr.Header().Rrtype}
+		i, ok := index[k]
+		if !ok {
+			i, index[k] = len(sets), len(sets)
+			sets = append(sets, nil)
+		}
+		sets[i] = append(sets[i], rr)
+	}
+	return sets
+}
+
+func (res *Resolver) handleDNSKEY(name string, m *dns.Msg) error {
+	if res.dnssec != nil && name == res.dnssec.zone {
+		m.Answer = append(m.Answer, res.dnssec.dnskeys(uint32(res.config.TTL))...)
+	}
+	return nil
+}
+
+// exists returns true if the given name owns records in the signed zone, or
+// is an ancestor of a name which does.
+func (res *Resolver) exists(rs *records.RecordGenerator, name string) bool {
+	if name == res.dnssec.zone || name == res.statusName() {
+		return true
+	}
+	suffix := "." + name
+	for _, set := range []map[string]map[string]struct{}{rs.As, rs.AAAAs, rs.SRVs} {
+		if len(set[name]) > 0 {
+			return true
+		}
+		for n, hosts := range set {
+			if len(hosts) > 0 && strings.HasSuffix(n, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// types returns the types of the records owned by the given name in the
+// signed zone, along with the given ones, in ascending order.
+func (res *Resolver) types(rs *records.RecordGenerator, name string, extra ...uint16) []uint16 {
+	types := extra
+	for t, set := range map[uint16]map[string]map[string]struct{}{
+		dns.TypeA:    rs.As,
+		dns.TypeAAAA: rs.AAAAs,
+		dns.TypeSRV:  rs.SRVs,
+	} {
+		if len(set[name]) > 0 {
+			types = append(types, t)
+		}
+	}
+	if name == res.dnssec.zone {
+		types = append(types, dns.TypeNS, dns.TypeSOA, dns.TypeDNSKEY)
+	}
+	if name == res.statusName() {
+		types = append(types, dns.TypeTXT)
+	}
+	sort.Sort(uint16s(types))
+	return types
+}
+
+// deny appends to the authority section of the given message the NSEC or
+// NSEC3 records proving that the given name doesn't exist, or that it has no
+// records of the type in question if it does.
+func (res *Resolver) deny(rs *records.RecordGenerator, name string, m *dns.Msg) {
+	ttl := uint32(res.config.TTL)
+	if res.dnssec.nsec3 {
+		m.Ns = append(m.Ns, res.denyNSEC3(rs, name, ttl)...)
+		return
+	}
+
+	if m.Rcode == dns.RcodeSuccess { // NODATA
+		types := res.types(rs, name, dns.TypeRRSIG, dns.TypeNSEC)
+		m.Ns = append(m.Ns, nsec(name, "\\000."+name, ttl, types...))
+		return
+	}
+
+	// the NSEC covering the name also proves that its parent is the closest
+	// encloser, whose wildcard is denied by the second NSEC
+	_, parent := splitFirst(name)
+	wildcard := "*." + parent
+	m.Ns = append(m.Ns,
+		nsec(predecessor(name), successor(name), ttl, dns.TypeRRSIG, dns.TypeNSEC),
+		nsec(predecessor(wildcard), successor(wildcard), ttl, dns.TypeRRSIG, dns.TypeNSEC),
+	)
+}
+
+// denyNSEC3 returns the NSEC3 records proving that the given name doesn't
+// exist, or that it has no records of the type in question if it does.
+// See: https://tools.ietf.org/html/rfc5155#section-7.2
+func (res *Resolver) denyNSEC3(rs *records.RecordGenerator, name string, ttl uint32) []dns.RR {
+	zone := res.dnssec.zone
+	if res.exists(rs, name) { // NODATA
+		return []dns.RR{res.nsec3Match(rs, name, ttl)}
+	}
+
+	// closest encloser proof and wildcard denial
+	encloser, closer := name, name
+	for encloser != zone {
+		closer = encloser
+		_, encloser = splitFirst(encloser)
+		if res.exists(rs, encloser) {
+			break
+		}
+	}
+	return []dns.RR{
+		res.nsec3Match(rs, encloser, ttl),
+		nsec3Cover(zone, closer, ttl),
+		nsec3Cover(zone, "*."+encloser, ttl),
+	}
+}
+
+// nsec3Match returns the NSEC3 record matching the given existing name.
+func (res *Resolver) nsec3Match(rs *records.RecordGenerator, name string, ttl uint32) dns.RR {
+	hash := nsec3Hash(name)
+	_, next := nsec3Neighbours(hash)
+	var types []uint16
+	if len(res.types(rs, name)) > 0 {
+		types = res.types(rs, name, dns.TypeRRSIG)
+	}
+	return nsec3(res.dnssec.zone, hash, next, ttl, types...)
+}
+
+// nsec3Cover returns the NSEC3 record covering the given missing name.
+func nsec3Cover(zone, name string, ttl uint32) dns.RR {
+	prev, next := nsec3Neighbours(nsec3Hash(name))
+	return nsec3(zone, prev, next, ttl)
+}
+
+func nsec(name, next string, ttl uint32, types ...uint16) *dns.NSEC {
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		NextDomain: next,
+		TypeBitMap: types,
+	}
+}
+
+func nsec3(zone, hash, next string, ttl uint32, types ...uint16) *dns.NSEC3 {
+	return &dns.NSEC3{
+		Hdr: dns.RR_Header{
+			Name:   strings.ToLower(hash) + "." + zone,
+			Rrtype: dns.TypeNSEC3,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Hash:       dns.SHA1,
+		HashLength: 20,
+		NextDomain: next,
+		TypeBitMap: types,
+	}
+}
+
+// uint16s implements sort.Interface for a slice of RR types.
+type uint16s []uint16
+
+func (s uint16s) Len() int           { return len(s) }
+func (s uint16s) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint16s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,308 @@
+package builtin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/miekg/dns"
+)
+
+// signedDNS returns a Resolver of the fake records signing the Mesos domain
+// with new keys, which it also returns.
+func signedDNS(t *testing.T, nsec3 bool) (*Resolver, []*dns.DNSKEY) {
+	dir, err := ioutil.TempDir("", "mesos-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var (
+		paths []string
+		keys  []*dns.DNSKEY
+	)
+	for _, flags := range []uint16{257, 256} { // KSK, ZSK
+		key := &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: "mesos.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+			Flags:     flags,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		}
+		priv, err := key.Generate(256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "Kmesos.+013+"+string('0'+rune(len(paths))))
+		if err = ioutil.WriteFile(path+".key", []byte(key.String()+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path+".private", []byte(key.PrivateKeyString(priv)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths, keys = append(paths, path), append(keys, key)
+	}
+
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	qualifySOA(res)
+
+	if res.dnssec, err = newZoneSigner("mesos.", paths[0]+".key", paths[1], nsec3); err != nil {
+		t.Fatal(err)
+	}
+	return res, keys
+}
+
+// query returns the reply of the given Resolver to a query with the DNSSEC OK
+// bit set.
+func query(res *Resolver, name string, qtype uint16) *dns.Msg {
+	q := new(dns.Msg).SetQuestion(name, qtype)
+	q.SetEdns0(4096, true)
+	rw := ResponseRecorder{
+		Local:  net.IPAddr{IP: net.ParseIP("127.0.0.1")},
+		Remote: net.IPAddr{IP: net.ParseIP("127.0.0.1")},
+	}
+	res.HandleMesos(&rw, q)
+	return rw.Msg
+}
+
+// verify checks that each RRset of the given section is validly signed by one
+// of the given keys.
+func verify(t *testing.T, section string, rrs []dns.RR, keys []*dns.DNSKEY) {
+	sigs := map[uint16][]*dns.RRSIG{}
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs[sig.TypeCovered] = append(sigs[sig.TypeCovered], sig)
+		}
+	}
+	for _, rrset := range rrsets(rrs) {
+		var verified bool
+		for _, sig := range sigs[rrset[0].Header().Rrtype] {
+			for _, key := range keys {
+				if sig.KeyTag == key.KeyTag() && sig.Verify(key, rrset) == nil &&
+					sig.ValidityPeriod(time.Now()) {
+					verified = true
+				}
+			}
+		}
+		if !verified {
+			t.Errorf("%s: unsigned RRset %v", section, rrset)
+		}
+	}
+}
+
+func TestDNSSEC(t *testing.T) {
+	res, keys := signedDNS(t, false)
+	ksk, zsk := keys[:1], keys[1:]
+
+	for i, tt := range []struct {
+		name  string
+		qtype uint16
+		rcode int
+		keys  []*dns.DNSKEY
+	}{
+		{"liquor-store.marathon.mesos.", dns.TypeA, dns.RcodeSuccess, zsk},
+		{"_liquor-store._tcp.marathon.mesos.", dns.TypeSRV, dns.RcodeSuccess, zsk},
+		{"mesos.", dns.TypeSOA, dns.RcodeSuccess, zsk},
+		{"mesos.", dns.TypeDNSKEY, dns.RcodeSuccess, ksk},
+	} {
+		m := query(res, tt.name, tt.qtype)
+		if m.Rcode != tt.rcode || len(m.Answer) == 0 {
+			t.Errorf("test #%d: got rcode %d with %d answers, want %d", i, m.Rcode, len(m.Answer), tt.rcode)
+			continue
+		}
+		verify(t, "answer", m.Answer, tt.keys)
+		verify(t, "additional", m.Extra, zsk)
+		if opt := m.IsEdns0(); opt == nil || !opt.Do() {
+			t.Errorf("test #%d: got OPT %v, want the DO bit set", i, opt)
+		}
+	}
+
+	// unsigned without the DO bit
+	rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("127.0.0.1")}}
+	res.HandleMesos(&rw, new(dns.Msg).SetQuestion("liquor-store.marathon.mesos.", dns.TypeA))
+	for _, rr := range rw.Msg.Answer {
+		if _, ok := rr.(*dns.RRSIG); ok {
+			t.Errorf("unexpected signature: %v", rr)
+		}
+	}
+}
+
+func TestDNSSECSignatureCache(t *testing.T) {
+	res, _ := signedDNS(t, false)
+
+	sigs := func() []*dns.RRSIG {
+		var sigs []*dns.RRSIG
+		for _, rr := range query(res, "liquor-store.marathon.mesos.", dns.TypeA).Answer {
+			if sig, ok := rr.(*dns.RRSIG); ok {
+				sigs = append(sigs, sig)
+			}
+		}
+		return sigs
+	}
+
+	first, second := sigs(), sigs()
+	if len(first) != 1 || len(second) != 1 || first[0].Signature != second[0].Signature {
+		t.Errorf("got signatures %v and %v, want the same cached one", first, second)
+	}
+
+	validity := time.Duration(res.rg.Config.SOAExpire) * time.Second
+	if got := time.Unix(int64(first[0].Expiration), 0).Sub(time.Now()); got > validity || got < validity-time.Minute {
+		t.Errorf("got signature validity of %v, want %v", got, validity)
+	}
+}
+
+func TestNSEC(t *testing.T) {
+	res, keys := signedDNS(t, false)
+
+	for i, tt := range []struct {
+		name  string
+		qtype uint16
+		rcode int
+		nsecs [][2]string // owner and next names
+		types []uint16    // of the first NSEC
+	}{
+		{
+			"missing.mesos.", dns.TypeA, dns.RcodeNameError,
+			[][2]string{
+				{`missinf\255.mesos.`, `missing\000.mesos.`},
+				{`\041\255.mesos.`, `\042\000.mesos.`},
+			},
+			[]uint16{dns.TypeRRSIG, dns.TypeNSEC},
+		},
+		{
+			"chronos.marathon.mesos.", dns.TypeAAAA, dns.RcodeSuccess,
+			[][2]string{{`chronos.marathon.mesos.`, `\000.chronos.marathon.mesos.`}},
+			[]uint16{dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC},
+		},
+		{ // empty non-terminal
+			"_tcp.marathon.mesos.", dns.TypeA, dns.RcodeSuccess,
+			[][2]string{{`_tcp.marathon.mesos.`, `\000._tcp.marathon.mesos.`}},
+			[]uint16{dns.TypeRRSIG, dns.TypeNSEC},
+		},
+	} {
+		m := query(res, tt.name, tt.qtype)
+		if m.Rcode != tt.rcode {
+			t.Errorf("test #%d: got rcode %d, want %d", i, m.Rcode, tt.rcode)
+		}
+		verify(t, "authority", m.Ns, keys[1:])
+
+		var nsecs []*dns.NSEC
+		for _, rr := range m.Ns {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, rr)
+			case *dns.SOA:
+				if rr.Hdr.Name != "mesos." {
+					t.Errorf("test #%d: got SOA of %q, want the zone's", i, rr.Hdr.Name)
+				}
+			}
+		}
+		if len(nsecs) != len(tt.nsecs) {
+			t.Fatalf("test #%d: got %d NSECs, want %d", i, len(nsecs), len(tt.nsecs))
+		}
+		for j, nsec := range nsecs {
+			if got := [2]string{nsec.Hdr.Name, nsec.NextDomain}; got != tt.nsecs[j] {
+				t.Errorf("test #%d: NSEC #%d: got %v, want %v", i, j, got, tt.nsecs[j])
+			}
+		}
+		if got := nsecs[0].TypeBitMap; !equalTypes(got, tt.types) {
+			t.Errorf("test #%d: got types %v, want %v", i, got, tt.types)
+		}
+	}
+}
+
+func TestNSEC3(t *testing.T) {
+	res, keys := signedDNS(t, true)
+
+	{ // NXDOMAIN: closest encloser proof and wildcard denial
+		m := query(res, "missing.marathon.mesos.", dns.TypeA)
+		if m.Rcode != dns.RcodeNameError {
+			t.Errorf("got rcode %d, want NXDOMAIN", m.Rcode)
+		}
+		verify(t, "authority", m.Ns, keys[1:])
+
+		var nsec3s []*dns.NSEC3
+		for _, rr := range m.Ns {
+			if rr, ok := rr.(*dns.NSEC3); ok {
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+		if len(nsec3s) != 3 {
+			t.Fatalf("got %d NSEC3s, want 3", len(nsec3s))
+		}
+		if !nsec3s[0].Match("marathon.mesos.") {
+			t.Errorf("NSEC3 %v doesn't match the closest encloser", nsec3s[0])
+		}
+		if !nsec3s[1].Cover("missing.marathon.mesos.") {
+			t.Errorf("NSEC3 %v doesn't cover the next closer name", nsec3s[1])
+		}
+		if !nsec3s[2].Cover("*.marathon.mesos.") {
+			t.Errorf("NSEC3 %v doesn't cover the wildcard", nsec3s[2])
+		}
+	}
+	{ // NODATA
+		m := query(res, "chronos.marathon.mesos.", dns.TypeAAAA)
+		if m.Rcode != dns.RcodeSuccess {
+			t.Errorf("got rcode %d, want NOERROR", m.Rcode)
+		}
+		var nsec3s []*dns.NSEC3
+		for _, rr := range m.Ns {
+			if rr, ok := rr.(*dns.NSEC3); ok {
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+		if len(nsec3s) != 1 || !nsec3s[0].Match("chronos.marathon.mesos.") {
+			t.Fatalf("got NSEC3s %v, want one matching the name", nsec3s)
+		}
+		if want := []uint16{dns.TypeA, dns.TypeRRSIG}; !equalTypes(nsec3s[0].TypeBitMap, want) {
+			t.Errorf("got types %v, want %v", nsec3s[0].TypeBitMap, want)
+		}
+	}
+}
+
+func TestDenialNames(t *testing.T) {
+	for i, tt := range []struct {
+		name, pred, succ string
+	}{
+		{"foo.mesos.", `fon\255.mesos.`, `foo\000.mesos.`},
+		{"a.b.mesos.", `\096\255.b.mesos.`, `a\000.b.mesos.`},
+		{`foo\000.mesos.`, "foo.mesos.", `foo\000\000.mesos.`},
+		{"mesos.", `mesor\255.`, `mesos\000.`},
+		{"*.mesos.", `\041\255.mesos.`, `\042\000.mesos.`},
+	} {
+		if got := predecessor(tt.name); got != tt.pred {
+			t.Errorf("test #%d: got predecessor %q, want %q", i, got, tt.pred)
+		}
+		if got := successor(tt.name); got != tt.succ {
+			t.Errorf("test #%d: got successor %q, want %q", i, got, tt.succ)
+		}
+	}
+
+	hash := nsec3Hash("mesos.")
+	prev, next := nsec3Neighbours(hash)
+	if !(prev < hash && hash < next) || len(prev) != len(hash) || len(next) != len(hash) {
+		t.Errorf("got neighbours %q and %q of %q", prev, next, hash)
+	}
+	if prev, next := nsec3Neighbours(strings.Repeat("V", 32)); next != strings.Repeat("0", 32) || prev[31] != 'U' {
+		t.Errorf("got neighbours %q and %q of the last hash", prev, next)
+	}
+}
+
+func equalTypes(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,212 @@
+package builtin
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// ednsCookie is the code of the COOKIE option (RFC 7873), which the dns
+	// package unpacks as an EDNS0_LOCAL option.
+	ednsCookie = 10
+	// rcodeBadVers is the BADVERS extended RCODE (RFC 6891), which shares
+	// its value with BADSIG.
+	rcodeBadVers = dns.RcodeBadSig
+)
+
+const (
+	clientCookieLen = 8
+	serverCookieLen = 16 // of the generated ones; requests may hold 8 to 32
+	// cookieLifetime is the age after which server cookies are renewed, and
+	// cookieSkew the one they may have in the future, e.g. if generated by
+	// another server of an anycast address.
+	cookieLifetime = 30 * time.Minute
+	cookieSkew     = 5 * time.Minute
+)
+
+// checkEDNS returns the error reply to the given request if its OPT record
+// is invalid: BADVERS if its EDNS version isn't 0, or FORMERR if there's more
+// than one or if it holds a malformed option. It returns nil otherwise.
+func (res *Resolver) checkEDNS(w dns.ResponseWriter, r *dns.Msg) *dns.Msg {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	rcode, opts := dns.RcodeSuccess, 0
+	for _, rr := range r.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			opts++
+		}
+	}
+	switch {
+	case opts > 1:
+		rcode = dns.RcodeFormatError
+	case opt.Version() != 0:
+		rcode = rcodeBadVers
+	default:
+		for _, o := range opt.Option {
+			if !validOption(o) {
+				rcode = dns.RcodeFormatError
+			}
+		}
+	}
+	if rcode == dns.RcodeSuccess {
+		return nil
+	}
+
+	m := new(dns.Msg).SetRcode(r, rcode)
+	res.setEDNS(w, r, m)
+	return m
+}
+
+// validOption returns false if the given option of a request is malformed: a
+// COOKIE option must hold an 8 bytes client cookie, optionally followed by an
+// 8 to 32 bytes server cookie (RFC 7873), and the scope of a client subnet
+// option must be zero (RFC 7871).
+func validOption(o dns.EDNS0) bool {
+	switch o := o.(type) {
+	case *dns.EDNS0_LOCAL:
+		n := len(o.Data) - clientCookieLen
+		return o.Code != ednsCookie || n == 0 || n >= 8 && n <= 32
+	case *dns.EDNS0_SUBNET:
+		return o.SourceScope == 0
+	}
+	return true
+}
+
+// setEDNS replaces the OPT records of the given reply, e.g. of forwarded
+// requests, with the one of the server if the given request has one, as
+// mandated by RFC 6891. It advertises the UDP size of the reply, echoes the DO
+// bit, and holds a server cookie and the client subnet of the request, scoped
+// to none, if it has them.
+func (res *Resolver) setEDNS(w dns.ResponseWriter, r, m *dns.Msg) {
+	var extra []dns.RR
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	m.Extra = extra
+
+	req := r.IsEdns0()
+	if req == nil {
+		return
+	}
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(res.udpSize(r))
+	if req.Do() {
+		opt.SetDo()
+	}
+	for _, o := range req.Option {
+		if !validOption(o) {
+			continue
+		}
+		switch o := o.(type) {
+		case *dns.EDNS0_LOCAL:
+			if o.Code == ednsCookie {
+				opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{
+					Code: ednsCookie,
+					Data: res.cookie(o.Data, remoteIP(w.RemoteAddr()), time.Now()),
+				})
+			}
+		case *dns.EDNS0_SUBNET:
+			ecs := *o
+			ecs.SourceScope = 0 // answers don't depend on it
+			opt.Option = append(opt.Option, &ecs)
+		}
+	}
+
+	// the TSIG record, if any, must remain the last one
+	if n := len(m.Extra); n > 0 && m.Extra[n-1].Header().Rrtype == dns.TypeTSIG {
+		m.Extra = append(m.Extra[:n-1:n-1], opt, m.Extra[n-1])
+	} else {
+		m.Extra = append(m.Extra, opt)
+	}
+}
+
+// udpSize returns the UDP payload size advertised in replies to the given
+// request, and so the size they're truncated to: the one advertised by its
+// OPT record, bounded by the minimum message size and the configured UDPSize.
+func (res *Resolver) udpSize(r *dns.Msg) uint16 {
+	size := uint16(dns.MinMsgSize)
+	if opt := r.IsEdns0(); opt != nil && opt.UDPSize() > size {
+		size = opt.UDPSize()
+	}
+	if max := res.config.UDPSize; max >= dns.MinMsgSize && int(size) > max {
+		size = uint16(max)
+	}
+	return size
+}
+
+// cookie returns the data of the COOKIE option of replies to requests from
+// the given address with the given one, at the given time: their client
+// cookie followed by a server cookie. The server cookie of the request is
+// echoed if it's valid and not due for renewal.
+func (res *Resolver) cookie(data []byte, ip net.IP, now time.Time) []byte {
+	client, server := data[:clientCookieLen], data[clientCookieLen:]
+	if len(server) == serverCookieLen {
+		t := time.Unix(int64(binary.BigEndian.Uint32(server[4:8])), 0)
+		if age := now.Sub(t); age < cookieLifetime && age > -cookieSkew &&
+			hmac.Equal(server, res.serverCookie(client, ip, t)) {
+			return data
+		}
+	}
+	return append(append([]byte{}, client...), res.serverCookie(client, ip, now)...)
+}
+
+// serverCookie returns the server cookie of the given client cookie and
+// address, generated at the given time, in the interoperable format of RFC
+// 9018 but hashed with HMAC-SHA256: a version, three reserved bytes, a
+// timestamp and a truncated hash of them, the client cookie and the address.
+func (res *Resolver) serverCookie(client []byte, ip net.IP, t time.Time) []byte {
+	b := make([]byte, 8, 8+sha256.Size)
+	b[0] = 1 // version
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Unix()))
+
+	mac := hmac.New(sha256.New, res.cookieSecret)
+	_, _ = mac.Write(client)
+	_, _ = mac.Write(b)
+	_, _ = mac.Write(ip.To16())
+	return mac.Sum(b)[:serverCookieLen]
+}
+
+// cookieSecret returns the given base64 encoded secret server cookies are
+// generated with, or a random one if empty.
+func cookieSecret(secret string) ([]byte, error) {
+	if secret != "" {
+		return base64.StdEncoding.DecodeString(secret)
+	}
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// withoutCookie returns the given request without its COOKIE option, if any,
+// since cookies are shared with clients only, not with the servers requests
+// are forwarded to.
+func withoutCookie(r *dns.Msg) *dns.Msg {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return r
+	}
+	var opts []dns.EDNS0
+	for _, o := range opt.Option {
+		if o.Option() != ednsCookie {
+			opts = append(opts, o)
+		}
+	}
+	if len(opts) == len(opt.Option) {
+		return r
+	}
+	r = r.Copy()
+	r.IsEdns0().Option = opts
+	return r
+}
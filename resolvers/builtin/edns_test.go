@@ -0,0 +1,200 @@
+package builtin
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/miekg/dns"
+)
+
+// opt returns an OPT record with the given UDP size, EDNS version and
+// options.
+func opt(size uint16, version uint8, opts ...dns.EDNS0) *dns.OPT {
+	o := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}, Option: opts}
+	o.SetUDPSize(size)
+	o.SetVersion(version)
+	return o
+}
+
+// cookieOpt returns a COOKIE option with the given data.
+func cookieOpt(data []byte) *dns.EDNS0_LOCAL {
+	return &dns.EDNS0_LOCAL{Code: ednsCookie, Data: data}
+}
+
+func TestEDNS(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := opt(4096, 0)
+	do.SetDo()
+	ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("10.1.2.0")}
+	scoped := *ecs
+	scoped.SourceScope = 24
+
+	for i, tt := range []struct {
+		extra   []dns.RR
+		rcode   int
+		size    uint16 // of the reply OPT, none if zero
+		do      bool
+		options int
+	}{
+		{nil, dns.RcodeSuccess, 0, false, 0},
+		{[]dns.RR{opt(4096, 0)}, dns.RcodeSuccess, 1232, false, 0},
+		{[]dns.RR{opt(800, 0)}, dns.RcodeSuccess, 800, false, 0},
+		{[]dns.RR{opt(100, 0)}, dns.RcodeSuccess, 512, false, 0},
+		{[]dns.RR{do}, dns.RcodeSuccess, 1232, true, 0},
+		{[]dns.RR{opt(4096, 1)}, rcodeBadVers, 1232, false, 0},
+		{[]dns.RR{opt(4096, 0), opt(4096, 0)}, dns.RcodeFormatError, 1232, false, 0},
+		{[]dns.RR{opt(4096, 0, cookieOpt(make([]byte, 8)))}, dns.RcodeSuccess, 1232, false, 1},
+		{[]dns.RR{opt(4096, 0, cookieOpt(make([]byte, 5)))}, dns.RcodeFormatError, 1232, false, 0},
+		{[]dns.RR{opt(4096, 0, cookieOpt(make([]byte, 12)))}, dns.RcodeFormatError, 1232, false, 0},
+		{[]dns.RR{opt(4096, 0, ecs)}, dns.RcodeSuccess, 1232, false, 1},
+		{[]dns.RR{opt(4096, 0, &scoped)}, dns.RcodeFormatError, 1232, false, 0},
+		{[]dns.RR{opt(4096, 0, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})}, dns.RcodeSuccess, 1232, false, 0},
+	} {
+		rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("127.0.0.1")}}
+		res.HandleMesos(&rw, Message(
+			Question("liquor-store.marathon.mesos.", dns.TypeA),
+			Extras(tt.extra...),
+		))
+
+		m := rw.Msg
+		if m.Rcode != tt.rcode {
+			t.Errorf("test #%d: got rcode %d, want %d", i, m.Rcode, tt.rcode)
+		}
+		if tt.rcode == dns.RcodeSuccess && len(m.Answer) == 0 {
+			t.Errorf("test #%d: got no answers", i)
+		} else if tt.rcode != dns.RcodeSuccess && len(m.Answer) != 0 {
+			t.Errorf("test #%d: got answers %v to an invalid request", i, m.Answer)
+		}
+
+		o := m.IsEdns0()
+		switch {
+		case tt.size == 0 && o != nil:
+			t.Errorf("test #%d: got OPT %v, want none", i, o)
+		case tt.size == 0:
+		case o == nil:
+			t.Errorf("test #%d: got no OPT", i)
+		case o.UDPSize() != tt.size || o.Do() != tt.do || o.Version() != 0 || len(o.Option) != tt.options:
+			t.Errorf("test #%d: got OPT %v, want size %d, DO %t and %d options", i, o, tt.size, tt.do, tt.options)
+		}
+	}
+}
+
+func TestEDNSClientSubnet(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 2, SourceNetmask: 56, Address: net.ParseIP("2001:db8::")}
+	rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("127.0.0.1")}}
+	res.HandleMesos(&rw, Message(
+		Question("liquor-store.marathon.mesos.", dns.TypeA),
+		Extras(opt(4096, 0, ecs)),
+	))
+
+	o := rw.Msg.IsEdns0()
+	if o == nil || len(o.Option) != 1 {
+		t.Fatalf("got OPT %v, want the client subnet echoed", o)
+	}
+	got, ok := o.Option[0].(*dns.EDNS0_SUBNET)
+	if !ok || got.Family != 2 || got.SourceNetmask != 56 || got.SourceScope != 0 || !got.Address.Equal(ecs.Address) {
+		t.Errorf("got client subnet %v, want %v with scope 0", o.Option[0], ecs)
+	}
+	if _, err := rw.Msg.Pack(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCookie(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := []byte("clientck")
+	ip, now := net.ParseIP("10.0.0.1"), time.Now()
+	fresh := res.cookie(client, ip, now)
+	if len(fresh) != clientCookieLen+serverCookieLen || !bytes.Equal(fresh[:clientCookieLen], client) {
+		t.Fatalf("got cookie %x, want the client cookie followed by a server one", fresh)
+	}
+	if fresh[clientCookieLen] != 1 {
+		t.Errorf("got cookie %x, want a version 1 server cookie", fresh)
+	}
+
+	other, _ := cookieSecret("")
+	for i, tt := range []struct {
+		data   []byte
+		ip     net.IP
+		secret []byte
+		at     time.Time
+		echoed bool
+	}{
+		{fresh, ip, res.cookieSecret, now.Add(time.Minute), true},
+		{fresh, ip, res.cookieSecret, now.Add(-time.Minute), true}, // from a server ahead of time
+		{fresh, ip, res.cookieSecret, now.Add(cookieLifetime), false},
+		{fresh, ip, res.cookieSecret, now.Add(-cookieSkew - time.Second), false},
+		{fresh, net.ParseIP("10.0.0.2"), res.cookieSecret, now, false},
+		{fresh, ip, other, now, false},
+		{append([]byte("clientck"), make([]byte, 8)...), ip, res.cookieSecret, now, false},
+	} {
+		r := &Resolver{cookieSecret: tt.secret}
+		got := r.cookie(tt.data, tt.ip, tt.at)
+		if echoed := bytes.Equal(got, tt.data); echoed != tt.echoed {
+			t.Errorf("test #%d: got cookie %x echoed: %t, want %t", i, got, echoed, tt.echoed)
+		}
+		if !tt.echoed && !bytes.Equal(got[clientCookieLen:], r.serverCookie(client, tt.ip, tt.at)) {
+			t.Errorf("test #%d: got cookie %x, want a new server cookie", i, got)
+		}
+	}
+}
+
+func TestEDNSForwarded(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var forwarded *dns.Msg
+	res.fwd = func(m *dns.Msg, net string) (*dns.Msg, error) {
+		forwarded = m
+		r := new(dns.Msg).SetReply(m)
+		r.SetEdns0(4096, true)
+		return r, nil
+	}
+
+	client := []byte("clientck")
+	q := Message(
+		Question("example.com.", dns.TypeA),
+		Extras(opt(1400, 0, cookieOpt(client))),
+	)
+	rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("127.0.0.1")}}
+	res.HandleNonMesos(&rw, q)
+
+	if o := forwarded.IsEdns0(); o == nil || len(o.Option) != 0 {
+		t.Errorf("forwarded OPT %v, want one without the cookie", o)
+	}
+	if o := q.IsEdns0(); len(o.Option) != 1 {
+		t.Errorf("got request OPT %v modified", o)
+	}
+	o := rw.Msg.IsEdns0()
+	if o == nil || o.UDPSize() != 1232 || o.Do() || len(o.Option) != 1 {
+		t.Fatalf("got OPT %v, want the one of the server with a cookie", o)
+	}
+	if got := o.Option[0].(*dns.EDNS0_LOCAL).Data; !bytes.Equal(got[:clientCookieLen], client) {
+		t.Errorf("got cookie %x, want the client one %x echoed", got, client)
+	}
+
+	// no OPT is returned to clients which didn't send one
+	rw = ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("127.0.0.1")}}
+	res.HandleNonMesos(&rw, Message(Question("example.com.", dns.TypeA)))
+	if o := rw.Msg.IsEdns0(); o != nil {
+		t.Errorf("got OPT %v, want none", o)
+	}
+}
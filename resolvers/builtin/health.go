@@ -0,0 +1,221 @@
+package builtin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// A health tracks what the health and readiness of a Resolver depend on. It's
+// safe for concurrent use.
+type health struct {
+	mu     sync.Mutex
+	bound  map[string]bool // DNS servers listening, by protocol
+	leader string          // as last detected
+	known  bool            // whether any leader was detected
+
+	loaded   time.Time // of the last Reload, if any
+	failures int       // consecutive failures to refresh the records since
+	lastErr  error     // of the last failure, if any
+}
+
+// listening records whether the DNS server of the given protocol is bound
+// to its address.
+func (h *health) listening(proto string, bound bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.bound == nil {
+		h.bound = map[string]bool{}
+	}
+	h.bound[proto] = bound
+}
+
+// reloaded records a Reload at the given time.
+func (h *health) reloaded(t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.loaded, h.failures, h.lastErr = t, 0, nil
+}
+
+// SetLeader records the given leading Mesos master, as detected, which is
+// empty if there's none.
+func (res *Resolver) SetLeader(leader string) {
+	res.health.mu.Lock()
+	defer res.health.mu.Unlock()
+	res.health.leader, res.health.known = leader, true
+}
+
+// SetFailure records the given failure to refresh the records, which are
+// served stale until the next Reload.
+func (res *Resolver) SetFailure(err error) {
+	res.health.mu.Lock()
+	defer res.health.mu.Unlock()
+	res.health.failures++
+	res.health.lastErr = err
+}
+
+// A healthStatus is the status reported by the health and readiness
+// endpoints.
+type healthStatus struct {
+	Status string   // "ok" or "unavailable"
+	Errors []string `json:",omitempty"`
+}
+
+// healthErrors returns why the Resolver is unhealthy, if it is: when the DNS
+// servers, if enabled, aren't all bound to their addresses. Those include the
+// DNS-over-TLS ("tls") and DNS-over-HTTPS ("https") ones, if configured.
+func (res *Resolver) healthErrors() []string {
+	if !res.config.DNSOn {
+		return nil
+	}
+	protos := []string{"udp", "tcp"}
+	if res.config.DoTPort != 0 {
+		protos = append(protos, "tls")
+	}
+	if res.config.DoHPort != 0 {
+		protos = append(protos, "https")
+	}
+	res.health.mu.Lock()
+	defer res.health.mu.Unlock()
+	var errs []string
+	for _, proto := range protos {
+		if !res.health.bound[proto] {
+			errs = append(errs, fmt.Sprintf("%s DNS server not listening", proto))
+		}
+	}
+	return errs
+}
+
+// readyErrors returns why the Resolver isn't ready to serve at the given time,
+// if it isn't: when no records were loaded yet, when they weren't refreshed
+// for ReadyRefreshMultiple times RefreshSeconds, or when no leading master is
+// detected.
+func (res *Resolver) readyErrors(now time.Time) []string {
+	errs := res.healthErrors()
+
+	res.health.mu.Lock()
+	defer res.health.mu.Unlock()
+	if res.health.loaded.IsZero() {
+		errs = append(errs, "no records loaded yet")
+	} else if max := time.Duration(res.config.ReadyRefreshMultiple*res.rg.Config.RefreshSeconds) * time.Second; max > 0 {
+		if age := now.Sub(res.health.loaded); age > max {
+			errs = append(errs, fmt.Sprintf("records not refreshed for %v", (age/time.Second)*time.Second))
+		}
+	}
+
+	switch {
+	case !res.health.known:
+		errs = append(errs, "leading master not detected yet")
+	case res.health.leader == "":
+		errs = append(errs, "no leading master")
+	}
+	return errs
+}
+
+// A refreshStatus reports the freshness of the records served.
+type refreshStatus struct {
+	Refreshed  *time.Time `json:",omitempty"` // never if nil
+	AgeSeconds int64      // since Refreshed, if any
+	Failures   int        // consecutive failures to refresh the records since
+	LastError  string     `json:",omitempty"`
+	Stale      bool       // if the last refresh failed
+	Leader     string
+}
+
+// refreshStatus returns the refreshStatus of the Resolver at the given time.
+func (res *Resolver) refreshStatus(now time.Time) refreshStatus {
+	res.health.mu.Lock()
+	defer res.health.mu.Unlock()
+	s := refreshStatus{
+		Failures: res.health.failures,
+		Stale:    res.health.failures > 0,
+		Leader:   res.health.leader,
+	}
+	if !res.health.loaded.IsZero() {
+		loaded := res.health.loaded
+		s.Refreshed = &loaded
+		s.AgeSeconds = int64(now.Sub(loaded) / time.Second)
+	}
+	if res.health.lastErr != nil {
+		s.LastError = res.health.lastErr.Error()
+	}
+	return s
+}
+
+// statusName returns the name of the TXT record reporting the refreshStatus.
+func (res *Resolver) statusName() string {
+	return "_status." + res.rg.Config.Domain + "."
+}
+
+// handleTXT answers with the refreshStatus of the Resolver if the question
+// is about its statusName.
+func (res *Resolver) handleTXT(name string, m *dns.Msg) error {
+	if name != res.statusName() {
+		return nil
+	}
+	s := res.refreshStatus(time.Now())
+	refreshed := "never"
+	if s.Refreshed != nil {
+		refreshed = s.Refreshed.UTC().Format(time.RFC3339)
+	}
+	txt := []string{
+		"refreshed=" + refreshed,
+		"age=" + strconv.FormatInt(s.AgeSeconds, 10),
+		"failures=" + strconv.Itoa(s.Failures),
+		"stale=" + strconv.FormatBool(s.Stale),
+		"leader=" + s.Leader,
+	}
+	if s.LastError != "" {
+		if txt = append(txt, "error="+s.LastError); len(txt[len(txt)-1]) > 255 {
+			txt[len(txt)-1] = txt[len(txt)-1][:255] // the limit of TXT strings
+		}
+	}
+	m.Answer = append(m.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    0, // it changes every second
+		},
+		Txt: txt,
+	})
+	return nil
+}
+
+// RestHealth handles HTTP requests of the health of Mesos-DNS, which is
+// alive and serving if it responds with 200.
+func (res *Resolver) RestHealth(req *restful.Request, resp *restful.Response) {
+	writeHealth(resp, res.healthErrors())
+}
+
+// RestReady handles HTTP requests of the readiness of Mesos-DNS, which is
+// serving fresh records if it responds with 200.
+func (res *Resolver) RestReady(req *restful.Request, resp *restful.Response) {
+	writeHealth(resp, res.readyErrors(time.Now()))
+}
+
+// RestStatus handles HTTP requests of the freshness of the records served.
+func (res *Resolver) RestStatus(req *restful.Request, resp *restful.Response) {
+	if err := resp.WriteAsJson(res.refreshStatus(time.Now())); err != nil {
+		logging.Error.Println(err)
+	}
+}
+
+// writeHealth responds with 200 and an "ok" status if there are no errors, and
+// with 503 and the errors otherwise.
+func writeHealth(resp *restful.Response, errs []string) {
+	status := healthStatus{Status: "ok", Errors: errs}
+	if len(errs) > 0 {
+		status.Status = "unavailable"
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := resp.WriteAsJson(status); err != nil {
+		logging.Error.Println(err)
+	}
+}
@@ -0,0 +1,123 @@
+package builtin
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/miekg/dns"
+)
+
+func TestReady(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.rg.Config.RefreshSeconds = 60
+	now := time.Now()
+
+	for i, tt := range []struct {
+		setup func()
+		errs  []string
+	}{
+		{func() {}, []string{"no records loaded yet", "leading master not detected yet"}},
+		{func() { res.SetLeader("") }, []string{"no records loaded yet", "no leading master"}},
+		{func() { res.Reload(res.records()); res.SetLeader("1.2.3.4:5050") }, nil},
+		{func() { res.health.reloaded(now.Add(-4 * time.Minute)) }, []string{"records not refreshed for 4m0s"}},
+		{func() { res.config.ReadyRefreshMultiple = 0 }, nil},
+		{func() { res.config.DNSOn = true }, []string{"udp DNS server not listening", "tcp DNS server not listening"}},
+		{func() { res.health.listening("udp", true); res.health.listening("tcp", true) }, nil},
+		{func() { res.config.DoTPort, res.config.DoHPort = 853, 443 }, []string{"tls DNS server not listening", "https DNS server not listening"}},
+		{func() { res.health.listening("tls", true); res.health.listening("https", true) }, nil},
+	} {
+		tt.setup()
+		if got := res.readyErrors(now); !reflect.DeepEqual(got, tt.errs) {
+			t.Errorf("test #%d: got errors %q, want %q", i, got, tt.errs)
+		}
+	}
+}
+
+func TestRestHealth(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range []struct {
+		route  restful.RouteFunction
+		code   int
+		status string
+	}{
+		{res.RestHealth, http.StatusOK, "ok"},
+		{res.RestReady, http.StatusServiceUnavailable, "unavailable"},
+	} {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.route(restful.NewRequest(req), restful.NewResponse(rec))
+		if rec.Code != tt.code {
+			t.Errorf("test #%d: got status %d, want %d", i, rec.Code, tt.code)
+		}
+		var got healthStatus
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Errorf("test #%d: %v", i, err)
+		} else if got.Status != tt.status {
+			t.Errorf("test #%d: got status %q, want %q", i, got.Status, tt.status)
+		}
+	}
+}
+
+func TestRefreshStatus(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.SetLeader("1.2.3.4:5050")
+
+	txt := func(qtype uint16) (int, []string) {
+		rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("10.0.0.1")}}
+		res.HandleMesos(&rw, Message(Question("_status.mesos.", qtype)))
+		var txt []string
+		for _, rr := range rw.Msg.Answer {
+			txt = append(txt, rr.(*dns.TXT).Txt...)
+		}
+		return rw.Msg.Rcode, txt
+	}
+
+	for i, tt := range []struct {
+		setup func()
+		txt   []string
+	}{
+		{func() {}, []string{"refreshed=never", "age=0", "failures=0", "stale=false", "leader=1.2.3.4:5050"}},
+		{func() { res.SetFailure(errors.New("no master")); res.SetFailure(errors.New("no master")) },
+			[]string{"refreshed=never", "age=0", "failures=2", "stale=true", "leader=1.2.3.4:5050", "error=no master"}},
+		{func() { res.health.reloaded(time.Unix(1e9, 0)) },
+			nil}, // checked below
+	} {
+		tt.setup()
+		rcode, got := txt(dns.TypeTXT)
+		if rcode != dns.RcodeSuccess {
+			t.Errorf("test #%d: got rcode %d", i, rcode)
+		}
+		if tt.txt != nil && !reflect.DeepEqual(got, tt.txt) {
+			t.Errorf("test #%d: got TXT %q, want %q", i, got, tt.txt)
+		}
+	}
+
+	s := res.refreshStatus(time.Unix(1e9+90, 0))
+	if s.Refreshed == nil || !s.Refreshed.Equal(time.Unix(1e9, 0)) || s.AgeSeconds != 90 || s.Stale || s.Failures != 0 {
+		t.Errorf("got status %+v after a reload", s)
+	}
+	if rcode, got := txt(dns.TypeA); rcode != dns.RcodeSuccess || len(got) != 0 {
+		t.Errorf("got rcode %d and TXT %q for an A question", rcode, got)
+	}
+}
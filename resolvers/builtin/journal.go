@@ -0,0 +1,56 @@
+package builtin
+
+import (
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// journalSize is the number of consecutive zone changes kept to answer
+// incremental zone transfers.
+const journalSize = 64
+
+// A delta holds the records deleted and added between two zone serials.
+type delta struct {
+	from, to       uint32
+	deleted, added []dns.RR
+}
+
+// journal keeps the differences between consecutive generations of records,
+// keyed by the SOA serial they lead from, so IXFR requests can be answered
+// with only what changed since the serial a secondary has.
+// It's safe for concurrent use.
+type journal struct {
+	mu     sync.RWMutex
+	deltas []delta // oldest first
+}
+
+// add records the given delta, discarding the oldest one if the journal is
+// full. deltas that don't follow the latest recorded serial break the chain,
+// so the journal is reset before adding them.
+func (j *journal) add(d delta) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if n := len(j.deltas); n > 0 && j.deltas[n-1].to != d.from {
+		j.deltas = nil
+	}
+	if len(j.deltas) == journalSize {
+		j.deltas = append(j.deltas[:0], j.deltas[1:]...)
+	}
+	j.deltas = append(j.deltas, d)
+}
+
+// since returns the chain of deltas leading from the given serial to the
+// latest one, and whether the journal reaches back to that serial at all.
+func (j *journal) since(serial uint32) ([]delta, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	for i := range j.deltas {
+		if j.deltas[i].from == serial {
+			return append([]delta(nil), j.deltas[i:]...), true
+		}
+	}
+	return nil, false
+}
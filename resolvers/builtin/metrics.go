@@ -0,0 +1,116 @@
+package builtin
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"time"
+	"unicode"
+
+	"github.com/emicklei/go-restful"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
+	"github.com/miekg/dns"
+)
+
+// Kinds of instrumented requests.
+const (
+	requestMesos     = "mesos"
+	requestForwarded = "forwarded"
+)
+
+var (
+	dnsResponses = metrics.NewCounterVec("mesos_dns_responses_total",
+		`Number of DNS responses, by kind of request ("mesos" or "forwarded"), type of question and rcode.`,
+		"kind", "qtype", "rcode")
+	dnsDuration = metrics.NewHistogramVec("mesos_dns_request_duration_seconds",
+		`Duration of the handling of DNS requests, by kind ("mesos" or "forwarded").`,
+		[]float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		"kind")
+	reloadTimestamp = metrics.NewGauge("mesos_dns_reload_timestamp_seconds",
+		"Time of the last reload of the records served.")
+)
+
+func init() {
+	registerLogOut(&logging.CurLog)
+}
+
+// registerLogOut registers the counters of the given LogOut as metrics
+// named after their fields, e.g. mesos_dns_mesos_nx_domain_total for
+// MesosNXDomain.
+func registerLogOut(l *logging.LogOut) {
+	v := reflect.ValueOf(l).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		c, ok := v.Field(i).Interface().(interface {
+			Value() uint64
+		})
+		if !ok {
+			continue
+		}
+		name := v.Type().Field(i).Name
+		metrics.CounterFunc("mesos_dns_"+snakeCase(name)+"_total", "Value of the "+name+" counter.",
+			func() float64 { return float64(c.Value()) })
+	}
+}
+
+// snakeCase converts the given CamelCase name, which may hold acronyms, to
+// snake_case.
+func snakeCase(name string) string {
+	rs := []rune(name)
+	var b bytes.Buffer
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(rs[i-1]) || i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// instrumented returns a handler which counts the responses of the given one
+// and times its handling of requests of the given kind.
+func instrumented(kind string, h func(dns.ResponseWriter, *dns.Msg)) func(dns.ResponseWriter, *dns.Msg) {
+	duration := dnsDuration.WithLabelValues(kind)
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		start := time.Now()
+		rw := &recordingWriter{ResponseWriter: w, rcode: -1}
+		h(rw, r)
+		duration.ObserveSince(start)
+
+		qtype := "none"
+		if len(r.Question) > 0 {
+			if qtype = dns.TypeToString[r.Question[0].Qtype]; qtype == "" {
+				qtype = "other" // bounds the cardinality of the metric
+			}
+		}
+		rcode := "none" // e.g. dropped by rate limiting
+		if rw.rcode >= 0 {
+			if rcode = dns.RcodeToString[rw.rcode]; rcode == "" {
+				rcode = strconv.Itoa(rw.rcode)
+			}
+		}
+		dnsResponses.WithLabelValues(kind, qtype, rcode).Inc()
+	}
+}
+
+// A recordingWriter is a dns.ResponseWriter which records the rcode of the
+// first message it writes.
+type recordingWriter struct {
+	dns.ResponseWriter
+	rcode int
+}
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (w *recordingWriter) WriteMsg(m *dns.Msg) error {
+	if w.rcode < 0 {
+		w.rcode = m.Rcode
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// RestMetrics handles HTTP requests of the metrics of Mesos-DNS, in the
+// Prometheus text format.
+func (res *Resolver) RestMetrics(req *restful.Request, resp *restful.Response) {
+	metrics.Default.ServeHTTP(resp.ResponseWriter, req.Request)
+}
@@ -0,0 +1,49 @@
+package builtin
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/miekg/dns"
+)
+
+func TestSnakeCase(t *testing.T) {
+	for i, tt := range []struct {
+		name, want string
+	}{
+		{"MesosRequests", "mesos_requests"},
+		{"MesosNXDomain", "mesos_nx_domain"},
+		{"XFRRefused", "xfr_refused"},
+		{"HTTPForbidden", "http_forbidden"},
+		{"NonMesosCacheHits", "non_mesos_cache_hits"},
+	} {
+		if got := snakeCase(tt.name); got != tt.want {
+			t.Errorf("test #%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestInstrumented(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := instrumented("test", res.HandleMesos)
+
+	for i, tt := range []struct {
+		q            *dns.Msg
+		qtype, rcode string
+	}{
+		{Message(Question("leader.mesos.", dns.TypeA)), "A", "NOERROR"},
+		{Message(Question("missing.mesos.", dns.TypeAAAA)), "AAAA", "NXDOMAIN"},
+		{Message(Question("leader.mesos.", 65000)), "other", "NOERROR"},
+	} {
+		c := dnsResponses.WithLabelValues("test", tt.qtype, tt.rcode)
+		before := c.Value()
+		h(&ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("10.0.0.1")}}, tt.q)
+		if got := c.Value() - before; got != 1 {
+			t.Errorf("test #%d: got %d responses counted, want 1", i, got)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package builtin
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// notifyRetries is the number of times a NOTIFY is retried before giving up
+// on a secondary.
+const notifyRetries = 5
+
+// notify sends DNS NOTIFY messages (RFC 1996) announcing the given serial of
+// every zone the Resolver is authoritative for to each configured secondary.
+// It returns immediately, retrying unacknowledged messages in the background
+// with exponential backoff until a newer serial is announced.
+func (res *Resolver) notify(serial uint32) {
+	if len(res.config.NotifySecondaries) == 0 {
+		return
+	}
+
+	zones, _ := reverseZones(res.config.ReverseCIDRs)
+	zones = append([]string{res.rg.Config.Domain + "."}, zones...)
+	for _, zone := range zones {
+		soa := res.formatSOA(zone)
+		soa.Serial = serial
+		for _, addr := range res.config.NotifySecondaries {
+			addr, err := notifyAddr(addr)
+			if err != nil {
+				logging.Error.Printf("not notifying secondary: %v", err)
+				continue
+			}
+			go res.notifySecondary(zone, addr, soa)
+		}
+	}
+}
+
+// notifyAddr returns the host:port address of the given secondary, which is
+// an IP address with an optional port, 53 by default.
+func notifyAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, "53"
+	}
+	if net.ParseIP(host) == nil {
+		return "", &net.ParseError{Type: "IP address", Text: host}
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", &net.ParseError{Type: "port", Text: port}
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// notifySecondary sends a NOTIFY for the given zone and SOA to addr until it
+// gets acknowledged, the retries run out or a newer serial gets announced.
+func (res *Resolver) notifySecondary(zone, addr string, soa *dns.SOA) {
+	backoff := res.notifyBackoff
+	for i := 0; i <= notifyRetries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if atomic.LoadUint32(&res.serial) != soa.Serial {
+			return // superseded
+		}
+
+		m := new(dns.Msg).SetNotify(zone)
+		m.Answer = []dns.RR{soa}
+
+		r, _, err := res.notifier.Exchange(m, addr)
+		switch {
+		case err != nil:
+			logging.VeryVerbose.Printf("notifying %s of %q serial %d: %v", addr, zone, soa.Serial, err)
+		case r.Rcode != dns.RcodeSuccess: // some servers reply with a QUERY opcode
+			logging.VeryVerbose.Printf("notifying %s of %q serial %d: unexpected reply %v",
+				addr, zone, soa.Serial, dns.RcodeToString[r.Rcode])
+		default:
+			return
+		}
+	}
+	logging.Error.Printf("giving up notifying %s of %q serial %d", addr, zone, soa.Serial)
+}
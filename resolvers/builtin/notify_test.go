@@ -0,0 +1,106 @@
+package builtin
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/exchanger"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/miekg/dns"
+)
+
+func TestReloadSerial(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.serial = 0
+
+	regenerate := func() *records.RecordGenerator {
+		config := res.records().Config
+		rg := records.NewRecordGenerator(config)
+		err := rg.InsertState(res.records().State, "mesos", "mesos-dns.mesos.", config.Masters, config.IPSources, labels.RFC952)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rg
+	}
+
+	rg := regenerate()
+	rg.UpdateSerial(res.records())
+	res.Reload(rg)
+	if res.serial != 0 || res.records().Config.SOASerial != 0 {
+		t.Errorf("unchanged records: got serial %d, want 0", res.serial)
+	}
+
+	rg = regenerate()
+	rg.As["new.mesos."] = map[string]struct{}{"1.2.3.4": {}}
+	rg.UpdateSerial(res.records())
+	res.Reload(rg)
+	if res.serial == 0 || res.records().Config.SOASerial != res.serial {
+		t.Errorf("changed records: got serial %d, want a new one", res.serial)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.config.NotifySecondaries = []string{"1.2.3.4", "[fd01:b::1]:5353"}
+	res.config.ReverseCIDRs = []string{"10.3.0.0/24"}
+	res.notifyBackoff = time.Millisecond
+	res.serial = 42
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		sent = map[string]int{}
+	)
+	wg.Add(4) // two zones to two secondaries
+	res.notifier = exchanger.Func(func(m *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if m.Opcode != dns.OpcodeNotify || len(m.Answer) != 1 || m.Answer[0].(*dns.SOA).Serial != 42 {
+			t.Errorf("unexpected NOTIFY to %s: %v", addr, m)
+		}
+		key := addr + " " + m.Question[0].Name
+		if sent[key]++; sent[key] == 1 {
+			return nil, 0, errors.New("timeout") // acknowledged on retry
+		}
+		wg.Done()
+		return new(dns.Msg).SetReply(m), 0, nil
+	})
+
+	res.notify(42)
+	wg.Wait()
+
+	for _, key := range []string{
+		"1.2.3.4:53 mesos.",
+		"1.2.3.4:53 0.3.10.in-addr.arpa.",
+		"[fd01:b::1]:5353 mesos.",
+		"[fd01:b::1]:5353 0.3.10.in-addr.arpa.",
+	} {
+		if got := sent[key]; got != 2 {
+			t.Errorf("%s: got %d NOTIFYs, want 2", key, got)
+		}
+	}
+}
+
+func TestNotifySuperseded(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.serial = 43
+
+	res.notifier = exchanger.Func(func(m *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+		t.Errorf("unexpected NOTIFY of a superseded serial to %s", addr)
+		return nil, 0, nil
+	})
+	res.notifySecondary("mesos.", "1.2.3.4:53", &dns.SOA{Serial: 42})
+}
@@ -31,6 +31,29 @@ type Resolver struct {
 	rsLock  sync.RWMutex
 	rng     *rand.Rand
 	fwd     exchanger.Forwarder
+	serial  uint32       // SOA serial of rg, as of its last Reload
+	journal journal      // zone changes for incremental transfers
+	xfrNets []*net.IPNet // secondaries allowed to transfer zones
+
+	stubs     map[string]exchanger.Forwarder  // by zone, for conditional forwarding
+	upstreams map[string]*exchanger.Upstreams // by zone, "." for ExternalDNS
+
+	notifier      exchanger.Exchanger // sends NOTIFY messages to secondaries
+	notifyBackoff time.Duration       // initial delay between NOTIFY retries
+
+	dnssec *zoneSigner // signs the Mesos domain, if configured
+
+	cookieSecret []byte // of server cookies (RFC 7873)
+
+	views []*view         // matched by clients, in order
+	acls  map[string]*acl // by capability
+
+	rrl *rateLimiter // of UDP responses, if configured
+
+	health health // of the servers, records and masters
+
+	topoLock sync.Mutex
+	topos    map[*records.RecordGenerator]*topology // built on demand
 }
 
 // New returns a Resolver with the given version and configuration.
@@ -49,11 +72,75 @@ func New(config *Config, errch chan error, rg *records.RecordGenerator, version
 		timeout = time.Duration(config.Timeout) * time.Second
 	}
 
+	// whether requests are forwarded to them depends on the view of clients
 	rs := config.ExternalDNS
-	if !config.ExternalOn {
-		rs = rs[:0]
+	secrets := tsigSecrets(config.TSIGKeys)
+	// outermost, so that cache hits are neither counted as forwarded nor
+	// exchanged with upstreams
+	cached := func(fwd exchanger.Forwarder) exchanger.Forwarder { return fwd }
+	if config.CacheSize > 0 {
+		// shared by both protocols, since truncated replies aren't cached
+		cache := exchanger.NewCache(config.CacheSize, time.Duration(config.CacheMaxTTL)*time.Second,
+			config.CachePrefetch, logging.CurLog.NonMesosCacheHits, logging.CurLog.NonMesosCacheMisses)
+		cached = func(fwd exchanger.Forwarder) exchanger.Forwarder {
+			return exchanger.CachingForwarder(cache, fwd)
+		}
+	}
+	inner, err := r.signer(config.ExternalTSIGKey)
+	if err != nil {
+		err = fmt.Errorf("Failed to setup forwarding: %v", err)
+		go func() { errch <- err }()
+	}
+	if config.UpstreamUDPSize > 0 {
+		inner = append(inner, exchanger.EDNS0(uint16(config.UpstreamUDPSize)))
+	}
+	exs := exchangers(timeout, secrets, inner, "udp", "tcp", "tls", "https")
+	policy, cooldown := config.UpstreamPolicy, time.Duration(config.UpstreamCooldown)*time.Second
+	if policy == "" {
+		policy = exchanger.Sequential
+	}
+	r.upstreams = map[string]*exchanger.Upstreams{".": exchanger.NewUpstreams(rs, policy, cooldown)}
+	r.fwd = cached(r.upstreams["."].Forwarder(exs))
+	r.stubs = make(map[string]exchanger.Forwarder, len(config.StubZones))
+	for zone, addrs := range config.StubZones {
+		zone = stubZone(zone)
+		r.upstreams[zone] = exchanger.NewUpstreams(addrs, policy, cooldown)
+		r.stubs[zone] = cached(r.upstreams[zone].Forwarder(exs))
+	}
+
+	r.views = newViews(config.Views)
+	r.acls = newACLs(config.ACLs)
+	r.rrl = newRateLimiter(config)
+
+	if r.xfrNets, err = parseNets(config.XFRAllowed); err != nil {
+		logging.Error.Printf("ignoring invalid zone transfer secondaries: %v", err)
+	}
+	if r.cookieSecret, err = cookieSecret(config.CookieSecret); err != nil {
+		logging.Error.Printf("ignoring invalid cookie secret: %v", err)
+		r.cookieSecret, _ = cookieSecret("")
+	}
+	r.serial = atomic.LoadUint32(&rg.Config.SOASerial)
+	notifySigner, err := r.signer(config.NotifyTSIGKey)
+	if err != nil {
+		err = fmt.Errorf("Failed to setup NOTIFY: %v", err)
+		go func() { errch <- err }()
+	}
+	r.notifier = exchanger.Decorate(&dns.Client{
+		Net:          "udp",
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+		TsigSecret:   secrets,
+	}, notifySigner...)
+	r.notifyBackoff = time.Second
+
+	if config.DNSSECKSK != "" && config.DNSSECZSK != "" {
+		zone := rg.Config.Domain + "."
+		if r.dnssec, err = newZoneSigner(zone, config.DNSSECKSK, config.DNSSECZSK, config.DNSSECNSEC3); err != nil {
+			err = fmt.Errorf("Failed to setup DNSSEC: %v", err)
+			go func() { errch <- err }()
+		}
 	}
-	r.fwd = exchanger.NewForwarder(rs, exchangers(timeout, "udp", "tcp"))
 
 	if config.DNSOn || config.HTTPOn {
 		// launch DNS server
@@ -69,24 +156,44 @@ func New(config *Config, errch chan error, rg *records.RecordGenerator, version
 	return r
 }
 
-func exchangers(timeout time.Duration, protos ...string) map[string]exchanger.Exchanger {
+// exchangers returns the Exchangers of the given protocols, decorated with
+// the given inner Decorators, e.g. signing messages. Besides "udp" and
+// "tcp", the protocols may be "tls" and "https" for DNS-over-TLS and
+// DNS-over-HTTPS upstreams. Truncated UDP replies are retried over TCP, if
+// it's one of the protocols.
+func exchangers(timeout time.Duration, secrets map[string]string, inner []exchanger.Decorator,
+	protos ...string) map[string]exchanger.Exchanger {
 	exs := make(map[string]exchanger.Exchanger, len(protos))
 	for _, proto := range protos {
-		exs[proto] = exchanger.Decorate(
-			&dns.Client{
+		var ex exchanger.Exchanger
+		switch proto {
+		case "tls":
+			ex = &exchanger.TLSClient{Timeout: timeout, TsigSecret: secrets}
+		case "https":
+			ex = &exchanger.HTTPSClient{Client: &http.Client{Timeout: timeout}, TsigSecret: secrets}
+		default:
+			ex = &dns.Client{
 				Net:          proto,
 				DialTimeout:  timeout,
 				ReadTimeout:  timeout,
 				WriteTimeout: timeout,
-			},
-			exchanger.ErrorLogging(logging.Error),
-			exchanger.Instrumentation(
-				logging.CurLog.NonMesosForwarded,
-				logging.CurLog.NonMesosSuccess,
-				logging.CurLog.NonMesosFailed,
-			),
+				TsigSecret:   secrets,
+			}
+		}
+		exs[proto] = exchanger.Decorate(ex,
+			append(append([]exchanger.Decorator{}, inner...),
+				exchanger.ErrorLogging(logging.Error),
+				exchanger.Instrumentation(
+					logging.CurLog.NonMesosForwarded,
+					logging.CurLog.NonMesosSuccess,
+					logging.CurLog.NonMesosFailed,
+				),
+			)...,
 		)
 	}
+	if udp, tcp := exs["udp"], exs["tcp"]; udp != nil && tcp != nil {
+		exs["udp"] = exchanger.TCPRetry(tcp)(udp)
+	}
 	return exs
 }
 
@@ -98,19 +205,31 @@ func (res *Resolver) records() *records.RecordGenerator {
 	return res.rg
 }
 
-// LaunchDNS starts a (TCP and UDP) DNS server for the Resolver,
+// LaunchDNS starts a (TCP and UDP) DNS server for the Resolver, as well as
+// the DNS-over-TLS and DNS-over-HTTPS ones if enabled,
 // returning a error channel to which errors are asynchronously sent.
 func (res *Resolver) LaunchDNS() <-chan error {
+	errCh := make(chan error, 4)
+
 	// Handers for Mesos requests
-	dns.HandleFunc(res.rg.Config.Domain+".", panicRecover(res.HandleMesos))
+	dns.HandleFunc(res.rg.Config.Domain+".", panicRecover(instrumented(requestMesos, res.rateLimited(res.tsig(res.HandleMesos)))))
+	// Handlers for reverse lookups of Mesos addresses
+	zones, err := reverseZones(res.config.ReverseCIDRs)
+	if err != nil {
+		errCh <- fmt.Errorf("Failed to setup reverse zones: %v", err)
+	}
+	for _, zone := range zones {
+		dns.HandleFunc(zone, panicRecover(instrumented(requestMesos, res.rateLimited(res.tsig(res.HandleMesos)))))
+	}
 	// Handler for nonMesos requests
-	dns.HandleFunc(".", panicRecover(res.HandleNonMesos))
+	dns.HandleFunc(".", panicRecover(instrumented(requestForwarded, res.rateLimited(res.tsig(res.HandleNonMesos)))))
 
-	errCh := make(chan error, 2)
 	_, e1 := res.Serve("tcp")
 	go func() { errCh <- <-e1 }()
 	_, e2 := res.Serve("udp")
 	go func() { errCh <- <-e2 }()
+	e3 := res.launchTLS(dns.DefaultServeMux)
+	go func() { errCh <- <-e3 }()
 	return errCh
 }
 
@@ -122,16 +241,20 @@ func (res *Resolver) Serve(proto string) (<-chan struct{}, <-chan error) {
 
 	ch := make(chan struct{})
 	server := &dns.Server{
-		Addr:              net.JoinHostPort(res.config.Listener, strconv.Itoa(res.config.Port)),
-		Net:               proto,
-		TsigSecret:        nil,
-		NotifyStartedFunc: func() { close(ch) },
+		Addr:       net.JoinHostPort(res.config.Listener, strconv.Itoa(res.config.Port)),
+		Net:        proto,
+		TsigSecret: tsigSecrets(res.config.TSIGKeys),
+		NotifyStartedFunc: func() {
+			res.health.listening(proto, true)
+			close(ch)
+		},
 	}
 
 	errCh := make(chan error, 1)
 	go func() {
 		defer close(errCh)
 		err := server.ListenAndServe()
+		res.health.listening(proto, false)
 		if err != nil {
 			errCh <- fmt.Errorf("Failed to setup %q server: %v", proto, err)
 		} else {
@@ -144,10 +267,41 @@ func (res *Resolver) Serve(proto string) (<-chan struct{}, <-chan error) {
 // Reload parses the incoming RecordGenerator to modify advertised records
 // This method is not goroutine-safe.
 func (res *Resolver) Reload(rg *records.RecordGenerator) {
+	// the serial is only bumped if records changed, by UpdateSerial
+	old, prev := res.records(), atomic.LoadUint32(&res.serial)
+	serial := atomic.LoadUint32(&rg.Config.SOASerial)
+	changed := serial != prev
+	var d *delta
+	if changed && len(res.xfrNets) > 0 {
+		// keep track of what changed for incremental zone transfers
+		deleted, added := res.diff(old, rg)
+		d = &delta{from: prev, to: serial, deleted: deleted, added: added}
+	}
+
+	views := res.generateViews(rg)
+
 	// may need to refactor for fairness
 	res.rsLock.Lock()
-	defer res.rsLock.Unlock()
+	if d != nil {
+		// along with the records, for zone transfers to see both or neither
+		res.journal.add(*d)
+	}
 	res.rg = rg
+	for i, v := range res.views {
+		v.rg = views[i]
+	}
+	atomic.StoreUint32(&res.serial, serial)
+	res.rsLock.Unlock()
+	res.topoLock.Lock()
+	res.topos = nil // of the replaced records
+	res.topoLock.Unlock()
+	now := time.Now()
+	res.health.reloaded(now)
+	reloadTimestamp.SetToTime(now)
+
+	if changed {
+		res.notify(serial)
+	}
 
 	logging.PrintCurLog()
 }
@@ -196,6 +350,41 @@ func (res *Resolver) formatA(dom string, target string) (*dns.A, error) {
 	}, nil
 }
 
+// returns the AAAA resource record for target
+// assumes target is a well formed IPv6 address
+func (res *Resolver) formatAAAA(dom string, target string) (*dns.AAAA, error) {
+	ttl := uint32(res.config.TTL)
+
+	a := net.ParseIP(target)
+	if a == nil || a.To4() != nil {
+		return nil, errors.New("invalid target")
+	}
+
+	return &dns.AAAA{
+		Hdr: dns.RR_Header{
+			Name:   dom,
+			Rrtype: dns.TypeAAAA,
+			Class:  dns.ClassINET,
+			Ttl:    ttl},
+		AAAA: a.To16(),
+	}, nil
+}
+
+// formatPTR returns the PTR resource record for target
+func (res *Resolver) formatPTR(name string, target string) *dns.PTR {
+	ttl := uint32(res.config.TTL)
+
+	return &dns.PTR{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypePTR,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Ptr: target,
+	}
+}
+
 // formatSOA returns the SOA resource record for the mesos domain
 func (res *Resolver) formatSOA(dom string) *dns.SOA {
 	ttl := uint32(res.config.TTL)
@@ -247,15 +436,46 @@ func shuffleAnswers(rng *rand.Rand, answers []dns.RR) []dns.RR {
 // external DNS servers.
 func (res *Resolver) HandleNonMesos(w dns.ResponseWriter, r *dns.Msg) {
 	logging.CurLog.NonMesosRequests.Inc()
-	m, err := res.fwd(r, w.RemoteAddr().Network())
+	if res.refuse(aclForward, w, r, logging.CurLog.NonMesosRefused) {
+		return
+	}
+	if m := res.checkEDNS(w, r); m != nil {
+		reply(w, m)
+		return
+	}
+	fwd := res.forwarder(res.view(w.RemoteAddr()), r.Question[0].Name)
+	m, err := fwd(withoutCookie(r), w.RemoteAddr().Network())
 	if err != nil {
 		m = new(dns.Msg).SetRcode(r, rcode(err))
 	} else if len(m.Answer) == 0 {
 		logging.CurLog.NonMesosNXDomain.Inc()
 	}
+	res.setEDNS(w, r, m)
 	reply(w, m)
 }
 
+// forwarder returns the Forwarder of the longest of the StubZones the given
+// name belongs to, or the one of the ExternalDNS servers if none and if
+// they're enabled in the given view.
+func (res *Resolver) forwarder(v *view, name string) exchanger.Forwarder {
+	name = stubZone(name)
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if fwd, ok := res.stubs[name[off:]]; ok {
+			return fwd
+		}
+	}
+	if !res.externalOn(v) {
+		return refused
+	}
+	return res.fwd
+}
+
+// stubZone returns the given zone name in the canonical form it's looked up
+// with.
+func stubZone(zone string) string {
+	return dns.Fqdn(strings.ToLower(zone))
+}
+
 func rcode(err error) int {
 	switch err.(type) {
 	case *exchanger.ForwardError:
@@ -267,44 +487,76 @@ func rcode(err error) int {
 
 // HandleMesos is a resolver request handler that responds to a resource
 // question with resource answer(s)
-// it can handle {A, SRV, ANY}
+// it can handle {A, AAAA, PTR, SRV, ANY} as well as zone transfers
+// {AXFR, IXFR}
 func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
 	logging.CurLog.MesosRequests.Inc()
+	if m := res.checkEDNS(w, r); m != nil {
+		reply(w, m)
+		return
+	}
 
+	switch r.Question[0].Qtype {
+	case dns.TypeAXFR, dns.TypeIXFR:
+		res.handleXFR(w, r)
+		return
+	}
+	if res.refuse(aclMesos, w, r, logging.CurLog.MesosRefused) {
+		return
+	}
+
+	v := res.view(w.RemoteAddr())
 	m := &dns.Msg{MsgHdr: dns.MsgHdr{
 		Authoritative:      true,
-		RecursionAvailable: res.config.RecurseOn,
+		RecursionAvailable: res.recurseOn(v),
 	}}
 	m.SetReply(r)
 
 	var errs multiError
-	rg := res.records()
+	rg := res.viewRecords(v)
 	name := strings.ToLower(cleanWild(r.Question[0].Name))
 	switch r.Question[0].Qtype {
 	case dns.TypeSRV:
 		errs.Add(res.handleSRV(rg, name, m, r))
 	case dns.TypeA:
 		errs.Add(res.handleA(rg, name, m))
+	case dns.TypeAAAA:
+		errs.Add(res.handleAAAA(rg, name, m))
+	case dns.TypePTR:
+		errs.Add(res.handlePTR(rg, name, m))
 	case dns.TypeSOA:
 		errs.Add(res.handleSOA(m, r))
 	case dns.TypeNS:
 		errs.Add(res.handleNS(m, r))
+	case dns.TypeDNSKEY:
+		errs.Add(res.handleDNSKEY(name, m))
+	case dns.TypeTXT:
+		errs.Add(res.handleTXT(name, m))
 	case dns.TypeANY:
 		errs.Add(
 			res.handleSRV(rg, name, m, r),
 			res.handleA(rg, name, m),
+			res.handleAAAA(rg, name, m),
+			res.handlePTR(rg, name, m),
 			res.handleSOA(m, r),
 			res.handleNS(m, r),
+			res.handleDNSKEY(name, m),
+			res.handleTXT(name, m),
 		)
 	}
 
 	if len(m.Answer) == 0 {
 		errs.Add(res.handleEmpty(rg, name, m, r))
 	} else {
-		shuffleAnswers(res.rng, m.Answer)
+		res.orderAnswers(rg, remoteIP(w.RemoteAddr()), m)
 		logging.CurLog.MesosSuccess.Inc()
 	}
 
+	if res.dnssecOK(r, name) {
+		errs.Add(res.sign(m))
+	}
+	res.setEDNS(w, r, m)
+
 	if !errs.Nil() {
 		logging.Error.Println(errs.Error())
 		logging.CurLog.MesosFailed.Inc()
@@ -315,7 +567,7 @@ func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
 
 func (res *Resolver) handleSRV(rs *records.RecordGenerator, name string, m, r *dns.Msg) error {
 	var errs multiError
-	added := map[string]struct{}{} // track the A RR's we've already added, avoid dups
+	added := map[string]struct{}{} // track the A/AAAA RR's we've already added, avoid dups
 	for srv := range rs.SRVs[name] {
 		srvRR, err := res.formatSRV(r.Question[0].Name, srv)
 		if err != nil {
@@ -329,18 +581,23 @@ func (res *Resolver) handleSRV(rs *records.RecordGenerator, name string, m, r *d
 			// avoid dups
 			continue
 		}
-		if len(rs.As[host]) == 0 {
-			continue
-		}
+		added[host] = struct{}{}
 
 		if a, ok := rs.As.First(host); ok {
 			aRR, err := res.formatA(host, a)
 			if err != nil {
 				errs.Add(err)
-				continue
+			} else {
+				m.Extra = append(m.Extra, aRR)
+			}
+		}
+		if aaaa, ok := rs.AAAAs.First(host); ok {
+			aaaaRR, err := res.formatAAAA(host, aaaa)
+			if err != nil {
+				errs.Add(err)
+			} else {
+				m.Extra = append(m.Extra, aaaaRR)
 			}
-			m.Extra = append(m.Extra, aRR)
-			added[host] = struct{}{}
 		}
 	}
 	return errs
@@ -359,17 +616,57 @@ func (res *Resolver) handleA(rs *records.RecordGenerator, name string, m *dns.Ms
 	return errs
 }
 
+func (res *Resolver) handleAAAA(rs *records.RecordGenerator, name string, m *dns.Msg) error {
+	var errs multiError
+	for aaaa := range rs.AAAAs[name] {
+		rr, err := res.formatAAAA(name, aaaa)
+		if err != nil {
+			errs.Add(err)
+			continue
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+	return errs
+}
+
+func (res *Resolver) handlePTR(rs *records.RecordGenerator, name string, m *dns.Msg) error {
+	for ptr := range rs.PTRs[name] {
+		m.Answer = append(m.Answer, res.formatPTR(name, ptr))
+	}
+	return nil
+}
+
+// handleSOA adds the SOA record of the zone to the authority section. Signed
+// replies, whose authority section only holds signed denials, answer with it
+// instead if the question is about the apex, and are NODATA otherwise.
 func (res *Resolver) handleSOA(m, r *dns.Msg) error {
-	m.Ns = append(m.Ns, res.formatSOA(r.Question[0].Name))
+	name := strings.ToLower(r.Question[0].Name)
+	switch {
+	case !res.dnssecOK(r, name):
+		m.Ns = append(m.Ns, res.formatSOA(r.Question[0].Name))
+	case res.authoritative(name):
+		m.Answer = append(m.Answer, res.formatSOA(name))
+	}
 	return nil
 }
 
+// handleNS adds the NS record of the zone like handleSOA.
 func (res *Resolver) handleNS(m, r *dns.Msg) error {
-	m.Ns = append(m.Ns, res.formatNS(r.Question[0].Name))
+	name := strings.ToLower(r.Question[0].Name)
+	switch {
+	case !res.dnssecOK(r, name):
+		m.Ns = append(m.Ns, res.formatNS(r.Question[0].Name))
+	case res.authoritative(name):
+		m.Answer = append(m.Answer, res.formatNS(name))
+	}
 	return nil
 }
 
 func (res *Resolver) handleEmpty(rs *records.RecordGenerator, name string, m, r *dns.Msg) error {
+	if res.dnssecOK(r, name) {
+		return res.handleSignedEmpty(rs, name, m)
+	}
+
 	qType := r.Question[0].Qtype
 	switch qType {
 	case dns.TypeSOA, dns.TypeNS, dns.TypeSRV:
@@ -379,21 +676,11 @@ func (res *Resolver) handleEmpty(rs *records.RecordGenerator, name string, m, r
 
 	m.Rcode = dns.RcodeNameError
 
-	// Because we don't implement AAAA records, AAAA queries will always
-	// go via this path
-	// Unfortunately, we don't implement AAAA queries in Mesos-DNS,
-	// and although the 'Not Implemented' error code seems more suitable,
-	// RFCs do not recommend it: https://tools.ietf.org/html/rfc4074
-	// Therefore we always return success, which is synonymous with NODATA
-	// to get a positive cache on no records AAAA
-	// Further information:
-	// PR: https://github.com/mesosphere/mesos-dns/pull/366
-	// Issue: https://github.com/mesosphere/mesos-dns/issues/363
-
-	// The second component is just a matter of returning NODATA if we have
-	// SRV or A records for the given name, but no neccessarily the given query
-
-	if (qType == dns.TypeAAAA) || (len(rs.SRVs[name])+len(rs.As[name]) > 0) {
+	// Return NODATA if we have SRV, A, AAAA or PTR records for the given name,
+	// but not necessarily for the given query type. This is what lets
+	// resolvers cache the absence of e.g. AAAA records for an IPv4-only task.
+	// See: https://tools.ietf.org/html/rfc4074
+	if len(rs.SRVs[name])+len(rs.As[name])+len(rs.AAAAs[name])+len(rs.PTRs[name]) > 0 || name == res.statusName() {
 		m.Rcode = dns.RcodeSuccess
 	}
 
@@ -406,6 +693,21 @@ func (res *Resolver) handleEmpty(rs *records.RecordGenerator, name string, m, r
 	return nil
 }
 
+// handleSignedEmpty replies like handleEmpty to requests whose replies are
+// signed, with the SOA record of the zone and the NSEC or NSEC3 records
+// authenticating the denial, for any query type.
+func (res *Resolver) handleSignedEmpty(rs *records.RecordGenerator, name string, m *dns.Msg) error {
+	m.Rcode = dns.RcodeNameError
+	if res.exists(rs, name) {
+		m.Rcode = dns.RcodeSuccess
+	}
+
+	logging.CurLog.MesosNXDomain.Inc()
+	m.Ns = append(m.Ns, res.formatSOA(res.dnssec.zone))
+	res.deny(rs, name, m)
+	return nil
+}
+
 // reply writes the given dns.Msg out to the given dns.ResponseWriter,
 // compressing the message first and truncating it accordingly.
 func reply(w dns.ResponseWriter, m *dns.Msg) {
@@ -437,7 +739,14 @@ func truncate(m *dns.Msg, udp bool) *dns.Msg {
 		return m
 	}
 
-	m.Extra = nil // Drop all extra records first
+	// Drop all extra records first, but the OPT one (RFC 6891, section 7)
+	var opt []dns.RR
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			opt = append(opt, rr)
+		}
+	}
+	m.Extra = opt
 	if m.Len() < max {
 		return m
 	}
@@ -461,14 +770,22 @@ func truncate(m *dns.Msg, udp bool) *dns.Msg {
 func (res *Resolver) configureHTTP() {
 	// webserver + available routes
 	ws := new(restful.WebService)
-	ws.Route(ws.GET("/v1/version").To(res.RestVersion))
-	ws.Route(ws.GET("/v1/config").To(res.RestConfig))
-	ws.Route(ws.GET("/v1/hosts/{host}").To(res.RestHost))
-	ws.Route(ws.GET("/v1/hosts/{host}/ports").To(res.RestPorts))
-	ws.Route(ws.GET("/v1/services/{service}").To(res.RestService))
+	route := func(path string, f restful.RouteFunction) {
+		ws.Route(ws.GET(path).To(res.allowHTTP(path, f)))
+	}
+	route("/v1/version", res.RestVersion)
+	route("/v1/config", res.RestConfig)
+	route("/v1/hosts/{host}", res.RestHost)
+	route("/v1/hosts/{host}/ports", res.RestPorts)
+	route("/v1/services/{service}", res.RestService)
+	route("/v1/upstreams", res.RestUpstreams)
+	route("/v1/status", res.RestStatus)
+	route("/metrics", res.RestMetrics)
+	route("/health", res.RestHealth)
+	route("/ready", res.RestReady)
 	if res.config.EnumerationOn {
-		ws.Route(ws.GET("/v1/enumerate").To(res.RestEnumerate))
-		ws.Route(ws.GET("/v1/axfr").To(res.RestAXFR))
+		route("/v1/enumerate", res.RestEnumerate)
+		route("/v1/axfr", res.RestAXFR)
 	}
 	restful.Add(ws)
 }
@@ -502,6 +819,18 @@ func (res *Resolver) RestConfig(req *restful.Request, resp *restful.Response) {
 	}
 }
 
+// RestUpstreams handles HTTP requests of the health statistics of the
+// upstream DNS servers, by zone.
+func (res *Resolver) RestUpstreams(req *restful.Request, resp *restful.Response) {
+	stats := make(map[string][]exchanger.UpstreamStats, len(res.upstreams))
+	for zone, u := range res.upstreams {
+		stats[zone] = u.Stats()
+	}
+	if err := resp.WriteAsJson(stats); err != nil {
+		logging.Error.Println(err)
+	}
+}
+
 // RestEnumerate handles HTTP requests of the enumeration data
 func (res *Resolver) RestEnumerate(req *restful.Request, resp *restful.Response) {
 
@@ -516,8 +845,10 @@ func (res *Resolver) RestAXFR(req *restful.Request, resp *restful.Response) {
 	records := res.records()
 
 	AXFRRecords := models.AXFRRecords{
-		SRVs: records.SRVs.ToAXFRResourceRecordSet(),
-		As:   records.As.ToAXFRResourceRecordSet(),
+		SRVs:  records.SRVs.ToAXFRResourceRecordSet(),
+		As:    records.As.ToAXFRResourceRecordSet(),
+		AAAAs: records.AAAAs.ToAXFRResourceRecordSet(),
+		PTRs:  records.PTRs.ToAXFRResourceRecordSet(),
 	}
 	AXFR := models.AXFR{
 		Records:        AXFRRecords,
@@ -561,11 +892,14 @@ func (res *Resolver) RestHost(req *restful.Request, resp *restful.Response) {
 		IP   string `json:"ip"`
 	}
 
-	aRRs := rs.As[dom]
-	records := make([]record, 0, len(aRRs))
+	aRRs, aaaaRRs := rs.As[dom], rs.AAAAs[dom]
+	records := make([]record, 0, len(aRRs)+len(aaaaRRs))
 	for ip := range aRRs {
 		records = append(records, record{dom, ip})
 	}
+	for ip := range aaaaRRs {
+		records = append(records, record{dom, ip})
+	}
 
 	if len(records) == 0 {
 		records = append(records, record{})
@@ -575,7 +909,7 @@ func (res *Resolver) RestHost(req *restful.Request, resp *restful.Response) {
 		logging.Error.Println(err)
 	}
 
-	stats(dom, res.rg.Config.Domain+".", len(aRRs) > 0)
+	stats(dom, res.rg.Config.Domain+".", len(aRRs)+len(aaaaRRs) > 0)
 }
 
 func stats(domain, zone string, success bool) {
@@ -624,6 +958,8 @@ func (res *Resolver) RestService(req *restful.Request, resp *restful.Response) {
 		var ip string
 		if r, ok := rs.As.First(host); ok {
 			ip = r
+		} else if r, ok := rs.AAAAs.First(host); ok {
+			ip = r
 		}
 		records = append(records, record{service, host, ip, port})
 	}
@@ -12,11 +12,14 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/kylelemons/godebug/pretty"
 	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/exchanger"
 	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
 	"github.com/mesosphere/mesos-dns/records"
 	"github.com/mesosphere/mesos-dns/records/labels"
 	"github.com/mesosphere/mesos-dns/records/state"
@@ -77,24 +80,42 @@ func TestShuffleAnswers(t *testing.T) {
 }
 
 func TestHandlers(t *testing.T) {
-	if err := runHandlers(); err != nil {
-		t.Error(err)
+	for _, rrl := range []bool{false, true} {
+		if err := runHandlers(rrl); err != nil {
+			t.Errorf("rrl %t: %v", rrl, err)
+		}
 	}
 }
 
 func BenchmarkHandlers(b *testing.B) {
 	for n := 0; n < b.N; n++ {
-		if err := runHandlers(); err != nil {
+		if err := runHandlers(false); err != nil {
+			b.Error(err)
+		}
+	}
+}
+
+func BenchmarkHandlersRRL(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		if err := runHandlers(true); err != nil {
 			b.Error(err)
 		}
 	}
 }
 
-func runHandlers() error {
+// runHandlers checks the responses of the handlers to a set of requests,
+// received over UDP and rate limited, with rates no response exceeds, if rrl.
+func runHandlers(rrl bool) error {
 	res, err := fakeDNS()
 	if err != nil {
 		return err
 	}
+	if rrl {
+		res.config.RRLResponsesPerSecond = 1e6
+		res.config.RRLNXDomainsPerSecond = 1e6
+		res.config.RRLErrorsPerSecond = 1e6
+		res.rrl = newRateLimiter(res.config)
+	}
 
 	res.fwd = func(m *dns.Msg, net string) (*dns.Msg, error) {
 		rr1, err := res.formatA("google.com.", "1.1.1.1")
@@ -184,6 +205,15 @@ func runHandlers() error {
 					SOA(RRHeader("non-existing.mesos.", dns.TypeSOA, 60),
 						"ns1.mesos", "root.ns1.mesos", 60))),
 		},
+		{ // unsigned replies hold the SOA of the apex in the authority section too
+			res.HandleMesos,
+			Message(
+				Question("mesos.", dns.TypeSOA),
+				Header(true, dns.RcodeSuccess),
+				NSs(
+					SOA(RRHeader("mesos.", dns.TypeSOA, 60),
+						"ns1.mesos", "root.ns1.mesos", 60))),
+		},
 		{
 			res.HandleMesos,
 			Message(
@@ -213,12 +243,70 @@ func runHandlers() error {
 		{
 			res.HandleMesos,
 			Message(
-				Question("missing.mesos.", dns.TypeAAAA),
+				Question("nginx.marathon.mesos.", dns.TypeAAAA),
+				Header(true, dns.RcodeSuccess),
+				Answers(
+					AAAA(RRHeader("nginx.marathon.mesos.", dns.TypeAAAA, 60),
+						net.ParseIP("fd01:b::1:8000:3")))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("nginx.marathon.mesos.", dns.TypeANY),
 				Header(true, dns.RcodeSuccess),
+				Answers(
+					AAAA(RRHeader("nginx.marathon.mesos.", dns.TypeAAAA, 60),
+						net.ParseIP("fd01:b::1:8000:3"))),
+				NSs(
+					SOA(RRHeader("nginx.marathon.mesos.", dns.TypeSOA, 60),
+						"ns1.mesos", "root.ns1.mesos", 60),
+					NS(RRHeader("nginx.marathon.mesos.", dns.TypeNS, 60), "ns1.mesos"))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("missing.mesos.", dns.TypeAAAA),
+				Header(true, dns.RcodeNameError),
 				NSs(
 					SOA(RRHeader("missing.mesos.", dns.TypeSOA, 60),
 						"ns1.mesos", "root.ns1.mesos", 60))),
 		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("3.0.3.10.in-addr.arpa.", dns.TypePTR), // nginx only has its netinfo IPv6 address
+				Header(true, dns.RcodeNameError),
+				NSs(
+					SOA(RRHeader("3.0.3.10.in-addr.arpa.", dns.TypeSOA, 60),
+						"ns1.mesos", "root.ns1.mesos", 60))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("3.0.0.0.0.0.0.8.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.b.0.0.0.1.0.d.f.ip6.arpa.", dns.TypePTR),
+				Header(true, dns.RcodeSuccess),
+				Answers(
+					PTR(RRHeader("3.0.0.0.0.0.0.8.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.b.0.0.0.1.0.d.f.ip6.arpa.", dns.TypePTR, 60),
+						"nginx-6ud99-0.marathon.mesos."))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("1.0.3.10.in-addr.arpa.", dns.TypeA),
+				Header(true, dns.RcodeSuccess),
+				NSs(
+					SOA(RRHeader("1.0.3.10.in-addr.arpa.", dns.TypeSOA, 60),
+						"ns1.mesos", "root.ns1.mesos", 60))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("9.9.3.10.in-addr.arpa.", dns.TypePTR),
+				Header(true, dns.RcodeNameError),
+				NSs(
+					SOA(RRHeader("9.9.3.10.in-addr.arpa.", dns.TypeSOA, 60),
+						"ns1.mesos", "root.ns1.mesos", 60))),
+		},
 		{
 			res.HandleNonMesos,
 			Message(
@@ -230,7 +318,13 @@ func runHandlers() error {
 		},
 	} {
 		var rw ResponseRecorder
-		tt.HandlerFunc(&rw, tt.Msg)
+		if rrl {
+			udp := &udpRecorder{remote: net.UDPAddr{IP: net.ParseIP("10.0.0.1")}}
+			res.rateLimited(tt.HandlerFunc)(udp, tt.Msg)
+			rw = udp.ResponseRecorder
+		} else {
+			tt.HandlerFunc(&rw, tt.Msg)
+		}
 		if got, want := rw.Msg, tt.Msg; !(Msg{got}).equivalent(Msg{want}) {
 			return fmt.Errorf("Test #%d\n%v\n%s\n", i, pretty.Sprint(tt.Msg.Question), pretty.Compare(got, want))
 		}
@@ -291,6 +385,48 @@ func (rr RRs) equivalent(other RRs) bool {
 	return len(rrhash) == 0
 }
 
+func TestStubZones(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var used string
+	forwarder := func(name string) exchanger.Forwarder {
+		return func(m *dns.Msg, net string) (*dns.Msg, error) {
+			used = name
+			return new(dns.Msg).SetReply(m), nil
+		}
+	}
+	res.fwd = forwarder("external")
+	res.stubs = map[string]exchanger.Forwarder{
+		"example.com.":      forwarder("example.com."),
+		"corp.example.com.": forwarder("corp.example.com."),
+		"consul.":           forwarder("consul."),
+	}
+
+	for i, tt := range []struct {
+		name, want string
+	}{
+		{"example.com.", "example.com."},
+		{"www.example.com.", "example.com."},
+		{"corp.example.com.", "corp.example.com."},
+		{"Host.CORP.example.com.", "corp.example.com."},
+		{"othercorp.example.com.", "example.com."},
+		{"web.service.consul.", "consul."},
+		{"example.org.", "external"},
+		{"com.", "external"},
+		{".", "external"},
+	} {
+		used = ""
+		var rw ResponseRecorder
+		res.HandleNonMesos(&rw, new(dns.Msg).SetQuestion(tt.name, dns.TypeA))
+		if used != tt.want {
+			t.Errorf("test #%d: %q forwarded with %q, want %q", i, tt.name, used, tt.want)
+		}
+	}
+}
+
 func TestHTTP(t *testing.T) {
 	// setup DNS server (just http)
 	res, err := fakeDNS()
@@ -338,6 +474,18 @@ func TestHTTP(t *testing.T) {
 				"ip":   "1.2.3.4",
 			}},
 		},
+		{"/v1/upstreams", http.StatusOK, map[string]interface{}{},
+			map[string]interface{}{
+				".": []interface{}{map[string]interface{}{
+					"Addr":                "8.8.8.8",
+					"RTTMillis":           0.0,
+					"ConsecutiveFailures": 0.0,
+					"Exchanges":           0.0,
+					"Errors":              0.0,
+					"CoolingDown":         false,
+				}},
+			},
+		},
 	} {
 		if resp, err := http.Get(srv.URL + tt.path); err != nil {
 			t.Error(err)
@@ -351,18 +499,38 @@ func TestHTTP(t *testing.T) {
 			_ = resp.Body.Close()
 		}
 	}
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Header.Get("Content-Type"), metrics.ContentType; got != want {
+		t.Errorf("GET /metrics: Content-Type: got %q, want %q", got, want)
+	}
+	for _, name := range []string{"mesos_dns_mesos_requests_total", "mesos_dns_state_fetch_failures_total"} {
+		if !strings.Contains(string(body), "\n"+name+" ") {
+			t.Errorf("GET /metrics: missing %s", name)
+		}
+	}
 }
 
 func fakeDNS() (*Resolver, error) {
 	var err error
 
 	c := records.NewConfig()
-	c.IPSources = []string{"docker", "mesos", "host"}
+	c.IPSources = []string{"netinfo", "docker", "mesos", "host"}
 	// Matches "leader" in fake.json
 	c.Masters = []string{"1.2.3.4:5050"}
 
 	config := NewConfig()
 	config.RecurseOn = false
+	// handlers are called directly, without the servers
+	config.DNSOn, config.HTTPOn = false, false
 
 	rg := records.NewRecordGenerator(c)
 
@@ -393,6 +561,13 @@ func fakeDNS() (*Resolver, error) {
 	return res, nil
 }
 
+// qualifySOA makes the SOA names of the given Resolver's records fully
+// qualified, as done by records.SetConfig, for the records to pack.
+func qualifySOA(res *Resolver) {
+	config := res.records().Config
+	config.SOAMname, config.SOARname = dns.Fqdn(config.SOAMname), dns.Fqdn(config.SOARname)
+}
+
 func onError(abort <-chan struct{}, errCh <-chan error, f func(error)) <-chan struct{} {
 	ch := make(chan struct{})
 	go func() {
@@ -445,6 +620,25 @@ func TestTruncate(t *testing.T) {
 	}
 }
 
+func TestTruncateEDNS0(t *testing.T) {
+	m := Message(
+		Question("example.com.", dns.TypeA),
+		Answers(genA(100)...),
+		Extras(genA(10)...))
+	m.SetEdns0(1024, false)
+
+	tm := truncate(m, true)
+	if !tm.Truncated {
+		t.Fatal("Message not truncated")
+	}
+	if l := tm.Len(); l > 1024 || l < 512 {
+		t.Fatalf("Message not truncated to the advertised size: %d bytes", l)
+	}
+	if len(tm.Extra) != 1 || tm.IsEdns0() == nil {
+		t.Fatalf("got extra records %v, want the OPT one only", tm.Extra)
+	}
+}
+
 func BenchmarkTruncate(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		newTruncated()
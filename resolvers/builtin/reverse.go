@@ -0,0 +1,74 @@
+package builtin
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// reverseZones returns the reverse DNS zones (in-addr.arpa. and ip6.arpa.)
+// covering exactly the given CIDRs. Since reverse zones can only be delegated
+// on label boundaries (octets for IPv4, nibbles for IPv6), networks whose
+// prefix doesn't fall on one are split into the zones of their subnets on the
+// next boundary, e.g. 10.0.0.0/23 yields 0.0.10.in-addr.arpa. and
+// 1.0.10.in-addr.arpa.
+func reverseZones(cidrs []string) ([]string, error) {
+	var zones []string
+	seen := make(map[string]struct{})
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+
+		ip, step, suffix := ipnet.IP.To4(), 8, "in-addr.arpa."
+		if ip == nil {
+			ip, step, suffix = ipnet.IP.To16(), 4, "ip6.arpa."
+		}
+
+		ones, _ := ipnet.Mask.Size()
+		boundary := (ones + step - 1) / step * step
+		for i := 0; i < 1<<uint(boundary-ones); i++ {
+			subnet := make(net.IP, len(ip))
+			copy(subnet, ip)
+			setUnit(subnet, boundary/step-1, step, i)
+
+			zone := reverseZone(subnet, boundary/step, step, suffix)
+			if _, ok := seen[zone]; !ok {
+				seen[zone] = struct{}{}
+				zones = append(zones, zone)
+			}
+		}
+	}
+	return zones, nil
+}
+
+// setUnit ORs v into the n-th octet (step 8) or nibble (step 4) of ip.
+func setUnit(ip net.IP, n, step, v int) {
+	if n < 0 || v == 0 {
+		return
+	}
+	if step == 8 {
+		ip[n] |= byte(v)
+	} else if n%2 == 0 {
+		ip[n/2] |= byte(v << 4)
+	} else {
+		ip[n/2] |= byte(v)
+	}
+}
+
+// reverseZone returns the reverse zone name made of the first n octets
+// (step 8) or nibbles (step 4) of ip.
+func reverseZone(ip net.IP, n, step int, suffix string) string {
+	labels := make([]string, 0, n+1)
+	for i := n - 1; i >= 0; i-- {
+		if step == 8 {
+			labels = append(labels, strconv.Itoa(int(ip[i])))
+		} else if i%2 == 0 {
+			labels = append(labels, strconv.FormatUint(uint64(ip[i/2]>>4), 16))
+		} else {
+			labels = append(labels, strconv.FormatUint(uint64(ip[i/2]&0xf), 16))
+		}
+	}
+	return strings.Join(append(labels, suffix), ".")
+}
@@ -0,0 +1,38 @@
+package builtin
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReverseZones(t *testing.T) {
+	for i, tt := range []struct {
+		cidrs []string
+		zones []string
+		err   bool
+	}{
+		{nil, nil, false},
+		{[]string{"10.0.0.0/8"}, []string{"10.in-addr.arpa."}, false},
+		{[]string{"10.1.2.3/24"}, []string{"2.1.10.in-addr.arpa."}, false},
+		{[]string{"10.1.2.0/23"}, []string{"2.1.10.in-addr.arpa.", "3.1.10.in-addr.arpa."}, false},
+		{[]string{"192.168.0.0/15"}, []string{"168.192.in-addr.arpa.", "169.192.in-addr.arpa."}, false},
+		{[]string{"10.0.0.0/8", "10.0.0.0/8"}, []string{"10.in-addr.arpa."}, false},
+		{[]string{"0.0.0.0/0"}, []string{"in-addr.arpa."}, false},
+		{[]string{"fd01:b::/32"}, []string{"b.0.0.0.1.0.d.f.ip6.arpa."}, false},
+		{[]string{"fd01:b::/31"}, []string{"a.0.0.0.1.0.d.f.ip6.arpa.", "b.0.0.0.1.0.d.f.ip6.arpa."}, false},
+		{[]string{"fd01:b::/30"}, []string{
+			"8.0.0.0.1.0.d.f.ip6.arpa.",
+			"9.0.0.0.1.0.d.f.ip6.arpa.",
+			"a.0.0.0.1.0.d.f.ip6.arpa.",
+			"b.0.0.0.1.0.d.f.ip6.arpa.",
+		}, false},
+		{[]string{"10.0.0.0"}, nil, true},
+	} {
+		zones, err := reverseZones(tt.cidrs)
+		if (err != nil) != tt.err {
+			t.Errorf("test #%d: unexpected error: %v", i, err)
+		} else if !reflect.DeepEqual(zones, tt.zones) {
+			t.Errorf("test #%d: got %v, want %v", i, zones, tt.zones)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package builtin
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// Categories of rate limited responses.
+const (
+	rrlResponses = iota // answers and empty answers
+	rrlNXDomains
+	rrlErrors
+	rrlCategories
+)
+
+// rrlShards is the number of independently locked shards of the buckets of a
+// rateLimiter, reducing contention between concurrent responses.
+const rrlShards = 64
+
+// Outcomes of rate limiting a response.
+const (
+	rrlSend = iota
+	rrlDrop
+	rrlSlip // send it truncated, so that legitimate clients retry over TCP
+)
+
+// A rateLimiter implements Response Rate Limiting, as done by BIND, limiting
+// the rate of identical responses sent to the clients of each network prefix
+// with token buckets. It's safe for concurrent use.
+type rateLimiter struct {
+	rates   [rrlCategories]float64 // tokens per second, unlimited if zero
+	burst   [rrlCategories]float64 // capacity of the buckets
+	window  time.Duration
+	slip    int
+	v4, v6  net.IPMask
+	logOnly bool
+	now     func() time.Time
+
+	shards [rrlShards]rrlShard
+}
+
+// A rrlShard holds some of the buckets of a rateLimiter.
+type rrlShard struct {
+	mu      sync.Mutex
+	buckets map[uint64]*rrlBucket // by key
+	swept   time.Time             // last removal of idle buckets
+}
+
+// A rrlBucket is the token bucket of identical responses to a network prefix.
+type rrlBucket struct {
+	tokens  float64
+	last    time.Time // of the last refill
+	limited int       // consecutive responses exceeding the rate
+}
+
+// newRateLimiter returns a rateLimiter of the given configuration, or nil if
+// it doesn't limit any category of responses.
+func newRateLimiter(c *Config) *rateLimiter {
+	if c.RRLResponsesPerSecond <= 0 && c.RRLNXDomainsPerSecond <= 0 && c.RRLErrorsPerSecond <= 0 {
+		return nil
+	}
+	window := time.Duration(c.RRLWindow) * time.Second
+	if window <= 0 {
+		window = time.Second
+	}
+	rl := &rateLimiter{
+		rates: [rrlCategories]float64{
+			float64(c.RRLResponsesPerSecond),
+			float64(c.RRLNXDomainsPerSecond),
+			float64(c.RRLErrorsPerSecond),
+		},
+		window:  window,
+		slip:    c.RRLSlip,
+		v4:      net.CIDRMask(c.RRLIPv4PrefixLength, 8*net.IPv4len),
+		v6:      net.CIDRMask(c.RRLIPv6PrefixLength, 8*net.IPv6len),
+		logOnly: c.RRLLogOnly,
+		now:     time.Now,
+	}
+	for i, rate := range rl.rates {
+		rl.burst[i] = rate * window.Seconds()
+	}
+	for i := range rl.shards {
+		rl.shards[i].buckets = map[uint64]*rrlBucket{}
+	}
+	return rl
+}
+
+// category returns the category of the given response and the name and type
+// its bucket is keyed with: the question for answers, the zone, if known, for
+// NXDOMAIN so that random subdomains share a bucket, and none for errors.
+func category(m *dns.Msg) (cat int, name string, qtype uint16) {
+	if len(m.Question) > 0 {
+		name, qtype = m.Question[0].Name, m.Question[0].Qtype
+	}
+	switch m.Rcode {
+	case dns.RcodeSuccess:
+		return rrlResponses, name, qtype
+	case dns.RcodeNameError:
+		for _, rr := range m.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				return rrlNXDomains, soa.Hdr.Name, 0
+			}
+		}
+		return rrlNXDomains, name, qtype
+	default:
+		return rrlErrors, "", 0
+	}
+}
+
+// FNV-1a parameters, see: https://tools.ietf.org/html/draft-eastlake-fnv
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// key returns the key of the bucket of responses of the given category, name
+// and type to the network prefix of the given address: their 64-bit FNV-1a
+// hash, names being case insensitive. Unlike a string, it's computed without
+// allocating.
+func (rl *rateLimiter) key(ip net.IP, cat int, name string, qtype uint16) uint64 {
+	ip, mask := rl.mask(ip)
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(ip) && i < len(mask); i++ {
+		h = (h ^ uint64(ip[i]&mask[i])) * fnvPrime64
+	}
+	for _, b := range [...]byte{byte(cat), byte(qtype >> 8), byte(qtype)} {
+		h = (h ^ uint64(b)) * fnvPrime64
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		h = (h ^ uint64(c)) * fnvPrime64
+	}
+	return h
+}
+
+// mask returns the given address, in its 4-byte form if it's an IPv4 one,
+// and the mask of its network prefix.
+func (rl *rateLimiter) mask(ip net.IP) (net.IP, net.IPMask) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, rl.v4
+	}
+	return ip, rl.v6
+}
+
+// limit returns whether the given response to the given client address must
+// be sent, dropped or slipped.
+func (rl *rateLimiter) limit(ip net.IP, m *dns.Msg) int {
+	cat, name, qtype := category(m)
+	if rl.rates[cat] <= 0 || ip == nil {
+		return rrlSend
+	}
+
+	key := rl.key(ip, cat, name, qtype)
+	shard := &rl.shards[key%rrlShards]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := rl.now()
+	if now.Sub(shard.swept) >= rl.window {
+		// buckets idle for a whole window are full, and so needless
+		for k, b := range shard.buckets {
+			if now.Sub(b.last) >= rl.window {
+				delete(shard.buckets, k)
+			}
+		}
+		shard.swept = now
+	}
+
+	b, ok := shard.buckets[key]
+	if !ok {
+		b = &rrlBucket{tokens: rl.burst[cat], last: now}
+		shard.buckets[key] = b
+	}
+	if b.tokens += now.Sub(b.last).Seconds() * rl.rates[cat]; b.tokens > rl.burst[cat] {
+		b.tokens = rl.burst[cat]
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = 0
+		return rrlSend
+	}
+
+	b.limited++
+	logging.CurLog.RateLimited.Inc()
+	if b.limited == 1 {
+		ip, mask := rl.mask(ip)
+		logging.Verbose.Printf("rate limiting responses to %v (%s %s)", ip.Mask(mask), name, dns.TypeToString[qtype])
+	}
+	switch {
+	case rl.logOnly:
+		return rrlSend
+	case rl.slip > 0 && b.limited%rl.slip == 0:
+		logging.CurLog.RateLimitSlipped.Inc()
+		return rrlSlip
+	default:
+		return rrlDrop
+	}
+}
+
+// rateLimited returns a handler which rate limits the UDP responses of the
+// given one, if configured. TCP responses can't be sent to spoofed
+// addresses, and so aren't limited.
+func (res *Resolver) rateLimited(h func(dns.ResponseWriter, *dns.Msg)) func(dns.ResponseWriter, *dns.Msg) {
+	if res.rrl == nil {
+		return h
+	}
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		if !isUDP(w) {
+			h(w, r)
+			return
+		}
+		h(&limitingWriter{ResponseWriter: w, rl: res.rrl}, r)
+	}
+}
+
+// A limitingWriter is a dns.ResponseWriter which drops or truncates the
+// messages it writes if they exceed the rates of its rateLimiter.
+type limitingWriter struct {
+	dns.ResponseWriter
+	rl *rateLimiter
+}
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (w *limitingWriter) WriteMsg(m *dns.Msg) error {
+	switch w.rl.limit(remoteIP(w.RemoteAddr()), m) {
+	case rrlDrop:
+		return nil
+	case rrlSlip:
+		m = slipped(m)
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// slipped returns the given message truncated to its header, question, and
+// OPT and TSIG records.
+func slipped(m *dns.Msg) *dns.Msg {
+	tc := &dns.Msg{MsgHdr: m.MsgHdr, Compress: m.Compress, Question: m.Question}
+	tc.Truncated = true
+	for _, rr := range m.Extra {
+		switch rr.Header().Rrtype {
+		case dns.TypeOPT, dns.TypeTSIG:
+			tc.Extra = append(tc.Extra, rr)
+		}
+	}
+	return tc
+}
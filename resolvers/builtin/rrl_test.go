@@ -0,0 +1,133 @@
+package builtin
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/miekg/dns"
+)
+
+func TestRateLimit(t *testing.T) {
+	c := NewConfig()
+	c.RRLResponsesPerSecond, c.RRLNXDomainsPerSecond, c.RRLErrorsPerSecond = 2, 1, 1
+	c.RRLWindow, c.RRLSlip = 1, 2
+	rl := newRateLimiter(c)
+	now := time.Unix(1e9, 0)
+	rl.now = func() time.Time { return now }
+
+	answer := func(name string) *dns.Msg {
+		return new(dns.Msg).SetReply(Message(Question(name, dns.TypeA)))
+	}
+	nxdomain := func(name string) *dns.Msg {
+		m := new(dns.Msg).SetRcode(Message(Question(name, dns.TypeA)), dns.RcodeNameError)
+		m.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: "mesos.", Rrtype: dns.TypeSOA, Class: dns.ClassINET}}}
+		return m
+	}
+	servfail := func(name string) *dns.Msg {
+		return new(dns.Msg).SetRcode(Message(Question(name, dns.TypeA)), dns.RcodeServerFailure)
+	}
+
+	for i, tt := range []struct {
+		client  string
+		m       *dns.Msg
+		elapsed time.Duration
+		want    int
+	}{
+		{"10.0.0.1", answer("a.mesos."), 0, rrlSend},
+		{"10.0.0.2", answer("a.mesos."), 0, rrlSend},
+		{"10.0.0.3", answer("a.mesos."), 0, rrlDrop}, // same /24
+		{"10.0.0.1", answer("a.mesos."), 0, rrlSlip},
+		{"10.0.0.1", answer("a.mesos."), 0, rrlDrop},
+		{"10.0.1.1", answer("a.mesos."), 0, rrlSend},
+		{"10.0.0.1", answer("b.mesos."), 0, rrlSend},
+		{"10.0.0.1", answer("a.mesos."), 500 * time.Millisecond, rrlSend},
+		{"10.0.0.1", answer("a.mesos."), 0, rrlDrop},
+		{"10.0.0.1", nxdomain("x.mesos."), 0, rrlSend},
+		{"10.0.0.1", nxdomain("y.mesos."), 0, rrlDrop}, // same zone
+		{"10.0.0.1", servfail("a.mesos."), 0, rrlSend},
+		{"10.0.0.1", servfail("b.mesos."), 0, rrlDrop},
+		{"10.0.0.1", servfail("b.mesos."), 0, rrlSlip},
+		{"2001:db8::1", answer("a.mesos."), 0, rrlSend},
+		{"2001:db8::2", answer("a.mesos."), 0, rrlSend},
+		{"2001:db8::3", answer("a.mesos."), 0, rrlDrop}, // same /56
+		{"2001:db8:0:100::1", answer("a.mesos."), 0, rrlSend},
+		{"10.0.0.1", answer("a.mesos."), time.Minute, rrlSend},
+		{"10.0.0.1", answer("a.mesos."), 0, rrlSend},
+		{"10.0.0.1", answer("a.mesos."), 0, rrlDrop}, // the burst is bounded by the window
+	} {
+		now = now.Add(tt.elapsed)
+		if got := rl.limit(net.ParseIP(tt.client), tt.m); got != tt.want {
+			t.Errorf("test #%d: got %d, want %d", i, got, tt.want)
+		}
+	}
+
+	rl.logOnly = true
+	if got := rl.limit(net.ParseIP("10.0.0.1"), answer("a.mesos.")); got != rrlSend {
+		t.Errorf("got %d in log-only mode, want %d", got, rrlSend)
+	}
+	if newRateLimiter(NewConfig()) != nil {
+		t.Error("got a rate limiter without rates")
+	}
+}
+
+func BenchmarkRateLimit(b *testing.B) {
+	c := NewConfig()
+	c.RRLResponsesPerSecond, c.RRLNXDomainsPerSecond, c.RRLErrorsPerSecond = 1e6, 1e6, 1e6
+	rl := newRateLimiter(c)
+	ip := net.ParseIP("10.0.0.1")
+	m := new(dns.Msg).SetReply(Message(Question("leader.mesos.", dns.TypeA)))
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		rl.limit(ip, m)
+	}
+}
+
+// udpRecorder is a ResponseRecorder of UDP requests.
+type udpRecorder struct {
+	ResponseRecorder
+	remote net.UDPAddr
+}
+
+func (r *udpRecorder) RemoteAddr() net.Addr { return &r.remote }
+
+func TestRateLimited(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.config.RRLResponsesPerSecond, res.config.RRLWindow, res.config.RRLSlip = 2, 1, 1
+	res.rrl = newRateLimiter(res.config)
+	h := res.rateLimited(res.HandleMesos)
+
+	for i, tt := range []struct {
+		w         dns.ResponseWriter
+		truncated bool
+	}{
+		{&udpRecorder{remote: net.UDPAddr{IP: net.ParseIP("10.0.0.1")}}, false},
+		{&udpRecorder{remote: net.UDPAddr{IP: net.ParseIP("10.0.0.1")}}, false},
+		{&udpRecorder{remote: net.UDPAddr{IP: net.ParseIP("10.0.0.1")}}, true},
+		{&ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("10.0.0.1")}}, false}, // not UDP
+	} {
+		q := Message(Question("leader.mesos.", dns.TypeA))
+		h(tt.w, q)
+		var m *dns.Msg
+		switch w := tt.w.(type) {
+		case *udpRecorder:
+			m = w.Msg
+		case *ResponseRecorder:
+			m = w.Msg
+		}
+		if m == nil {
+			t.Fatalf("test #%d: no response", i)
+		}
+		if m.Truncated != tt.truncated {
+			t.Errorf("test #%d: got truncated %t, want %t", i, m.Truncated, tt.truncated)
+		}
+		if tt.truncated && len(m.Answer) != 0 {
+			t.Errorf("test #%d: got answers %v in slipped response", i, m.Answer)
+		}
+	}
+}
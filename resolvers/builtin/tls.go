@@ -0,0 +1,378 @@
+package builtin
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+const (
+	// dotIdleTimeout is how long idle DNS-over-TLS connections are kept open.
+	// See: https://tools.ietf.org/html/rfc7858#section-3.4
+	dotIdleTimeout = 2 * time.Minute
+	// dohPath is the URI path of DNS-over-HTTPS requests.
+	// See: https://tools.ietf.org/html/rfc8484#section-4.1
+	dohPath = "/dns-query"
+	// dohMediaType is the media type of DNS-over-HTTPS messages.
+	dohMediaType = "application/dns-message"
+)
+
+// A certificate is a TLS certificate which is reloaded from its files when
+// they change. It's safe for concurrent use.
+type certificate struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // of the most recently modified file
+}
+
+// newCertificate returns the certificate loaded from the given PEM encoded
+// certificate and key files.
+func newCertificate(certFile, keyFile string) (*certificate, error) {
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload loads the certificate from its files if they were modified since
+// it was last loaded.
+func (c *certificate) reload() error {
+	var modTime time.Time
+	for _, f := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cert != nil && modTime.Equal(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	if c.cert != nil {
+		logging.Verbose.Printf("reloaded TLS certificate from %s", c.certFile)
+	}
+	c.cert, c.modTime = &cert, modTime
+	return nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if
+// its files changed. It keeps serving the previous one if they can't be
+// loaded, e.g. while only one of them was replaced. It implements the
+// tls.Config.GetCertificate callback.
+func (c *certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := c.reload(); err != nil {
+		logging.Error.Printf("failed to reload TLS certificate from %s: %v", c.certFile, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, nil
+}
+
+// tlsConfig returns a TLS configuration serving the given certificate.
+func tlsConfig(cert *certificate, protos ...string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: cert.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     protos,
+	}
+}
+
+// launchTLS starts the DNS-over-TLS and DNS-over-HTTPS servers of the
+// Resolver which are enabled, serving requests with the given handler. It
+// returns an error channel to which errors are asynchronously sent.
+func (res *Resolver) launchTLS(h dns.Handler) <-chan error {
+	errCh := make(chan error, 2)
+	if res.config.DoTPort == 0 && res.config.DoHPort == 0 {
+		return errCh
+	}
+
+	cert, err := newCertificate(res.config.TLSCertFile, res.config.TLSKeyFile)
+	if err != nil {
+		errCh <- fmt.Errorf("Failed to load TLS certificate: %v", err)
+		return errCh
+	}
+
+	if res.config.DoTPort != 0 {
+		addr := net.JoinHostPort(res.config.DoTListener, strconv.Itoa(res.config.DoTPort))
+		go func() {
+			l, err := tls.Listen("tcp", addr, tlsConfig(cert, "dot"))
+			if err == nil {
+				res.health.listening("tls", true)
+				err = res.serveDoT(l, h)
+				res.health.listening("tls", false)
+			}
+			errCh <- fmt.Errorf("Failed to setup DNS-over-TLS server: %v", err)
+		}()
+	}
+	if res.config.DoHPort != 0 {
+		srv := &http.Server{
+			Addr:      net.JoinHostPort(res.config.DoHListener, strconv.Itoa(res.config.DoHPort)),
+			Handler:   res.dohHandler(h),
+			TLSConfig: tlsConfig(cert, "h2", "http/1.1"),
+		}
+		go func() {
+			// http.Server doesn't tell when it's bound, so it's considered
+			// listening until it fails
+			res.health.listening("https", true)
+			err := srv.ListenAndServeTLS("", "")
+			res.health.listening("https", false)
+			errCh <- fmt.Errorf("Failed to setup DNS-over-HTTPS server: %v", err)
+		}()
+	}
+	return errCh
+}
+
+// serveDoT serves DNS-over-TLS (RFC 7858) connections accepted by the given
+// listener with the given handler, until the listener fails.
+func (res *Resolver) serveDoT(l net.Listener, h dns.Handler) error {
+	defer func() { _ = l.Close() }()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		go res.serveDoTConn(conn, h)
+	}
+}
+
+// serveDoTConn serves the length prefixed DNS messages received on the given
+// connection, in order, until it's closed or stays idle for too long.
+func (res *Resolver) serveDoTConn(conn net.Conn, h dns.Handler) {
+	defer func() { _ = conn.Close() }()
+
+	r := bufio.NewReader(conn)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(dotIdleTimeout)); err != nil {
+			return
+		}
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return
+		}
+
+		w := res.msgWriter(conn.LocalAddr(), conn.RemoteAddr(), func(b []byte) error {
+			out := make([]byte, 2, 2+len(b))
+			binary.BigEndian.PutUint16(out, uint16(len(b)))
+			_, err := conn.Write(append(out, b...))
+			return err
+		})
+		if !w.serve(h, buf) {
+			return
+		}
+	}
+}
+
+// dohHandler returns an http.Handler serving DNS-over-HTTPS (RFC 8484) GET
+// and POST requests with the given handler. Zone transfers, which may take
+// several messages while a response can only hold one, aren't implemented.
+func (res *Resolver) dohHandler(h dns.Handler) http.Handler {
+	h = noXFR(h)
+
+	// the address the requests are received on isn't known before Go 1.7,
+	// so it's the configured one
+	local, _ := net.ResolveTCPAddr("tcp",
+		net.JoinHostPort(res.config.DoHListener, strconv.Itoa(res.config.DoHPort)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(dohPath, func(rw http.ResponseWriter, req *http.Request) {
+		var (
+			buf []byte
+			err error
+		)
+		switch req.Method {
+		case http.MethodGet:
+			buf, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if req.Header.Get("Content-Type") != dohMediaType {
+				http.Error(rw, "unsupported media type", http.StatusUnsupportedMediaType)
+				return
+			}
+			buf, err = ioutil.ReadAll(io.LimitReader(req.Body, dns.MaxMsgSize))
+		default:
+			rw.Header().Set("Allow", "GET, POST")
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil || len(buf) == 0 {
+			http.Error(rw, "invalid DNS message", http.StatusBadRequest)
+			return
+		}
+
+		remote, _ := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+
+		var written bool
+		w := res.msgWriter(local, remote, func(b []byte) error {
+			m := new(dns.Msg)
+			if err := m.Unpack(b); err == nil {
+				if ttl, ok := minTTL(m); ok {
+					rw.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(ttl)))
+				}
+			}
+			rw.Header().Set("Content-Type", dohMediaType)
+			rw.Header().Set("Content-Length", strconv.Itoa(len(b)))
+			written = true
+			_, err := rw.Write(b)
+			return err
+		})
+		if w.serve(h, buf); !written {
+			http.Error(rw, "invalid DNS message", http.StatusBadRequest)
+		}
+	})
+	return mux
+}
+
+// noXFR returns a handler answering AXFR and IXFR requests with NOTIMP, and
+// handing the others over to the given handler.
+func noXFR(h dns.Handler) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		switch r.Question[0].Qtype {
+		case dns.TypeAXFR, dns.TypeIXFR:
+			reply(w, new(dns.Msg).SetRcode(r, dns.RcodeNotImplemented))
+		default:
+			h.ServeDNS(w, r)
+		}
+	})
+}
+
+// minTTL returns the smallest TTL of the records of the given message, not
+// counting OPT records, and whether it has any.
+// See: https://tools.ietf.org/html/rfc8484#section-5.1
+func minTTL(m *dns.Msg) (ttl uint32, ok bool) {
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if !ok || rr.Header().Ttl < ttl {
+				ttl, ok = rr.Header().Ttl, true
+			}
+		}
+	}
+	return ttl, ok
+}
+
+// msgWriter returns a msgWriter with the given addresses, writing packed
+// messages with the given func.
+func (res *Resolver) msgWriter(local, remote net.Addr, write func([]byte) error) *msgWriter {
+	return &msgWriter{
+		local:      local,
+		remote:     remote,
+		write:      write,
+		tsigSecret: tsigSecrets(res.config.TSIGKeys),
+	}
+}
+
+// A msgWriter is a dns.ResponseWriter for requests which aren't received by
+// a dns.Server, i.e. over TLS or HTTPS. It verifies and generates the TSIG
+// records of the messages it handles, as a dns.Server does.
+type msgWriter struct {
+	local, remote net.Addr
+	write         func([]byte) error
+	closed        bool
+
+	tsigSecret     map[string]string
+	tsigStatus     error
+	tsigTimersOnly bool
+	tsigRequestMAC string
+}
+
+// serve unpacks the given request and hands it over to the given handler.
+// Malformed requests get a FORMERR reply. It returns false if the request
+// couldn't be unpacked at all or if the handler closed the writer.
+func (w *msgWriter) serve(h dns.Handler, buf []byte) bool {
+	r := new(dns.Msg)
+	if err := r.Unpack(buf); err != nil || len(r.Question) == 0 || r.Response {
+		_ = w.WriteMsg(new(dns.Msg).SetRcodeFormatError(r))
+		return false
+	}
+
+	if t := r.IsTsig(); t != nil && w.tsigSecret != nil {
+		secret, ok := w.tsigSecret[t.Hdr.Name]
+		if w.tsigStatus = dns.ErrSecret; ok {
+			w.tsigStatus = dns.TsigVerify(buf, secret, "", false)
+		}
+		w.tsigRequestMAC = t.MAC
+	}
+
+	h.ServeDNS(w, r)
+	return !w.closed
+}
+
+// LocalAddr implements the dns.ResponseWriter interface.
+func (w *msgWriter) LocalAddr() net.Addr { return w.local }
+
+// RemoteAddr implements the dns.ResponseWriter interface.
+func (w *msgWriter) RemoteAddr() net.Addr { return w.remote }
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (w *msgWriter) WriteMsg(m *dns.Msg) error {
+	var (
+		b   []byte
+		err error
+	)
+	if t := m.IsTsig(); t != nil && w.tsigSecret != nil {
+		b, w.tsigRequestMAC, err = dns.TsigGenerate(m, w.tsigSecret[t.Hdr.Name], w.tsigRequestMAC, w.tsigTimersOnly)
+	} else {
+		b, err = m.Pack()
+	}
+	if err != nil {
+		return err
+	}
+	return w.write(b)
+}
+
+// Write implements the dns.ResponseWriter interface.
+func (w *msgWriter) Write(b []byte) (int, error) {
+	if err := w.write(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close implements the dns.ResponseWriter interface.
+func (w *msgWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+// TsigStatus implements the dns.ResponseWriter interface.
+func (w *msgWriter) TsigStatus() error { return w.tsigStatus }
+
+// TsigTimersOnly implements the dns.ResponseWriter interface.
+func (w *msgWriter) TsigTimersOnly(b bool) { w.tsigTimersOnly = b }
+
+// Hijack implements the dns.ResponseWriter interface.
+func (w *msgWriter) Hijack() {}
@@ -0,0 +1,316 @@
+package builtin
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// writeCertificate writes a new self-signed certificate for 127.0.0.1 with
+// the given common name, and its key, to the given files.
+func writeCertificate(t *testing.T, certFile, keyFile, cn string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tlsDNS returns a Resolver of the fake records with a new certificate, and
+// a func removing its files.
+func tlsDNS(t *testing.T) (*Resolver, *certificate, func()) {
+	dir, err := ioutil.TempDir("", "mesos-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, "mesos-dns")
+	cert, err := newCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	qualifySOA(res)
+
+	return res, cert, func() { _ = os.RemoveAll(dir) }
+}
+
+// leaderAnswer fails the test unless the given reply answers leader.mesos.
+// with the address of the leading master.
+func leaderAnswer(t *testing.T, m *dns.Msg) {
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+		t.Fatalf("got %v, want the A record of leader.mesos.", m)
+	}
+	if a, ok := m.Answer[0].(*dns.A); !ok || !a.A.Equal(net.ParseIP("1.2.3.4")) {
+		t.Fatalf("got %v, want 1.2.3.4", m.Answer[0])
+	}
+}
+
+func TestDoT(t *testing.T) {
+	res, cert, cleanup := tlsDNS(t)
+	defer cleanup()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig(cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = res.serveDoT(l, dns.HandlerFunc(res.HandleMesos)) }()
+	defer func() { _ = l.Close() }()
+
+	leaf, _ := cert.GetCertificate(nil)
+	roots := x509.NewCertPool()
+	c, _ := x509.ParseCertificate(leaf.Certificate[0])
+	roots.AddCert(c)
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// several queries are served over the same connection
+	for i := 0; i < 3; i++ {
+		q := new(dns.Msg).SetQuestion("leader.mesos.", dns.TypeA)
+		b, err := q.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = binary.Write(conn, binary.BigEndian, uint16(len(b))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Write(b); err != nil {
+			t.Fatal(err)
+		}
+
+		var n uint16
+		if err = binary.Read(conn, binary.BigEndian, &n); err != nil {
+			t.Fatal(err)
+		}
+		b = make([]byte, n)
+		if _, err = io.ReadFull(conn, b); err != nil {
+			t.Fatal(err)
+		}
+		m := new(dns.Msg)
+		if err = m.Unpack(b); err != nil {
+			t.Fatal(err)
+		}
+		if m.Id != q.Id {
+			t.Fatalf("got ID %d, want %d", m.Id, q.Id)
+		}
+		leaderAnswer(t, m)
+	}
+}
+
+func TestDoH(t *testing.T) {
+	res, _, cleanup := tlsDNS(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(res.dohHandler(dns.HandlerFunc(res.HandleMesos)))
+	defer srv.Close()
+
+	q := new(dns.Msg).SetQuestion("leader.mesos.", dns.TypeA)
+	q.Id = 0 // as recommended for caching
+	b, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() (*http.Response, error) {
+		return http.Get(srv.URL + dohPath + "?dns=" + base64.RawURLEncoding.EncodeToString(b))
+	}
+	post := func() (*http.Response, error) {
+		return http.Post(srv.URL+dohPath, dohMediaType, bytes.NewReader(b))
+	}
+	for _, do := range []func() (*http.Response, error){get, post} {
+		resp, err := do()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d: %s", resp.StatusCode, body)
+		}
+		if got := resp.Header.Get("Content-Type"); got != dohMediaType {
+			t.Errorf("got Content-Type %q, want %q", got, dohMediaType)
+		}
+		if got, want := resp.Header.Get("Cache-Control"), "max-age=60"; got != want {
+			t.Errorf("got Cache-Control %q, want %q", got, want)
+		}
+		m := new(dns.Msg)
+		if err = m.Unpack(body); err != nil {
+			t.Fatal(err)
+		}
+		leaderAnswer(t, m)
+	}
+
+	// zone transfers don't fit in a single response
+	for _, xfr := range []*dns.Msg{
+		new(dns.Msg).SetAxfr("mesos."),
+		new(dns.Msg).SetIxfr("mesos.", 0, "ns1.mesos.", "root.ns1.mesos."),
+	} {
+		out, err := xfr.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(srv.URL+dohPath, dohMediaType, bytes.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := new(dns.Msg)
+		if err = m.Unpack(body); err != nil {
+			t.Fatalf("%s: %v", dns.TypeToString[xfr.Question[0].Qtype], err)
+		}
+		if m.Rcode != dns.RcodeNotImplemented || len(m.Answer) != 0 {
+			t.Errorf("%s: got %v, want NOTIMP", dns.TypeToString[xfr.Question[0].Qtype], m)
+		}
+	}
+
+	for _, tt := range []struct {
+		method, query, mediaType string
+		body                     []byte
+		status                   int
+	}{
+		{"GET", "?dns=!", "", nil, http.StatusBadRequest},
+		{"GET", "", "", nil, http.StatusBadRequest},
+		{"POST", "", "text/plain", b, http.StatusUnsupportedMediaType},
+		// malformed messages are answered with FORMERR
+		{"POST", "", dohMediaType, []byte{0, 1, 2}, http.StatusOK},
+		{"PUT", "", dohMediaType, b, http.StatusMethodNotAllowed},
+	} {
+		req, err := http.NewRequest(tt.method, srv.URL+dohPath+tt.query, bytes.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", tt.mediaType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %q: got status %d, want %d", tt.method, tt.query, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	_, cert, cleanup := tlsDNS(t)
+	defer cleanup()
+
+	commonName := func() string {
+		c, err := cert.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+	touch := func(files ...string) {
+		later := time.Now().Add(time.Minute)
+		for _, f := range files {
+			if err := os.Chtimes(f, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if got, want := commonName(), "mesos-dns"; got != want {
+		t.Fatalf("got certificate %q, want %q", got, want)
+	}
+
+	// renewed certificates are served once replaced
+	writeCertificate(t, cert.certFile, cert.keyFile, "renewed")
+	touch(cert.certFile, cert.keyFile)
+	if got, want := commonName(), "renewed"; got != want {
+		t.Fatalf("got certificate %q, want %q", got, want)
+	}
+
+	// the previous one is kept while the files don't match
+	if err := ioutil.WriteFile(cert.keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	touch(cert.keyFile)
+	if got, want := commonName(), "renewed"; got != want {
+		t.Fatalf("got certificate %q, want %q", got, want)
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	_, cert, cleanup := tlsDNS(t)
+	defer cleanup()
+
+	for i, tt := range []struct {
+		dot, doh          int
+		certFile, keyFile string
+		valid             bool
+	}{
+		{0, 0, "", "", true},
+		{853, 0, cert.certFile, cert.keyFile, true},
+		{0, 443, cert.certFile, cert.keyFile, true},
+		{853, 0, "", "", false},
+		{0, 443, cert.certFile, "", false},
+		{853, 443, cert.certFile, cert.certFile, false},
+		{853, 0, cert.certFile, cert.keyFile + ".missing", false},
+	} {
+		c := &Config{DoTPort: tt.dot, DoHPort: tt.doh, TLSCertFile: tt.certFile, TLSKeyFile: tt.keyFile}
+		if err := validateTLS(c); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got %v, want valid: %t", i, err, tt.valid)
+		}
+	}
+}
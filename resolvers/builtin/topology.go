@@ -0,0 +1,195 @@
+package builtin
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/miekg/dns"
+)
+
+// Orders of the answers of A, AAAA and SRV replies.
+const (
+	// orderRandom shuffles answers.
+	orderRandom = "random"
+	// orderTopology shuffles answers, then moves those of tasks in the rack
+	// of the querying agent first, followed by those in its dc.
+	orderTopology = "topology"
+	// orderLocal orders answers like orderTopology, keeping only the ones
+	// nearest to the querying agent: in its rack if any, else in its dc if
+	// any, else all of them.
+	orderLocal = "local"
+)
+
+// Distances between locations.
+const (
+	sameRack = iota
+	sameDC
+	elsewhere
+)
+
+// A topology locates agents, and the tasks running on them, by their IP
+// addresses, with the dc and rack attributes of the agents.
+type topology struct {
+	rg     *records.RecordGenerator    // located records
+	agents map[string]state.Attributes // by agent IP
+	hosts  map[string]state.Attributes // by agent and task IP
+}
+
+// newTopology returns the topology of the agents and tasks of the given
+// RecordGenerator.
+func newTopology(rg *records.RecordGenerator) *topology {
+	t := &topology{
+		rg:     rg,
+		agents: make(map[string]state.Attributes, len(rg.SlaveIPs)),
+		hosts:  make(map[string]state.Attributes, len(rg.SlaveIPs)),
+	}
+	slaves := make(map[string]state.Attributes, len(rg.State.Slaves))
+	for _, slave := range rg.State.Slaves {
+		slaves[slave.ID] = slave.Attrs
+		if ip, ok := rg.SlaveIPs[slave.ID]; ok {
+			t.agents[ip], t.hosts[ip] = slave.Attrs, slave.Attrs
+		}
+	}
+
+	var ipSources []string
+	if rg.Config != nil {
+		ipSources = rg.Config.IPSources
+	}
+	for _, f := range rg.State.Frameworks {
+		for _, task := range f.Tasks {
+			attrs, ok := slaves[task.SlaveID]
+			if !ok {
+				continue
+			}
+			for _, ip := range task.IPs(ipSources...) {
+				if _, found := t.hosts[ip.String()]; !found {
+					t.hosts[ip.String()] = attrs
+				}
+			}
+		}
+	}
+	return t
+}
+
+// distance returns the distance from the given location to the nearest host
+// the given A, AAAA or SRV record points to.
+func (t *topology) distance(from state.Attributes, rr dns.RR) int {
+	var ips []string
+	switch rr := rr.(type) {
+	case *dns.A:
+		ips = append(ips, rr.A.String())
+	case *dns.AAAA:
+		ips = append(ips, rr.AAAA.String())
+	case *dns.SRV:
+		target := strings.ToLower(rr.Target)
+		for ip := range t.rg.As[target] {
+			ips = append(ips, ip)
+		}
+		for ip := range t.rg.AAAAs[target] {
+			ips = append(ips, ip)
+		}
+	}
+
+	d := elsewhere
+	for _, ip := range ips {
+		if to, ok := t.hosts[ip]; ok && distance(from, to) < d {
+			d = distance(from, to)
+		}
+	}
+	return d
+}
+
+// distance returns the distance between the given locations. Racks are
+// only the same within the same dc.
+func distance(from, to state.Attributes) int {
+	switch {
+	case from.Rack != "" && from.Rack == to.Rack && from.DC == to.DC:
+		return sameRack
+	case from.DC != "" && from.DC == to.DC:
+		return sameDC
+	default:
+		return elsewhere
+	}
+}
+
+// topology returns the topology of the given RecordGenerator, building it
+// the first time it's asked for. Topologies are kept until the next Reload,
+// so that those of replaced records can be collected.
+func (res *Resolver) topology(rg *records.RecordGenerator) *topology {
+	res.topoLock.Lock()
+	defer res.topoLock.Unlock()
+	if t, ok := res.topos[rg]; ok {
+		return t
+	}
+	if res.topos == nil {
+		res.topos = make(map[*records.RecordGenerator]*topology, len(res.views)+1)
+	}
+	res.topos[rg] = newTopology(rg)
+	return res.topos[rg]
+}
+
+// orderAnswers orders the answers of the given reply to a request from the
+// given address as configured by AnswerOrder. Answers are shuffled, and
+// those of A, AAAA and SRV replies to known agents are then ordered by
+// their distance to the agent, as described by orderTopology and
+// orderLocal.
+func (res *Resolver) orderAnswers(rg *records.RecordGenerator, client net.IP, m *dns.Msg) {
+	shuffleAnswers(res.rng, m.Answer)
+	if res.config.AnswerOrder != orderTopology && res.config.AnswerOrder != orderLocal {
+		return
+	}
+	switch m.Question[0].Qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeSRV:
+	default:
+		return
+	}
+	topo := res.topology(rg)
+	from, ok := topo.agents[client.String()]
+	if !ok {
+		return
+	}
+
+	dists := make(map[dns.RR]int, len(m.Answer))
+	for _, rr := range m.Answer {
+		dists[rr] = topo.distance(from, rr)
+	}
+	sort.Stable(byDistance{m.Answer, dists})
+	if res.config.AnswerOrder != orderLocal {
+		return
+	}
+
+	nearest := dists[m.Answer[0]]
+	m.Answer = m.Answer[:sort.Search(len(m.Answer), func(i int) bool {
+		return dists[m.Answer[i]] > nearest
+	})]
+	// drop the addresses of the targets of dropped SRV answers
+	targets := make(map[string]bool, len(m.Answer))
+	for _, rr := range m.Answer {
+		if srv, ok := rr.(*dns.SRV); ok {
+			targets[strings.ToLower(srv.Target)] = true
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+	extra := m.Extra[:0]
+	for _, rr := range m.Extra {
+		if targets[strings.ToLower(rr.Header().Name)] {
+			extra = append(extra, rr)
+		}
+	}
+	m.Extra = extra
+}
+
+// byDistance implements sort.Interface for answers, by increasing distance.
+type byDistance struct {
+	rrs   []dns.RR
+	dists map[dns.RR]int
+}
+
+func (s byDistance) Len() int           { return len(s.rrs) }
+func (s byDistance) Less(i, j int) bool { return s.dists[s.rrs[i]] < s.dists[s.rrs[j]] }
+func (s byDistance) Swap(i, j int)      { s.rrs[i], s.rrs[j] = s.rrs[j], s.rrs[i] }
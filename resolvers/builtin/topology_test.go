@@ -0,0 +1,146 @@
+package builtin
+
+import (
+	"math/rand"
+	"net"
+	"reflect"
+	"testing"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/miekg/dns"
+)
+
+func TestAnswerOrder(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// agents of fake.json, by IP
+	locations := map[string]state.Attributes{
+		"1.2.3.10": {DC: "east", Rack: "r1"},
+		"1.2.3.11": {DC: "east", Rack: "r2"},
+		"1.2.3.12": {DC: "west", Rack: "r1"},
+	}
+	rg := res.records()
+	for i, slave := range rg.State.Slaves {
+		rg.State.Slaves[i].Attrs = locations[rg.SlaveIPs[slave.ID]]
+	}
+
+	for i, tt := range []struct {
+		order  string
+		client string
+		want   []string
+	}{
+		{orderRandom, "1.2.3.10", nil},
+		{orderTopology, "1.2.3.10", []string{"1.2.3.10", "1.2.3.11", "1.2.3.12"}},
+		{orderTopology, "1.2.3.12", []string{"1.2.3.12", "", ""}},
+		{orderTopology, "10.0.0.1", nil}, // not an agent
+		{orderLocal, "1.2.3.10", []string{"1.2.3.10"}},
+		{orderLocal, "1.2.3.11", []string{"1.2.3.11"}},
+		{orderLocal, "10.0.0.1", nil},
+	} {
+		res.config.AnswerOrder = tt.order
+		for n := 0; n < 10; n++ { // since answers are shuffled first
+			rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP(tt.client)}}
+			res.HandleMesos(&rw, Message(Question("slave.mesos.", dns.TypeA)))
+
+			var got []string
+			for j, rr := range rw.Msg.Answer {
+				ip := rr.(*dns.A).A.String()
+				if j < len(tt.want) && tt.want[j] == "" {
+					ip = "" // of any other agent
+				}
+				got = append(got, ip)
+			}
+			if tt.want == nil {
+				if len(got) != len(locations) {
+					t.Errorf("test #%d: got answers %v, want all agents", i, got)
+				}
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("test #%d: got answers %v, want %v", i, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestTopologyReload(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := res.records()
+	if res.topology(old) != res.topology(old) {
+		t.Error("the topology wasn't kept")
+	}
+
+	res.Reload(records.NewRecordGenerator(old.Config))
+	if _, ok := res.topos[old]; ok || len(res.topos) != 0 {
+		t.Errorf("got topologies %v of replaced records", res.topos)
+	}
+}
+
+func TestAnswerOrderSRV(t *testing.T) {
+	rg := &records.RecordGenerator{
+		State: state.State{Slaves: []state.Slave{
+			{ID: "a", Attrs: state.Attributes{DC: "east", Rack: "r1"}},
+			{ID: "b", Attrs: state.Attributes{DC: "east", Rack: "r2"}},
+			{ID: "c", Attrs: state.Attributes{DC: "west", Rack: "r1"}},
+		}},
+		SlaveIPs: map[string]string{"a": "10.0.0.1", "b": "10.0.0.2", "c": "10.0.0.3"},
+		As: map[string]map[string]struct{}{
+			"near.slave.mesos.": {"10.0.0.1": {}},
+			"dc.slave.mesos.":   {"10.0.0.2": {}},
+			"far.slave.mesos.":  {"10.0.0.3": {}},
+		},
+	}
+	srv := func(target string) dns.RR {
+		return SRV(RRHeader("_app._tcp.mesos.", dns.TypeSRV, 60), target, 80, 0, 0)
+	}
+	a := func(name, ip string) dns.RR {
+		return A(RRHeader(name, dns.TypeA, 60), net.ParseIP(ip))
+	}
+	targets := func(rrs []dns.RR) (names []string) {
+		for _, rr := range rrs {
+			if srv, ok := rr.(*dns.SRV); ok {
+				names = append(names, srv.Target)
+			} else {
+				names = append(names, rr.Header().Name)
+			}
+		}
+		return names
+	}
+
+	res := &Resolver{config: NewConfig(), rng: rand.New(rand.NewSource(0))}
+	for i, tt := range []struct {
+		order  string
+		answer []string
+		extra  []string
+	}{
+		{
+			orderTopology,
+			[]string{"near.slave.mesos.", "dc.slave.mesos.", "far.slave.mesos."},
+			[]string{"far.slave.mesos.", "dc.slave.mesos.", "near.slave.mesos."},
+		},
+		{
+			orderLocal,
+			[]string{"near.slave.mesos."},
+			[]string{"near.slave.mesos."},
+		},
+	} {
+		res.config.AnswerOrder = tt.order
+		m := Message(
+			Question("_app._tcp.mesos.", dns.TypeSRV),
+			Answers(srv("far.slave.mesos."), srv("dc.slave.mesos."), srv("near.slave.mesos.")),
+			Extras(a("far.slave.mesos.", "10.0.0.3"), a("dc.slave.mesos.", "10.0.0.2"), a("near.slave.mesos.", "10.0.0.1")),
+		)
+		res.orderAnswers(rg, net.ParseIP("10.0.0.1"), m)
+		if got := targets(m.Answer); !reflect.DeepEqual(got, tt.answer) {
+			t.Errorf("test #%d: got answers %v, want %v", i, got, tt.answer)
+		}
+		if got := targets(m.Extra); !reflect.DeepEqual(got, tt.extra) {
+			t.Errorf("test #%d: got extra records %v, want %v", i, got, tt.extra)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package builtin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/exchanger"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// tsigAlgorithms maps the names of the supported TSIG algorithms, with or
+// without their trailing dot, to their canonical names.
+var tsigAlgorithms = map[string]string{
+	"hmac-md5":                 dns.HmacMD5,
+	"hmac-md5.sig-alg.reg.int": dns.HmacMD5,
+	"hmac-sha1":                dns.HmacSHA1,
+	"hmac-sha256":              dns.HmacSHA256,
+	"hmac-sha512":              dns.HmacSHA512,
+}
+
+// tsigAlgorithm returns the canonical name of the given TSIG algorithm and
+// whether it's supported.
+func tsigAlgorithm(alg string) (string, bool) {
+	canonical, ok := tsigAlgorithms[strings.TrimSuffix(strings.ToLower(alg), ".")]
+	return canonical, ok
+}
+
+// tsigName returns the given TSIG key name as it appears in messages.
+func tsigName(name string) string {
+	return dns.Fqdn(strings.ToLower(name))
+}
+
+// tsigSecrets returns the secrets of the given keys by their names, as
+// expected by dns.Server and dns.Client, or nil if there are none.
+func tsigSecrets(keys []TSIGKey) map[string]string {
+	if len(keys) == 0 {
+		return nil
+	}
+	secrets := make(map[string]string, len(keys))
+	for _, k := range keys {
+		secrets[tsigName(k.Name)] = k.Secret
+	}
+	return secrets
+}
+
+// tsigKey returns the configured TSIG key of the given name, if any.
+func (res *Resolver) tsigKey(name string) (key TSIGKey, ok bool) {
+	if name == "" {
+		return key, false
+	}
+	for _, k := range res.config.TSIGKeys {
+		if tsigName(k.Name) == tsigName(name) {
+			key = k
+			key.Name = tsigName(k.Name)
+			key.Algorithm, ok = tsigAlgorithm(k.Algorithm)
+			return key, ok
+		}
+	}
+	return key, false
+}
+
+// signer returns the Decorator signing messages with the TSIG key of the
+// given name, or none if the name is empty. It fails if there's no such key,
+// or if its algorithm isn't supported.
+func (res *Resolver) signer(name string) ([]exchanger.Decorator, error) {
+	if name == "" {
+		return nil, nil
+	}
+	key, ok := res.tsigKey(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown TSIG key %q or algorithm", name)
+	}
+	return []exchanger.Decorator{exchanger.Signing(key.Name, key.Algorithm)}, nil
+}
+
+// verify returns nil if the given request is signed with one of the
+// configured TSIG keys, using its algorithm, and the signature was verified
+// by the server.
+func (res *Resolver) verify(w dns.ResponseWriter, r *dns.Msg) error {
+	t := r.IsTsig()
+	if t == nil {
+		return dns.ErrNoSig
+	}
+	if err := w.TsigStatus(); err != nil {
+		return err
+	}
+	key, ok := res.tsigKey(t.Hdr.Name)
+	switch {
+	case !ok:
+		return dns.ErrKey
+	case key.Algorithm != strings.ToLower(dns.Fqdn(t.Algorithm)):
+		return dns.ErrKeyAlg
+	}
+	return nil
+}
+
+// tsig returns a handler which answers requests signed with one of the
+// configured TSIG keys with replies signed with the same key, as mandated by
+// RFC 2845, handing them over without their TSIG record. Requests failing
+// verification are answered with NOTAUTH. Unsigned requests, and all
+// requests if no keys are configured, are handed over as they are.
+func (res *Resolver) tsig(h func(dns.ResponseWriter, *dns.Msg)) func(dns.ResponseWriter, *dns.Msg) {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		t := r.IsTsig()
+		if t == nil || len(res.config.TSIGKeys) == 0 {
+			h(w, r)
+			return
+		}
+		if err := res.verify(w, r); err != nil {
+			logging.Error.Printf("failed to verify TSIG %q of request from %v: %v",
+				t.Hdr.Name, w.RemoteAddr(), err)
+			reply(w, new(dns.Msg).SetRcode(r, dns.RcodeNotAuth))
+			return
+		}
+		r = r.Copy()
+		r.Extra = r.Extra[:len(r.Extra)-1]
+		h(&signingWriter{ResponseWriter: w, name: t.Hdr.Name, algorithm: t.Algorithm}, r)
+	}
+}
+
+// signed returns true if replies written to w are signed, i.e. if the
+// request was signed with one of the configured TSIG keys.
+func signed(w dns.ResponseWriter) bool {
+	_, ok := w.(*signingWriter)
+	return ok
+}
+
+// A signingWriter is a dns.ResponseWriter which signs the messages it writes
+// with a TSIG key, whose secret must be known to the underlying writer.
+type signingWriter struct {
+	dns.ResponseWriter
+	name, algorithm string
+}
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (w *signingWriter) WriteMsg(m *dns.Msg) error {
+	if m.IsTsig() == nil {
+		m.SetTsig(w.name, w.algorithm, exchanger.TSIGFudge, time.Now().Unix())
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
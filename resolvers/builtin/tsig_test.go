@@ -0,0 +1,128 @@
+package builtin
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/exchanger"
+	"github.com/miekg/dns"
+)
+
+var testKey = TSIGKey{Name: "transfer.mesos", Algorithm: "hmac-sha256", Secret: "so6ZGir4GPAqINNh9U5c3A=="}
+
+func TestTSIG(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.config.TSIGKeys = []TSIGKey{testKey}
+	if res.xfrNets, err = parseNets([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(m *dns.Msg, name, alg string) *dns.Msg {
+		return m.SetTsig(name, alg, exchanger.TSIGFudge, time.Now().Unix())
+	}
+	axfr := func() *dns.Msg { return new(dns.Msg).SetAxfr("mesos.") }
+	query := func() *dns.Msg { return new(dns.Msg).SetQuestion("leader.mesos.", dns.TypeA) }
+
+	for i, tt := range []struct {
+		q      *dns.Msg
+		status error
+		rcode  int
+		signed bool
+	}{
+		{axfr(), nil, dns.RcodeRefused, false},
+		{sign(axfr(), "transfer.mesos.", dns.HmacSHA256), nil, dns.RcodeSuccess, true},
+		{sign(axfr(), "transfer.mesos.", dns.HmacSHA256), dns.ErrSig, dns.RcodeNotAuth, false},
+		{sign(axfr(), "transfer.mesos.", dns.HmacMD5), nil, dns.RcodeNotAuth, false},
+		{sign(axfr(), "other.mesos.", dns.HmacSHA256), nil, dns.RcodeNotAuth, false},
+		{query(), nil, dns.RcodeSuccess, false},
+		{sign(query(), "transfer.mesos.", dns.HmacSHA256), nil, dns.RcodeSuccess, true},
+	} {
+		rw := ResponseRecorder{
+			Local:  net.IPAddr{IP: net.ParseIP("127.0.0.1")},
+			Remote: net.IPAddr{IP: net.ParseIP("127.0.0.1")},
+			Tsig:   tt.status,
+		}
+		res.tsig(res.HandleMesos)(&rw, tt.q)
+		if got := rw.Msg.Rcode; got != tt.rcode {
+			t.Errorf("test #%d: got rcode %d, want %d", i, got, tt.rcode)
+		}
+		for _, m := range rw.Msgs {
+			if ts := m.IsTsig(); (ts != nil) != tt.signed {
+				t.Errorf("test #%d: got TSIG %v, want signed %t", i, ts, tt.signed)
+			}
+		}
+	}
+}
+
+func TestTSIGTransfer(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.config.TSIGKeys = []TSIGKey{testKey}
+	if res.xfrNets, err = parseNets([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	qualifySOA(res)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{
+		Listener:          l,
+		TsigSecret:        tsigSecrets(res.config.TSIGKeys),
+		Handler:           dns.HandlerFunc(res.tsig(res.HandleMesos)),
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	defer func() { _ = srv.Shutdown() }()
+	<-started
+
+	tr := &dns.Transfer{TsigSecret: tsigSecrets(res.config.TSIGKeys)}
+	m := new(dns.Msg).SetAxfr("mesos.")
+	m.SetTsig("transfer.mesos.", dns.HmacSHA256, exchanger.TSIGFudge, time.Now().Unix())
+	envs, err := tr.In(m, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n, rrs int
+	for env := range envs {
+		if env.Error != nil {
+			t.Fatalf("envelope #%d: %v", n, env.Error)
+		}
+		n, rrs = n+1, rrs+len(env.RR)
+	}
+	if n < 2 || rrs <= xfrChunk {
+		t.Errorf("got %d envelopes of %d records, want a multi-message transfer", n, rrs)
+	}
+}
+
+func TestTSIGKey(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.config.TSIGKeys = []TSIGKey{testKey}
+
+	for i, tt := range []struct {
+		name string
+		ok   bool
+		want TSIGKey
+	}{
+		{"", false, TSIGKey{}},
+		{"other", false, TSIGKey{}},
+		{"Transfer.Mesos.", true, TSIGKey{"transfer.mesos.", dns.HmacSHA256, testKey.Secret}},
+	} {
+		if got, ok := res.tsigKey(tt.name); ok != tt.ok || got != tt.want {
+			t.Errorf("test #%d: got %+v (%t), want %+v (%t)", i, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -1,8 +1,15 @@
 package builtin
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/mesosphere/mesos-dns/exchanger"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
 )
 
 func validateEnabledServices(c *Config) error {
@@ -12,24 +19,255 @@ func validateEnabledServices(c *Config) error {
 	return nil
 }
 
-// validateExternalDNS checks that each remote server's IP in the list is a properly
-// formatted IP address. Duplicate IPs in the list are not allowed.
-// returns nil if the remote server list is empty, or else all IPs in the list are valid.
+// validateExternalDNS checks that each remote server in the list is an IP
+// address with an optional port (e.g. "1.2.3.4", "1.2.3.4:5353" or
+// "[2001:db8::1]:53"), a DNS-over-TLS server URL with an optional port (e.g.
+// "tls://1.1.1.1:853") or a DNS-over-HTTPS server URL (e.g.
+// "https://1.1.1.1/dns-query"). Duplicate servers in the list are not allowed.
+// returns nil if the remote server list is empty, or else all servers in the
+// list are valid.
 func validateExternalDNS(rs []string) error {
 	if len(rs) == 0 {
 		return nil
 	}
-	ips := make(map[string]struct{}, len(rs))
+	addrs := make(map[string]struct{}, len(rs))
 	for _, r := range rs {
-		ip := net.ParseIP(r)
-		if ip == nil {
-			return fmt.Errorf("illegal IP specified for remote server %q", r)
+		proto, addr := exchanger.Upstream(r, "udp")
+		switch proto {
+		case "https":
+			if u, err := url.Parse(addr); err != nil || u.Host == "" {
+				return fmt.Errorf("illegal URL specified for remote server %q", r)
+			}
+		default:
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil || host == "" {
+				return fmt.Errorf("illegal address specified for remote server %q", r)
+			}
+			if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+				return fmt.Errorf("illegal port specified for remote server %q", r)
+			}
+			if proto == "tls" {
+				break
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("illegal IP specified for remote server %q", r)
+			}
+			addr = net.JoinHostPort(ip.String(), port)
+		}
+		key := proto + "://" + addr
+		if _, found := addrs[key]; found {
+			return fmt.Errorf("duplicate remote server specified: %v", r)
+		}
+		addrs[key] = struct{}{}
+	}
+	return nil
+}
+
+// validateUpstreamPolicy checks that the given upstream selection policy is
+// supported.
+func validateUpstreamPolicy(policy string) error {
+	switch policy {
+	case exchanger.Sequential, exchanger.RoundRobin, exchanger.Fastest, exchanger.Race:
+		return nil
+	default:
+		return fmt.Errorf("unknown upstream policy %q", policy)
+	}
+}
+
+// validateAnswerOrder checks that the given order of answers is supported.
+func validateAnswerOrder(order string) error {
+	switch order {
+	case orderRandom, orderTopology, orderLocal:
+		return nil
+	default:
+		return fmt.Errorf("unknown answer order %q", order)
+	}
+}
+
+// validateViews checks that each view has a unique name, valid CIDRs and
+// valid IP sources, if any.
+func validateViews(views []View) error {
+	seen := make(map[string]struct{}, len(views))
+	for _, v := range views {
+		if v.Name == "" {
+			return fmt.Errorf("missing view name")
+		}
+		if _, found := seen[v.Name]; found {
+			return fmt.Errorf("duplicate view specified: %q", v.Name)
+		}
+		seen[v.Name] = struct{}{}
+		if len(v.CIDRs) == 0 {
+			return fmt.Errorf("no CIDRs specified for view %q", v.Name)
+		}
+		if _, err := parseNets(v.CIDRs); err != nil {
+			return fmt.Errorf("illegal CIDR specified for view %q: %v", v.Name, err)
+		}
+		if v.IPSources == nil {
+			continue
+		}
+		if err := records.ValidateIPSources(v.IPSources); err != nil {
+			return fmt.Errorf("illegal IP sources specified for view %q: %v", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateACLs checks that each ACL restricts a known capability or HTTP
+// route, with valid networks.
+func validateACLs(acls map[string]ACL) error {
+	for capability, a := range acls {
+		switch capability {
+		case aclMesos, aclForward, aclXFR, aclHTTP:
+		default:
+			known := false
+			for _, path := range httpRoutes {
+				known = known || path == capability
+			}
+			if !known {
+				return fmt.Errorf("unknown capability %q", capability)
+			}
+		}
+		if _, err := parseNets(a.Allow); err != nil {
+			return fmt.Errorf("illegal allowed network of %q: %v", capability, err)
+		}
+		if _, err := parseNets(a.Deny); err != nil {
+			return fmt.Errorf("illegal denied network of %q: %v", capability, err)
+		}
+	}
+	return nil
+}
+
+// validateRRL checks that the Response Rate Limiting rates and settings of
+// the given Config are within their bounds.
+func validateRRL(c *Config) error {
+	if c.RRLResponsesPerSecond < 0 || c.RRLNXDomainsPerSecond < 0 || c.RRLErrorsPerSecond < 0 {
+		return fmt.Errorf("rates must not be negative")
+	}
+	if c.RRLWindow < 1 || c.RRLWindow > 3600 {
+		return fmt.Errorf("window must be within [1, 3600] seconds")
+	}
+	if c.RRLSlip < 0 || c.RRLSlip > 10 {
+		return fmt.Errorf("slip must be within [0, 10]")
+	}
+	if c.RRLIPv4PrefixLength < 0 || c.RRLIPv4PrefixLength > 8*net.IPv4len {
+		return fmt.Errorf("IPv4 prefix length must be within [0, %d]", 8*net.IPv4len)
+	}
+	if c.RRLIPv6PrefixLength < 0 || c.RRLIPv6PrefixLength > 8*net.IPv6len {
+		return fmt.Errorf("IPv6 prefix length must be within [0, %d]", 8*net.IPv6len)
+	}
+	return nil
+}
+
+// validateStubZones checks that each zone in the map is a valid, unique
+// domain name with a valid list of remote servers, as checked by
+// validateExternalDNS.
+func validateStubZones(zones map[string][]string) error {
+	seen := make(map[string]struct{}, len(zones))
+	for zone, rs := range zones {
+		name := stubZone(zone)
+		if _, ok := dns.IsDomainName(name); !ok || name == "." {
+			return fmt.Errorf("illegal stub zone specified: %q", zone)
+		}
+		if _, found := seen[name]; found {
+			return fmt.Errorf("duplicate stub zone specified: %q", zone)
+		}
+		seen[name] = struct{}{}
+		if len(rs) == 0 {
+			return fmt.Errorf("no remote servers specified for stub zone %q", zone)
+		}
+		if err := validateExternalDNS(rs); err != nil {
+			return fmt.Errorf("stub zone %q: %v", zone, err)
+		}
+	}
+	return nil
+}
+
+// validateReverseCIDRs checks that each network in the list is a properly
+// formatted CIDR. returns nil if the list is empty.
+func validateReverseCIDRs(cidrs []string) error {
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("illegal CIDR specified for reverse zone %q", cidr)
 		}
-		ipstr := ip.String()
-		if _, found := ips[ipstr]; found {
-			return fmt.Errorf("duplicate remote IP specified: %v", r)
+	}
+	return nil
+}
+
+// validateXFRAllowed checks that each secondary in the list is a properly
+// formatted IP address or CIDR. returns nil if the list is empty.
+func validateXFRAllowed(addrs []string) error {
+	if _, err := parseNets(addrs); err != nil {
+		return fmt.Errorf("illegal secondary specified for zone transfers: %v", err)
+	}
+	return nil
+}
+
+// validateNotifySecondaries checks that each secondary in the list is a
+// properly formatted IP address, optionally with a port. returns nil if the
+// list is empty.
+func validateNotifySecondaries(addrs []string) error {
+	for _, addr := range addrs {
+		if _, err := notifyAddr(addr); err != nil {
+			return fmt.Errorf("illegal secondary specified for NOTIFY %q: %v", addr, err)
 		}
-		ips[ipstr] = struct{}{}
 	}
 	return nil
 }
+
+// validateTSIGKeys checks that each key in the list has a unique name, a
+// supported algorithm and a base64 encoded secret, and that the given key
+// names refer to one of them, unless empty.
+func validateTSIGKeys(keys []TSIGKey, names ...string) error {
+	seen := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		name := tsigName(k.Name)
+		if name == "." {
+			return fmt.Errorf("missing TSIG key name")
+		}
+		if _, found := seen[name]; found {
+			return fmt.Errorf("duplicate TSIG key specified: %q", k.Name)
+		}
+		seen[name] = struct{}{}
+		if _, ok := tsigAlgorithm(k.Algorithm); !ok {
+			return fmt.Errorf("unsupported algorithm %q of TSIG key %q", k.Algorithm, k.Name)
+		}
+		if _, err := base64.StdEncoding.DecodeString(k.Secret); err != nil || k.Secret == "" {
+			return fmt.Errorf("illegal secret of TSIG key %q", k.Name)
+		}
+	}
+	for _, name := range names {
+		if _, found := seen[tsigName(name)]; name != "" && !found {
+			return fmt.Errorf("unknown TSIG key specified: %q", name)
+		}
+	}
+	return nil
+}
+
+// validateEDNS checks that the advertised UDP size is a valid message size
+// and that the cookie secret, if any, is base64 encoded and long enough.
+func validateEDNS(size int, cookieSecret string) error {
+	if size < dns.MinMsgSize || size > dns.MaxMsgSize {
+		return fmt.Errorf("UDP size %d not within [%d, %d]", size, dns.MinMsgSize, dns.MaxMsgSize)
+	}
+	if cookieSecret == "" {
+		return nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(cookieSecret); err != nil || len(b) < 16 {
+		return fmt.Errorf("illegal cookie secret: must be at least 16 base64 encoded bytes")
+	}
+	return nil
+}
+
+// validateTLS checks that a certificate and key are specified, and can be
+// loaded, if either the DNS-over-TLS or the DNS-over-HTTPS server is enabled.
+func validateTLS(c *Config) error {
+	if c.DoTPort == 0 && c.DoHPort == 0 {
+		return nil
+	}
+	if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+		return fmt.Errorf("both TLSCertFile and TLSKeyFile must be set")
+	}
+	_, err := newCertificate(c.TLSCertFile, c.TLSKeyFile)
+	return err
+}
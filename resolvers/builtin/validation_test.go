@@ -19,11 +19,221 @@ func TestValidateExternalDNS(t *testing.T) {
 		{[]string{"2001:0db8:3c4d:0015:0000:0000:1a2f:1a2b"}, true},
 		{[]string{"2001:db8:3c4d:15::1a2f:1a2b"}, true},
 		{[]string{"2001:0db8:3c4d:0015:0000:0000:1a2f:1a2b", "2001:db8:3c4d:15::1a2f:1a2b"}, false},
+		{[]string{"1.2.3.4:5353"}, true},
+		{[]string{"1.2.3.4:53", "1.2.3.4"}, false},
+		{[]string{"1.2.3.4", "1.2.3.4:5353"}, true},
+		{[]string{"1.2.3.4:0"}, false},
+		{[]string{"1.2.3.4:dns"}, false},
+		{[]string{"1.2.3.4:65536"}, false},
+		{[]string{"[2001:db8::1]:5353"}, true},
+		{[]string{"[2001:db8::1]"}, true},
+		{[]string{"[2001:db8::1]:53", "2001:db8::1"}, false},
+		{[]string{"host:53"}, false},
+		{[]string{"tls://1.1.1.1"}, true},
+		{[]string{"tls://dns.example.com:853"}, true},
+		{[]string{"tls://1.1.1.1", "tls://1.1.1.1:853"}, false},
+		{[]string{"tls://1.1.1.1", "1.1.1.1"}, true},
+		{[]string{"tls://"}, false},
+		{[]string{"tls://1.1.1.1:x"}, false},
+		{[]string{"https://1.1.1.1/dns-query"}, true},
+		{[]string{"https://dns.example.com/dns-query", "https://dns.example.com/dns-query"}, false},
+		{[]string{"https:///dns-query"}, false},
+		{[]string{"ftp://1.1.1.1"}, false},
 	} {
 		validate(t, i+1, tc, validateExternalDNS)
 	}
 }
 
+func TestValidateReverseCIDRs(t *testing.T) {
+	for i, tc := range []validationTest{
+		{nil, true},
+		{[]string{}, true},
+		{[]string{""}, false},
+		{[]string{"10.0.0.1"}, false},
+		{[]string{"10.0.0.0/8"}, true},
+		{[]string{"10.0.0.0/33"}, false},
+		{[]string{"10.0.0.0/8", "fd01:b::/64"}, true},
+	} {
+		validate(t, i+1, tc, validateReverseCIDRs)
+	}
+}
+
+func TestValidateNotifySecondaries(t *testing.T) {
+	for i, tc := range []validationTest{
+		{nil, true},
+		{[]string{"10.0.0.1"}, true},
+		{[]string{"10.0.0.1:5353", "[fd01:b::1]:53", "fd01:b::2"}, true},
+		{[]string{"ns1.example.com"}, false},
+		{[]string{"10.0.0.1:dns"}, false},
+		{[]string{"10.0.0.1:65536"}, false},
+	} {
+		validate(t, i+1, tc, validateNotifySecondaries)
+	}
+}
+
+func TestValidateTSIGKeys(t *testing.T) {
+	key := func(name, alg, secret string) TSIGKey { return TSIGKey{name, alg, secret} }
+	for i, tt := range []struct {
+		keys  []TSIGKey
+		names []string
+		valid bool
+	}{
+		{nil, nil, true},
+		{nil, []string{""}, true},
+		{nil, []string{"a."}, false},
+		{[]TSIGKey{key("a.", "hmac-sha256", "c2VjcmV0")}, []string{"a", "A."}, true},
+		{[]TSIGKey{key("a", "HMAC-MD5.SIG-ALG.REG.INT.", "c2VjcmV0")}, nil, true},
+		{[]TSIGKey{key("", "hmac-sha256", "c2VjcmV0")}, nil, false},
+		{[]TSIGKey{key("a", "hmac-sha384", "c2VjcmV0")}, nil, false},
+		{[]TSIGKey{key("a", "hmac-sha1", "secret!")}, nil, false},
+		{[]TSIGKey{key("a", "hmac-sha1", "")}, nil, false},
+		{[]TSIGKey{key("a", "hmac-sha1", "c2VjcmV0"), key("A.", "hmac-sha1", "c2VjcmV0")}, nil, false},
+	} {
+		if err := validateTSIGKeys(tt.keys, tt.names...); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateUpstreamPolicy(t *testing.T) {
+	for i, tt := range []struct {
+		policy string
+		valid  bool
+	}{
+		{"sequential", true},
+		{"round-robin", true},
+		{"fastest", true},
+		{"race", true},
+		{"", false},
+		{"random", false},
+	} {
+		if err := validateUpstreamPolicy(tt.policy); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateEDNS(t *testing.T) {
+	for i, tt := range []struct {
+		size   int
+		secret string
+		valid  bool
+	}{
+		{1232, "", true},
+		{512, "MDEyMzQ1Njc4OWFiY2RlZg==", true},
+		{0, "", false},
+		{65536, "", false},
+		{1232, "c2VjcmV0", false}, // too short
+		{1232, "not base64", false},
+	} {
+		if err := validateEDNS(tt.size, tt.secret); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateAnswerOrder(t *testing.T) {
+	for i, tt := range []struct {
+		order string
+		valid bool
+	}{
+		{"random", true},
+		{"topology", true},
+		{"local", true},
+		{"", false},
+		{"nearest", false},
+	} {
+		if err := validateAnswerOrder(tt.order); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateViews(t *testing.T) {
+	for i, tt := range []struct {
+		views []View
+		valid bool
+	}{
+		{nil, true},
+		{[]View{{Name: "corp", CIDRs: []string{"10.0.0.0/8", "1.2.3.4"}}}, true},
+		{[]View{{Name: "corp", CIDRs: []string{"10.0.0.0/8"}, IPSources: []string{"host"}}}, true},
+		{[]View{{CIDRs: []string{"10.0.0.0/8"}}}, false},
+		{[]View{{Name: "corp"}}, false},
+		{[]View{{Name: "corp", CIDRs: []string{"10.0.0.0/33"}}}, false},
+		{[]View{{Name: "corp", CIDRs: []string{"10.0.0.0/8"}, IPSources: []string{}}}, false},
+		{[]View{{Name: "corp", CIDRs: []string{"10.0.0.0/8"}, IPSources: []string{"overlay"}}}, false},
+		{[]View{{Name: "corp", CIDRs: []string{"10.0.0.0/8"}}, {Name: "corp", CIDRs: []string{"1.2.3.4"}}}, false},
+	} {
+		if err := validateViews(tt.views); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateACLs(t *testing.T) {
+	for i, tt := range []struct {
+		acls  map[string]ACL
+		valid bool
+	}{
+		{nil, true},
+		{map[string]ACL{"mesos": {Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.1.0.0/16"}}}, true},
+		{map[string]ACL{"forward": {}, "xfr": {}, "http": {}, "/v1/hosts/{host}": {}}, true},
+		{map[string]ACL{"recursion": {}}, false},
+		{map[string]ACL{"/v1/hosts": {}}, false},
+		{map[string]ACL{"mesos": {Allow: []string{"10.0.0.0/33"}}}, false},
+		{map[string]ACL{"mesos": {Deny: []string{"localhost"}}}, false},
+	} {
+		if err := validateACLs(tt.acls); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateRRL(t *testing.T) {
+	for i, tt := range []struct {
+		set   func(*Config)
+		valid bool
+	}{
+		{func(*Config) {}, true},
+		{func(c *Config) { c.RRLResponsesPerSecond, c.RRLNXDomainsPerSecond, c.RRLErrorsPerSecond = 5, 5, 5 }, true},
+		{func(c *Config) { c.RRLSlip, c.RRLIPv4PrefixLength, c.RRLIPv6PrefixLength = 0, 32, 128 }, true},
+		{func(c *Config) { c.RRLResponsesPerSecond = -1 }, false},
+		{func(c *Config) { c.RRLErrorsPerSecond = -1 }, false},
+		{func(c *Config) { c.RRLWindow = 0 }, false},
+		{func(c *Config) { c.RRLSlip = 11 }, false},
+		{func(c *Config) { c.RRLIPv4PrefixLength = 33 }, false},
+		{func(c *Config) { c.RRLIPv6PrefixLength = -1 }, false},
+	} {
+		c := NewConfig()
+		tt.set(c)
+		if err := validateRRL(c); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateStubZones(t *testing.T) {
+	for i, tt := range []struct {
+		zones map[string][]string
+		valid bool
+	}{
+		{nil, true},
+		{map[string][]string{}, true},
+		{map[string][]string{"corp.example.com": {"10.0.0.1", "10.0.0.2"}}, true},
+		{map[string][]string{"consul.": {"127.0.0.1"}, "corp.example.com.": {"10.0.0.1"}}, true},
+		{map[string][]string{"corp.example.com": {}}, false},
+		{map[string][]string{"corp.example.com": {"a"}}, false},
+		{map[string][]string{".": {"10.0.0.1"}}, false},
+		{map[string][]string{"": {"10.0.0.1"}}, false},
+		{map[string][]string{"a..b": {"10.0.0.1"}}, false},
+		{map[string][]string{"consul": {"127.0.0.1"}, "Consul.": {"127.0.0.1"}}, false},
+	} {
+		if err := validateStubZones(tt.zones); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
 type validationTest struct {
 	in    []string
 	valid bool
@@ -0,0 +1,106 @@
+package builtin
+
+import (
+	"net"
+
+	"github.com/mesosphere/mesos-dns/exchanger"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// A view is a configured View, with its parsed networks and records.
+type view struct {
+	View
+	nets []*net.IPNet
+	// rg holds the records of the IPSources of the view, if any, as of the
+	// last Reload. It's guarded by the rsLock of the Resolver.
+	rg *records.RecordGenerator
+}
+
+// newViews returns the views of the given Views, ignoring invalid ones.
+func newViews(vs []View) []*view {
+	views := make([]*view, 0, len(vs))
+	for _, v := range vs {
+		nets, err := parseNets(v.CIDRs)
+		if err != nil {
+			logging.Error.Printf("ignoring view %q with invalid CIDRs: %v", v.Name, err)
+			continue
+		}
+		views = append(views, &view{View: v, nets: nets})
+	}
+	return views
+}
+
+// view returns the first view whose networks contain the given address, or
+// nil if there's none.
+func (res *Resolver) view(addr net.Addr) *view {
+	ip := remoteIP(addr)
+	if ip == nil {
+		return nil
+	}
+	for _, v := range res.views {
+		for _, n := range v.nets {
+			if n.Contains(ip) {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// viewRecords returns the current (read-only) record set of the given view,
+// which is the default one if the view is nil or has no IPSources of its
+// own.
+func (res *Resolver) viewRecords(v *view) *records.RecordGenerator {
+	res.rsLock.RLock()
+	defer res.rsLock.RUnlock()
+	if v != nil && v.rg != nil {
+		return v.rg
+	}
+	return res.rg
+}
+
+// generateViews returns the record sets of the views with IPSources of their
+// own, generated from the state of the given RecordGenerator and indexed like
+// the views. Views whose records fail to be generated are answered with the
+// default ones.
+func (res *Resolver) generateViews(rg *records.RecordGenerator) []*records.RecordGenerator {
+	rgs := make([]*records.RecordGenerator, len(res.views))
+	for i, v := range res.views {
+		if len(v.IPSources) == 0 {
+			continue
+		}
+		vrg, err := rg.View(v.IPSources)
+		if err != nil {
+			logging.Error.Printf("failed to generate the records of view %q: %v", v.Name, err)
+			continue
+		}
+		rgs[i] = vrg
+	}
+	return rgs
+}
+
+// recurseOn returns the RecurseOn setting of the given view, which is the
+// configured one if the view is nil or doesn't override it.
+func (res *Resolver) recurseOn(v *view) bool {
+	if v != nil && v.RecurseOn != nil {
+		return *v.RecurseOn
+	}
+	return res.config.RecurseOn
+}
+
+// externalOn returns the ExternalOn setting of the given view, which is the
+// configured one if the view is nil or doesn't override it.
+func (res *Resolver) externalOn(v *view) bool {
+	if v != nil && v.ExternalOn != nil {
+		return *v.ExternalOn
+	}
+	return res.config.ExternalOn
+}
+
+// refused is the Forwarder of clients whose requests aren't forwarded to the
+// ExternalDNS servers, which get REFUSED as if there were none.
+func refused(m *dns.Msg, proto string) (*dns.Msg, error) {
+	return nil, &exchanger.ForwardError{Proto: proto}
+}
@@ -0,0 +1,59 @@
+package builtin
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/miekg/dns"
+)
+
+func TestViews(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.fwd = func(m *dns.Msg, net string) (*dns.Msg, error) {
+		return new(dns.Msg).SetReply(m), nil
+	}
+
+	on, off := true, false
+	res.config.Views = []View{
+		{Name: "corp", CIDRs: []string{"192.168.0.0/16"}, IPSources: []string{"host"}, ExternalOn: &off, RecurseOn: &on},
+		{Name: "ops", CIDRs: []string{"192.168.1.1", "172.16.0.0/12"}, RecurseOn: &on},
+	}
+	res.views = newViews(res.config.Views)
+	res.Reload(res.records())
+
+	for i, tt := range []struct {
+		client    string
+		addrs     []string
+		recursion bool
+		rcode     int // of non-Mesos requests
+	}{
+		{"10.0.0.1", []string{"10.3.0.1", "10.3.0.2"}, false, dns.RcodeSuccess},
+		{"192.168.1.1", []string{"1.2.3.11", "1.2.3.12"}, true, dns.RcodeRefused}, // the first view wins
+		{"172.16.0.1", []string{"10.3.0.1", "10.3.0.2"}, true, dns.RcodeSuccess},
+	} {
+		rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP(tt.client)}}
+		res.HandleMesos(&rw, Message(Question("liquor-store.marathon.mesos.", dns.TypeA)))
+		var addrs []string
+		for _, rr := range rw.Msg.Answer {
+			addrs = append(addrs, rr.(*dns.A).A.String())
+		}
+		sort.Strings(addrs)
+		if !reflect.DeepEqual(addrs, tt.addrs) {
+			t.Errorf("test #%d: got answers %v, want %v", i, addrs, tt.addrs)
+		}
+		if rw.Msg.RecursionAvailable != tt.recursion {
+			t.Errorf("test #%d: got recursion available %t, want %t", i, rw.Msg.RecursionAvailable, tt.recursion)
+		}
+
+		res.HandleNonMesos(&rw, Message(Question("example.com.", dns.TypeA)))
+		if rw.Msg.Rcode != tt.rcode {
+			t.Errorf("test #%d: got rcode %d to non-Mesos request, want %d", i, rw.Msg.Rcode, tt.rcode)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package builtin
+
+import (
+	"net"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// xfrChunk is the number of records sent in each message of a zone transfer.
+const xfrChunk = 100
+
+// An rrset pairs a record set of a RecordGenerator with the func formatting
+// its records.
+type rrset struct {
+	rrs    map[string]map[string]struct{}
+	format func(name, host string) (dns.RR, error)
+}
+
+// rrsets returns all record sets of the given RecordGenerator.
+func (res *Resolver) rrsets(rs *records.RecordGenerator) []rrset {
+	return []rrset{
+		{rs.As, func(name, host string) (dns.RR, error) { return res.formatA(name, host) }},
+		{rs.AAAAs, func(name, host string) (dns.RR, error) { return res.formatAAAA(name, host) }},
+		{rs.SRVs, func(name, host string) (dns.RR, error) { return res.formatSRV(name, host) }},
+		{rs.PTRs, func(name, host string) (dns.RR, error) { return res.formatPTR(name, host), nil }},
+	}
+}
+
+// zoneRRs returns all the records of the given RecordGenerator which belong
+// to the given zone, without its SOA and NS records.
+func (res *Resolver) zoneRRs(rs *records.RecordGenerator, zone string) []dns.RR {
+	var rrs []dns.RR
+	for _, set := range res.rrsets(rs) {
+		for name, hosts := range set.rrs {
+			if !dns.IsSubDomain(zone, name) {
+				continue
+			}
+			for host := range hosts {
+				if rr, err := set.format(name, host); err == nil {
+					rrs = append(rrs, rr)
+				}
+			}
+		}
+	}
+	return rrs
+}
+
+// diff returns the records deleted and added between the old and the new
+// RecordGenerators.
+func (res *Resolver) diff(old, new *records.RecordGenerator) (deleted, added []dns.RR) {
+	olds, news := res.rrsets(old), res.rrsets(new)
+	for i := range olds {
+		deleted = append(deleted, missing(olds[i], news[i].rrs)...)
+		added = append(added, missing(news[i], olds[i].rrs)...)
+	}
+	return deleted, added
+}
+
+// missing returns the formatted records of set which aren't in other.
+func missing(set rrset, other map[string]map[string]struct{}) []dns.RR {
+	var rrs []dns.RR
+	for name, hosts := range set.rrs {
+		for host := range hosts {
+			if _, ok := other[name][host]; ok {
+				continue
+			}
+			if rr, err := set.format(name, host); err == nil {
+				rrs = append(rrs, rr)
+			}
+		}
+	}
+	return rrs
+}
+
+// inZone returns the given records which belong to the given zone.
+func inZone(zone string, rrs []dns.RR) []dns.RR {
+	in := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		if dns.IsSubDomain(zone, rr.Header().Name) {
+			in = append(in, rr)
+		}
+	}
+	return in
+}
+
+// authoritative returns true if the Resolver serves the given zone, i.e. if
+// it's the Mesos domain or one of the configured reverse zones.
+func (res *Resolver) authoritative(zone string) bool {
+	if zone == res.rg.Config.Domain+"." {
+		return true
+	}
+	zones, _ := reverseZones(res.config.ReverseCIDRs)
+	for _, z := range zones {
+		if zone == z {
+			return true
+		}
+	}
+	return false
+}
+
+// xfrAllowed returns true if the given address is allowed to transfer zones,
+// by both XFRAllowed and the ACL of zone transfers.
+func (res *Resolver) xfrAllowed(addr net.Addr) bool {
+	ip := remoteIP(addr)
+	if ip == nil || !res.allowed(aclXFR, addr) {
+		return false
+	}
+	for _, n := range res.xfrNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleXFR answers AXFR (RFC 5936) and IXFR (RFC 1995) requests for the
+// zones the Resolver is authoritative for.
+func (res *Resolver) handleXFR(w dns.ResponseWriter, r *dns.Msg) {
+	zone := strings.ToLower(r.Question[0].Name)
+	qtype := r.Question[0].Qtype
+
+	switch {
+	case !res.xfrAllowed(w.RemoteAddr()):
+		logging.Error.Printf("refused zone transfer of %q to %v", zone, w.RemoteAddr())
+		logging.CurLog.XFRRefused.Inc()
+		reply(w, new(dns.Msg).SetRcode(r, dns.RcodeRefused))
+		return
+	case len(res.config.TSIGKeys) > 0 && !signed(w):
+		logging.Error.Printf("refused unsigned zone transfer of %q to %v", zone, w.RemoteAddr())
+		logging.CurLog.XFRRefused.Inc()
+		reply(w, new(dns.Msg).SetRcode(r, dns.RcodeRefused))
+		return
+	case !res.authoritative(zone):
+		reply(w, new(dns.Msg).SetRcode(r, dns.RcodeNotAuth))
+		return
+	case qtype == dns.TypeAXFR && isUDP(w):
+		// AXFR is only defined over TCP
+		reply(w, new(dns.Msg).SetRcode(r, dns.RcodeRefused))
+		return
+	}
+
+	// the records, their SOA and the journal must be of the same Reload
+	res.rsLock.RLock()
+	rs := res.rg
+	soa := res.formatSOA(zone)
+	var rrs []dns.RR
+	if qtype == dns.TypeIXFR {
+		rrs = res.ixfr(zone, soa, r)
+	}
+	res.rsLock.RUnlock()
+	if rrs == nil {
+		rrs = append([]dns.RR{soa}, res.formatNS(zone))
+		rrs = append(rrs, res.zoneRRs(rs, zone)...)
+		rrs = append(rrs, soa)
+	}
+
+	if isUDP(w) && len(rrs) > 1 {
+		// a single SOA tells the client to retry the IXFR over TCP
+		// See: https://tools.ietf.org/html/rfc1995#section-2
+		rrs = rrs[:1]
+	}
+
+	if err := transfer(w, r, rrs); err != nil {
+		logging.Error.Printf("failed zone transfer of %q to %v: %v", zone, w.RemoteAddr(), err)
+	}
+}
+
+// ixfr returns the records of an incremental transfer of the given zone up
+// to the given SOA from the serial in the request's authority section. a
+// single SOA is returned if the client is up to date and nil if the journal
+// doesn't reach back to its serial, in which case a full transfer is due.
+func (res *Resolver) ixfr(zone string, soa *dns.SOA, r *dns.Msg) []dns.RR {
+	var serial uint32
+	for _, rr := range r.Ns {
+		if s, ok := rr.(*dns.SOA); ok {
+			serial = s.Serial
+		}
+	}
+
+	// RFC 1982 serial number arithmetic
+	if int32(soa.Serial-serial) <= 0 {
+		return []dns.RR{soa}
+	}
+
+	deltas, ok := res.journal.since(serial)
+	if !ok {
+		return nil
+	}
+
+	rrs := []dns.RR{soa}
+	for _, d := range deltas {
+		from, to := *soa, *soa
+		from.Serial, to.Serial = d.from, d.to
+		rrs = append(rrs, &from)
+		rrs = append(rrs, inZone(zone, d.deleted)...)
+		rrs = append(rrs, &to)
+		rrs = append(rrs, inZone(zone, d.added)...)
+	}
+	return append(rrs, soa)
+}
+
+// transfer writes the given records out as a sequence of answers to the
+// given request.
+func transfer(w dns.ResponseWriter, r *dns.Msg, rrs []dns.RR) error {
+	for len(rrs) > 0 {
+		n := xfrChunk
+		if n > len(rrs) {
+			n = len(rrs)
+		}
+
+		m := &dns.Msg{MsgHdr: dns.MsgHdr{Authoritative: true}}
+		m.SetReply(r)
+		m.Compress = true
+		m.Answer, rrs = rrs[:n], rrs[n:]
+		if err := w.WriteMsg(m); err != nil {
+			return err
+		}
+		// subsequent messages are signed with the timers of their TSIG only
+		// See: https://tools.ietf.org/html/rfc2845#section-4.4
+		w.TsigTimersOnly(true)
+	}
+	return nil
+}
+
+// remoteIP returns the IP address of the given net.Addr, or nil if it has
+// none.
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// parseNets parses the given IP addresses and CIDRs into networks. plain IP
+// addresses are parsed as networks holding that address only.
+func parseNets(addrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(addrs))
+	for _, a := range addrs {
+		if !strings.Contains(a, "/") {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: a}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
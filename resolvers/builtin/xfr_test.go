@@ -0,0 +1,220 @@
+package builtin
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/miekg/dns"
+)
+
+func TestHandleXFR(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.config.ReverseCIDRs = []string{"10.3.0.0/24"}
+
+	for i, tt := range []struct {
+		allowed []string
+		remote  string
+		q       *dns.Msg
+		rcode   int
+	}{
+		{nil, "1.2.3.4", new(dns.Msg).SetAxfr("mesos."), dns.RcodeRefused},
+		{[]string{"10.0.0.0/8"}, "1.2.3.4", new(dns.Msg).SetAxfr("mesos."), dns.RcodeRefused},
+		{[]string{"1.2.3.4"}, "1.2.3.4", new(dns.Msg).SetAxfr("example.com."), dns.RcodeNotAuth},
+		{[]string{"1.2.3.4"}, "1.2.3.4", new(dns.Msg).SetAxfr("marathon.mesos."), dns.RcodeNotAuth},
+		{[]string{"1.2.3.0/24"}, "1.2.3.4", new(dns.Msg).SetAxfr("mesos."), dns.RcodeSuccess},
+		{[]string{"1.2.3.4"}, "1.2.3.4", new(dns.Msg).SetAxfr("0.3.10.in-addr.arpa."), dns.RcodeSuccess},
+	} {
+		if res.xfrNets, err = parseNets(tt.allowed); err != nil {
+			t.Fatal(err)
+		}
+		rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP(tt.remote)}}
+		res.HandleMesos(&rw, tt.q)
+		if got := rw.Msg.Rcode; got != tt.rcode {
+			t.Errorf("test #%d: got rcode %d, want %d", i, got, tt.rcode)
+		}
+	}
+}
+
+func TestAXFR(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.xfrNets, err = parseNets([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("127.0.0.1")}}
+	res.HandleMesos(&rw, new(dns.Msg).SetAxfr("mesos."))
+
+	var rrs []dns.RR
+	for _, m := range rw.Msgs {
+		if !m.Authoritative || m.Rcode != dns.RcodeSuccess {
+			t.Fatalf("unexpected transfer message header: %+v", m.MsgHdr)
+		}
+		rrs = append(rrs, m.Answer...)
+	}
+	if len(rw.Msgs) < 2 {
+		t.Errorf("expected the transfer to span several messages, got %d", len(rw.Msgs))
+	}
+
+	rg := res.records()
+	want := 4 // SOA, NS, ..., SOA
+	for _, set := range []map[string]map[string]struct{}{rg.As, rg.AAAAs, rg.SRVs} {
+		for _, hosts := range set {
+			want += len(hosts)
+		}
+	}
+	if len(rrs) != want-1 { // one A record of a slave isn't an IP
+		t.Errorf("got %d records, want %d", len(rrs), want-1)
+	}
+	for _, i := range []int{0, len(rrs) - 1} {
+		if rrs[i].Header().Rrtype != dns.TypeSOA {
+			t.Errorf("record #%d: got %v, want SOA", i, rrs[i])
+		}
+	}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypePTR {
+			t.Errorf("unexpected record out of zone: %v", rr)
+		}
+	}
+}
+
+func TestIXFR(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.xfrNets, err = parseNets([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	res.serial = 0
+
+	// the next generation loses the nginx task
+	sj := res.records().State
+	for i := range sj.Frameworks {
+		var tasks []state.Task
+		for _, task := range sj.Frameworks[i].Tasks {
+			if task.Name != "nginx" {
+				tasks = append(tasks, task)
+			}
+		}
+		sj.Frameworks[i].Tasks = tasks
+	}
+	config := res.records().Config
+	rg := records.NewRecordGenerator(config)
+	err = rg.InsertState(sj, "mesos", "mesos-dns.mesos.", config.Masters, config.IPSources, labels.RFC952)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rg.UpdateSerial(res.records())
+	res.Reload(rg)
+	serial := res.serial
+	if serial == 0 {
+		t.Fatal("expected the serial to be bumped")
+	}
+
+	ixfr := func(serial uint32) []dns.RR {
+		rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("127.0.0.1")}}
+		res.HandleMesos(&rw, new(dns.Msg).SetIxfr("mesos.", serial, "ns1.mesos.", "root.ns1.mesos."))
+		var rrs []dns.RR
+		for _, m := range rw.Msgs {
+			rrs = append(rrs, m.Answer...)
+		}
+		return rrs
+	}
+
+	// up to date
+	if rrs := ixfr(serial); len(rrs) != 1 || rrs[0].(*dns.SOA).Serial != serial {
+		t.Errorf("up to date: got %v, want a single SOA", rrs)
+	}
+
+	// incremental
+	rrs := ixfr(0)
+	serials := []uint32{}
+	for _, rr := range rrs {
+		switch rr := rr.(type) {
+		case *dns.SOA:
+			serials = append(serials, rr.Serial)
+		default:
+			if !strings.HasPrefix(rr.Header().Name, "nginx") {
+				t.Errorf("unexpected change: %v", rr)
+			}
+			if len(serials) != 2 {
+				t.Errorf("unexpected addition: %v", rr)
+			}
+		}
+	}
+	if want := []uint32{serial, 0, serial, serial}; len(serials) != len(want) ||
+		serials[0] != want[0] || serials[1] != want[1] || serials[2] != want[2] || serials[3] != want[3] {
+		t.Errorf("got SOA serials %v, want %v", serials, want)
+	}
+	if len(rrs) != 4+4 { // AAAA and slave A records of both task names
+		t.Errorf("got %d records, want %d", len(rrs), 8)
+	}
+
+	// serials the journal doesn't reach back to fall back to a full transfer
+	res.journal = journal{}
+	if rrs := ixfr(0); len(rrs) < 10 || rrs[1].Header().Rrtype != dns.TypeNS {
+		t.Errorf("unknown serial: got %d records, want a full transfer", len(rrs))
+	}
+}
+
+func TestJournal(t *testing.T) {
+	var j journal
+	for i := uint32(0); i < journalSize+2; i++ {
+		j.add(delta{from: i, to: i + 1})
+	}
+	if _, ok := j.since(1); ok {
+		t.Error("expected the oldest deltas to be discarded")
+	}
+	if ds, ok := j.since(journalSize); !ok || len(ds) != 2 {
+		t.Errorf("got %d deltas (%t), want 2", len(ds), ok)
+	}
+
+	// a gap in the serials resets the journal
+	j.add(delta{from: 100, to: 101})
+	if _, ok := j.since(journalSize); ok {
+		t.Error("expected the journal to be reset")
+	}
+	if ds, ok := j.since(100); !ok || len(ds) != 1 {
+		t.Errorf("got %d deltas (%t), want 1", len(ds), ok)
+	}
+}
+
+func TestParseNets(t *testing.T) {
+	nets, err := parseNets([]string{"1.2.3.4", "10.0.0.0/8", "fd01::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range []struct {
+		ip   string
+		want bool
+	}{
+		{"1.2.3.4", true},
+		{"1.2.3.5", false},
+		{"10.20.30.40", true},
+		{"fd01::1", true},
+		{"fd01::2", false},
+	} {
+		var got bool
+		for _, n := range nets {
+			got = got || n.Contains(net.ParseIP(tt.ip))
+		}
+		if got != tt.want {
+			t.Errorf("test #%d: %s: got %t, want %t", i, tt.ip, got, tt.want)
+		}
+	}
+
+	if _, err := parseNets([]string{"1.2.3"}); err == nil {
+		t.Error("expected an error parsing an invalid address")
+	}
+}
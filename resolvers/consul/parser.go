@@ -85,7 +85,7 @@ func generateFrameworkRecords(ch chan Record, rg *records.RecordGenerator, prefi
 		// Pull sanitized framework host + port values
 		frameworkHost, frameworkPort := framework.HostPort()
 
-		// :(  records.hostToIP4 would be super
+		// :(  records.hostToIP would be super
 		if frameworkHost == "" {
 			continue
 		}
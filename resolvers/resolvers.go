@@ -14,6 +14,18 @@ type Resolver interface {
 	Reload(rg *records.RecordGenerator)
 }
 
+// A LeaderSetter is a Resolver told about the leading Mesos master, as
+// detected. The leader is empty if there's none.
+type LeaderSetter interface {
+	SetLeader(leader string)
+}
+
+// A FailureSetter is a Resolver told about the failures to refresh its
+// records, which it keeps serving until the next Reload.
+type FailureSetter interface {
+	SetFailure(err error)
+}
+
 func New(errch chan error, rg *records.RecordGenerator, version string) []Resolver {
 	var resolvers []Resolver
 
@@ -25,6 +37,10 @@ func New(errch chan error, rg *records.RecordGenerator, version string) []Resolv
 			utils.Merge(v, conf)
 			// Print out here after the merge to show the merged config
 			logging.VeryVerbose.Printf("Initializing resolver %s with config %+v\n", k, conf)
+			if err := conf.SetConfig(); err != nil {
+				go func() { errch <- err }()
+				continue
+			}
 			resolvers = append(resolvers, builtin.New(conf, errch, rg, version))
 		case "consul":
 			conf := consul.NewConfig()
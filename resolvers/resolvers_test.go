@@ -30,10 +30,71 @@ func TestResolvers(t *testing.T) {
 			"DNSOn":      false,
 			"ExternalOn": false,
 			"HTTPOn":     false},
-			true},
+			false},
 		{map[string]interface{}{
 			"NotReal": "fake, fake",
 			"Port":    25353},
+			true},
+		{map[string]interface{}{
+			"NotifyTSIGKey": "unknown."},
+			false},
+		{map[string]interface{}{
+			"TSIGKeys":        []interface{}{map[string]interface{}{"Name": "key.", "Algorithm": "hmac-foo", "Secret": "c2VjcmV0"}},
+			"ExternalTSIGKey": "key."},
+			false},
+		{map[string]interface{}{
+			"ACLs": map[string]interface{}{"mesoss": map[string]interface{}{"Allow": []interface{}{"10.0.0.0/8"}}}},
+			false},
+		{map[string]interface{}{
+			"ACLs": map[string]interface{}{"mesos": map[string]interface{}{"Allow": []interface{}{"10.0.0.0/33"}}}},
+			false},
+		{map[string]interface{}{
+			"UpstreamPolicy": "fastest"},
+			false},
+		{map[string]interface{}{
+			"Views": []interface{}{
+				map[string]interface{}{"Name": "internal", "CIDRs": []interface{}{"10.0.0.0/8"}},
+				map[string]interface{}{"Name": "internal", "CIDRs": []interface{}{"192.168.0.0/16"}}}},
+			false},
+		{map[string]interface{}{
+			"Views": []interface{}{map[string]interface{}{"CIDRs": []interface{}{"10.0.0.0/8"}}}},
+			false},
+		{map[string]interface{}{
+			"Views": []interface{}{map[string]interface{}{"Name": "internal", "CIDRs": []interface{}{"10.0.0/8"}}}},
+			false},
+		{map[string]interface{}{
+			"Views": []interface{}{map[string]interface{}{"Name": "internal", "CIDRs": []interface{}{"10.0.0.0/8"}, "IPSources": []interface{}{"nethost"}}}},
+			false},
+		{map[string]interface{}{
+			"XFRAllowed": []interface{}{"10.0.0.1/33"}},
+			false},
+		{map[string]interface{}{
+			"StubZones": map[string]interface{}{"corp.example.com": []interface{}{"not an address"}}},
+			false},
+		{map[string]interface{}{
+			"UpstreamUDPSize": 100},
+			false},
+		{map[string]interface{}{
+			"UDPSize": 100},
+			false},
+		{map[string]interface{}{
+			"CookieSecret": "c2VjcmV0"},
+			false},
+		{map[string]interface{}{
+			"RRLWindow": 0},
+			false},
+		{map[string]interface{}{
+			"ReverseCIDRs": []interface{}{"10.0.0.0"}},
+			false},
+		{map[string]interface{}{
+			"NotifySecondaries": []interface{}{"10.0.0.1:port"}},
+			false},
+		{map[string]interface{}{
+			"DoTPort": 8853},
+			false},
+		{map[string]interface{}{
+			"CacheSize":   1000,
+			"CacheMaxTTL": 0},
 			false},
 	}
 
@@ -44,8 +105,8 @@ func TestResolvers(t *testing.T) {
 		} else {
 			c.Resolvers = nil
 		}
-		if err := CreateResolvers(c); err != nil {
-			t.Fatalf("Error returned from resolvers: %v", err)
+		if err := CreateResolvers(c); (err == nil) != tc.Valid {
+			t.Fatalf("Got error %v from resolvers, want valid %v", err, tc.Valid)
 		}
 	}
 }
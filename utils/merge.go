@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 
@@ -13,7 +14,7 @@ func Merge(data interface{}, config interface{}) {
 			err := SetField(config, k, v)
 			// May want to do more than just log these errors
 			if err != nil {
-				logging.Verbose.Printf("Error merging config key %v:", k, err)
+				logging.Verbose.Printf("Error merging config key %v: %v", k, err)
 			}
 		}
 	}
@@ -45,13 +46,15 @@ func SetField(obj interface{}, name string, value interface{}) error {
 				return err
 			}
 			structFieldValue.Set(reflect.ValueOf(newVal))
-		case "slice":
-			// slice is a tricky one. Sticking to float64 and strings for now
-			if v, ok := value.([]float64); ok {
-				structFieldValue.Set(reflect.ValueOf(v))
-			} else if v, ok := value.([]string); ok {
-				structFieldValue.Set(reflect.ValueOf(v))
+		case "slice", "map":
+			// JSON decodes arrays and objects into []interface{} and
+			// map[string]interface{}, so convert them by round-tripping
+			// through JSON into the type of the field.
+			newVal, err := ConvertJSON(value, structFieldType)
+			if err != nil {
+				return err
 			}
+			structFieldValue.Set(newVal)
 		default:
 			return fmt.Errorf("Passed value (type %s) cannot be used for field (type %s)", val.Type(), structFieldType)
 		}
@@ -62,6 +65,20 @@ func SetField(obj interface{}, name string, value interface{}) error {
 	return nil
 }
 
+// ConvertJSON converts the given value into a value of type typ by encoding
+// it to JSON and decoding the result.
+func ConvertJSON(value interface{}, typ reflect.Type) (reflect.Value, error) {
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	ptr := reflect.New(typ)
+	if err = json.Unmarshal(bs, ptr.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("Passed value (type %T) cannot be used for field (type %s): %v", value, typ, err)
+	}
+	return ptr.Elem(), nil
+}
+
 func ConvertNum(val float64, obj interface{}, name string) (interface{}, error) {
 	// This is the same reflection process from SetField()
 	structValue := reflect.ValueOf(obj)
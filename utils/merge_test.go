@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+func init() {
+	logging.SetupLogs()
+}
+
+func TestMerge(t *testing.T) {
+	type config struct {
+		Port    int
+		Servers []string
+		Zones   map[string][]string
+	}
+
+	var c config
+	Merge(map[string]interface{}{
+		"Port":    float64(53),
+		"Servers": []interface{}{"1.2.3.4", "5.6.7.8"},
+		"Zones":   map[string]interface{}{"corp.": []interface{}{"10.0.0.1"}},
+		"NotReal": "fake",
+	}, &c)
+
+	want := config{
+		Port:    53,
+		Servers: []string{"1.2.3.4", "5.6.7.8"},
+		Zones:   map[string][]string{"corp.": {"10.0.0.1"}},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+}
+
+func TestSetFieldMismatch(t *testing.T) {
+	var c struct{ Servers []string }
+	if err := SetField(&c, "Servers", []interface{}{1.0}); err == nil {
+		t.Error("expected an error setting a []string field to numbers")
+	}
+}
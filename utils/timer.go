@@ -0,0 +1,15 @@
+package utils
+
+import "time"
+
+// ResetTimer resets the given timer to expire after the given duration,
+// draining it first if it already expired.
+func ResetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResetTimer(t *testing.T) {
+	timer := time.NewTimer(0)
+	time.Sleep(10 * time.Millisecond) // expired, undrained
+
+	ResetTimer(timer, time.Hour)
+	select {
+	case <-timer.C:
+		t.Error("got the expiry preceding the reset")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	ResetTimer(timer, 0)
+	select {
+	case <-timer.C:
+	case <-time.After(time.Second):
+		t.Error("the timer didn't expire after the reset")
+	}
+}